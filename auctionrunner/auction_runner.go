@@ -1,7 +1,9 @@
 package auctionrunner
 
 import (
+	"encoding/json"
 	"os"
+	"sync"
 	"time"
 
 	"code.cloudfoundry.org/clock"
@@ -9,6 +11,7 @@ import (
 
 	"code.cloudfoundry.org/auction/auctiontypes"
 	"code.cloudfoundry.org/auctioneer"
+	"code.cloudfoundry.org/rep"
 	"code.cloudfoundry.org/workpool"
 )
 
@@ -18,11 +21,28 @@ type auctionRunner struct {
 	delegate                      auctiontypes.AuctionRunnerDelegate
 	metricEmitter                 auctiontypes.AuctionMetricEmitterDelegate
 	batch                         *Batch
+	batchCapacity                 int
+	overflowPolicy                OverflowPolicy
+	wal                           *WriteAheadLog
+	taskGroup                     func(task *rep.Task) string
+	taskGroupingKey               func(task *rep.Task) string
+	queueAges                     *queueAgeTracker
+	cellStateCache                *CellStateCache
+	partition                     CellPartition
+	schedulerOptions              []SchedulerOption
+	pipelined                     bool
+	inFlightCommit                chan reservationLedger
+	shutdownMode                  ShutdownMode
+	shutdownDeadline              time.Duration
 	clock                         clock.Clock
 	workPool                      *workpool.WorkPool
 	binPackFirstFitWeight         float64
 	startingContainerWeight       float64
 	startingContainerCountMaximum int
+
+	// completionLock keeps the delegate from being told about completed
+	// auctions by the runner and its pipelined commits at the same time.
+	completionLock *sync.Mutex
 }
 
 func New(
@@ -34,102 +54,519 @@ func New(
 	binPackFirstFitWeight float64,
 	startingContainerWeight float64,
 	startingContainerCountMaximum int,
+	options ...Option,
 ) *auctionRunner {
-	return &auctionRunner{
+	runner := &auctionRunner{
 		logger:                        logger,
 		delegate:                      delegate,
 		metricEmitter:                 metricEmitter,
-		batch:                         NewBatch(clock),
+		queueAges:                     newQueueAgeTracker(clock, 0, 0),
 		clock:                         clock,
 		workPool:                      workPool,
 		binPackFirstFitWeight:         binPackFirstFitWeight,
 		startingContainerWeight:       startingContainerWeight,
 		startingContainerCountMaximum: startingContainerCountMaximum,
+		completionLock:                &sync.Mutex{},
+	}
+
+	for _, option := range options {
+		option(runner)
+	}
+
+	runner.batch = NewBoundedBatch(clock, runner.batchCapacity, runner.overflowPolicy)
+	runner.batch.wal = runner.wal
+	runner.batch.taskGroup = runner.taskGroup
+	runner.batch.taskGroupingKey = runner.taskGroupingKey
+
+	return runner
+}
+
+type Option func(*auctionRunner)
+
+// ShutdownMode decides what happens to the auctions still in the batch when
+// the runner is signalled.
+type ShutdownMode int
+
+const (
+	// ShutdownImmediately returns right away, dropping any pending auctions.
+	ShutdownImmediately ShutdownMode = iota
+	// ShutdownDrain holds one final auction for the pending work and hands back
+	// anything that is still pending.  A final auction that has not drained the
+	// batch by the shutdown deadline is abandoned, and the pending work handed
+	// back without waiting for it to return; one that has is waited for, as the
+	// work it drained is being committed to the cells.
+	ShutdownDrain
+	// ShutdownHandBack reports every pending auction to the delegate as failed
+	// with ErrorShuttingDown so that a standby auctioneer can pick it up.
+	ShutdownHandBack
+)
+
+// WithGracefulShutdown sets how pending auctions are dealt with on shutdown.
+// The deadline only applies to ShutdownDrain.
+func WithGracefulShutdown(mode ShutdownMode, deadline time.Duration) Option {
+	return func(a *auctionRunner) {
+		a.shutdownMode = mode
+		a.shutdownDeadline = deadline
+	}
+}
+
+// WithMaxQueueAge fails auctions that have been waiting longer than the given
+// age with ErrorAuctionExpired.  Failed auctions keep their original QueueTime
+// and Attempts when they are resubmitted within twice the age, and start afresh
+// after that.  A zero age means no limit.
+func WithMaxQueueAge(maxLRPQueueAge, maxTaskQueueAge time.Duration) Option {
+	return func(a *auctionRunner) {
+		a.queueAges = newQueueAgeTracker(a.clock, maxLRPQueueAge, maxTaskQueueAge)
+	}
+}
+
+// WithBatchCapacity bounds the number of auctions waiting in the batch.  Work
+// that does not fit is handled according to the overflow policy.
+func WithBatchCapacity(capacity int, overflowPolicy OverflowPolicy) Option {
+	return func(a *auctionRunner) {
+		a.batchCapacity = capacity
+		a.overflowPolicy = overflowPolicy
+	}
+}
+
+// WithWriteAheadLog journals the batch so that auctions which were queued but
+// not completed are replayed when the runner starts.
+func WithWriteAheadLog(wal *WriteAheadLog) Option {
+	return func(a *auctionRunner) {
+		a.wal = wal
+	}
+}
+
+// WithTaskGroups puts each submitted task in the task group group returns for
+// it, if any, so that the tasks of a group are placed together on one cell or
+// fail together.  The batch queues, evicts, expires and cancels the tasks of a
+// group together too.  Shard runners need their shard router built
+// WithShardTaskGroups as well, so that a group is not split between shards.
+func WithTaskGroups(group func(task *rep.Task) string) Option {
+	return func(a *auctionRunner) {
+		a.taskGroup = group
+	}
+}
+
+// WithTaskGroupingKeys gives each submitted task the grouping key key returns
+// for it, if any, so that the tasks sharing a key are balanced across zones.
+func WithTaskGroupingKeys(key func(task *rep.Task) string) Option {
+	return func(a *auctionRunner) {
+		a.taskGroupingKey = key
+	}
+}
+
+// WithCellStateCache builds the zones of each auction from the cache instead
+// of fetching the state of every cell.  The cache must be run separately to be
+// refreshed in the background.
+func WithCellStateCache(cache *CellStateCache) Option {
+	return func(a *auctionRunner) {
+		a.cellStateCache = cache
+	}
+}
+
+// WithCellPartition restricts the runner to the cells of the partition.  Cells
+// returned by the delegate that the partition does not own are ignored.
+func WithCellPartition(partition CellPartition) Option {
+	return func(a *auctionRunner) {
+		a.partition = partition
+	}
+}
+
+// WithSchedulerOptions passes the options to the scheduler of every auction.
+func WithSchedulerOptions(options ...SchedulerOption) Option {
+	return func(a *auctionRunner) {
+		a.schedulerOptions = append(a.schedulerOptions, options...)
+	}
+}
+
+// WithPipelining lets the next auction fetch cell states while the work of the
+// previous auction is still being committed.  The next auction waits for that
+// commit to finish before placing any work, and accounts for the committed
+// work that the fetched states do not show yet.
+func WithPipelining() Option {
+	return func(a *auctionRunner) {
+		a.pipelined = true
 	}
 }
 
 func (a *auctionRunner) Run(signals <-chan os.Signal, ready chan<- struct{}) error {
+	if a.wal != nil {
+		a.replayWriteAheadLog()
+	}
+
 	close(ready)
 
 	var hasWork chan struct{}
 	hasWork = a.batch.HasWork
 
 	for {
+		select {
+		case <-signals:
+			a.shutdown()
+			return nil
+		default:
+		}
+
 		select {
 		case <-hasWork:
-			logger := a.logger.Session("auction")
+			a.reportTurnedAwayAuctions()
+			if !a.batch.hasPendingWork() {
+				hasWork = a.batch.HasWork
+				break
+			}
 
-			logger.Info("fetching-cell-reps")
-			clients, err := a.delegate.FetchCellReps()
+			err := a.auction(nil)
 			if err != nil {
-				logger.Error("failed-to-fetch-reps", err)
 				time.Sleep(time.Second)
 				hasWork = make(chan struct{}, 1)
 				hasWork <- struct{}{}
 				break
 			}
-			logger.Info("fetched-cell-reps", lager.Data{"cell-reps-count": len(clients)})
 
 			hasWork = a.batch.HasWork
+		case <-signals:
+			a.shutdown()
+			return nil
+		}
+	}
+}
 
-			logger.Info("fetching-zone-state")
-			fetchStatesStartTime := time.Now()
-			zones := FetchStateAndBuildZones(logger, a.workPool, clients, a.metricEmitter, a.binPackFirstFitWeight)
-			fetchStateDuration := time.Since(fetchStatesStartTime)
-			err = a.metricEmitter.FetchStatesCompleted(fetchStateDuration)
-			if err != nil {
-				logger.Error("failed-sending-fetch-states-completed-metric", err)
-			}
+// auction holds a single auction for the work in the batch.  It only returns
+// an error when the cell reps could not be fetched, in which case the batch is
+// left untouched.  claim, if any, is asked right before the batch is drained,
+// and the batch is left untouched as well when it declines.
+func (a *auctionRunner) auction(claim func() bool) error {
+	logger := a.logger.Session("auction")
 
-			cellCount := 0
-			for zone, cells := range zones {
-				logger.Info("zone-state", lager.Data{"zone": zone, "cell-count": len(cells)})
-				cellCount += len(cells)
-			}
-			logger.Info("fetched-zone-state", lager.Data{
-				"cell-state-count":    cellCount,
-				"num-failed-requests": len(clients) - cellCount,
-				"duration":            fetchStateDuration.String(),
-			})
-
-			logger.Info("fetching-auctions")
-			lrpAuctions, taskAuctions := a.batch.DedupeAndDrain()
-			logger.Info("fetched-auctions", lager.Data{
-				"lrp-start-auctions": len(lrpAuctions),
-				"task-auctions":      len(taskAuctions),
-			})
-			if len(lrpAuctions) == 0 && len(taskAuctions) == 0 {
-				logger.Info("nothing-to-auction")
+	logger.Info("fetching-cell-reps")
+	clients, err := a.delegate.FetchCellReps()
+	if err != nil {
+		logger.Error("failed-to-fetch-reps", err)
+		return err
+	}
+	logger.Info("fetched-cell-reps", lager.Data{"cell-reps-count": len(clients)})
+
+	if a.partition != nil {
+		clients = partitionClients(a.partition, clients)
+	}
+
+	logger.Info("fetching-zone-state")
+	fetchStatesStartTime := time.Now()
+	var zones map[string]Zone
+	if a.cellStateCache != nil {
+		zones = a.cellStateCache.BuildZones(logger, clients, a.binPackFirstFitWeight)
+	} else {
+		zones = FetchStateAndBuildZones(logger, a.workPool, clients, a.metricEmitter, a.binPackFirstFitWeight)
+	}
+	fetchStateDuration := time.Since(fetchStatesStartTime)
+	err = a.metricEmitter.FetchStatesCompleted(fetchStateDuration)
+	if err != nil {
+		logger.Error("failed-sending-fetch-states-completed-metric", err)
+	}
+
+	cellCount := 0
+	for zone, cells := range zones {
+		logger.Info("zone-state", lager.Data{"zone": zone, "cell-count": len(cells)})
+		cellCount += len(cells)
+	}
+	logger.Info("fetched-zone-state", lager.Data{
+		"cell-state-count":    cellCount,
+		"num-failed-requests": len(clients) - cellCount,
+		"duration":            fetchStateDuration.String(),
+	})
+
+	if a.partition != nil {
+		zones = partitionZones(a.partition, zones)
+		ownedCellCount := 0
+		for _, cells := range zones {
+			ownedCellCount += len(cells)
+		}
+		logger.Info("partitioned-zone-state", lager.Data{"owned-cell-count": ownedCellCount})
+	}
+
+	ledger := a.waitForInFlightCommit(logger)
+	ledger.apply(zones)
+
+	if claim != nil && !claim() {
+		logger.Info("abandoned")
+		return nil
+	}
+
+	logger.Info("fetching-auctions")
+	lrpAuctions, taskAuctions := a.batch.DedupeAndDrain()
+	lrpAuctions, taskAuctions, expiredResults := a.queueAges.Expire(lrpAuctions, taskAuctions)
+	logger.Info("fetched-auctions", lager.Data{
+		"lrp-start-auctions":         len(lrpAuctions),
+		"task-auctions":              len(taskAuctions),
+		"expired-lrp-start-auctions": len(expiredResults.FailedLRPs),
+		"expired-task-auctions":      len(expiredResults.FailedTasks),
+	})
+	if len(lrpAuctions) == 0 && len(taskAuctions) == 0 {
+		logger.Info("nothing-to-auction")
+		if len(expiredResults.FailedLRPs) > 0 || len(expiredResults.FailedTasks) > 0 {
+			a.auctionCompleted(expiredResults)
+		}
+		return nil
+	}
+
+	logger.Info("scheduling")
+	auctionRequest := auctiontypes.AuctionRequest{
+		LRPs:  lrpAuctions,
+		Tasks: taskAuctions,
+	}
+
+	scheduler := NewScheduler(a.workPool, zones, a.clock, logger, a.binPackFirstFitWeight, a.startingContainerWeight, a.startingContainerCountMaximum, a.schedulerOptions...)
+	if !a.pipelined {
+		a.scheduled(logger, zones, scheduler.Schedule(auctionRequest), expiredResults)
+		return nil
+	}
+
+	placement := scheduler.place(auctionRequest)
+	committed := make(chan reservationLedger, 1)
+	a.inFlightCommit = committed
+	go func() {
+		a.scheduled(logger, zones, scheduler.commit(placement), expiredResults)
+		committed <- newReservationLedger(zones)
+	}()
+	return nil
+}
+
+func (a *auctionRunner) scheduled(logger lager.Logger, zones map[string]Zone, auctionResults, expiredResults auctiontypes.AuctionResults) {
+	if a.cellStateCache != nil {
+		a.cellStateCache.Record(zones)
+	}
+	logger.Info("scheduled", lager.Data{
+		"successful-lrp-start-auctions": len(auctionResults.SuccessfulLRPs),
+		"successful-task-auctions":      len(auctionResults.SuccessfulTasks),
+		"failed-lrp-start-auctions":     len(auctionResults.FailedLRPs),
+		"failed-task-auctions":          len(auctionResults.FailedTasks),
+	})
+
+	auctionResults.FailedLRPs = append(auctionResults.FailedLRPs, expiredResults.FailedLRPs...)
+	auctionResults.FailedTasks = append(auctionResults.FailedTasks, expiredResults.FailedTasks...)
+	a.auctionCompleted(auctionResults)
+}
+
+// waitForInFlightCommit waits for the work of a pipelined auction to be
+// committed and returns the work the cells may have accepted.
+func (a *auctionRunner) waitForInFlightCommit(logger lager.Logger) reservationLedger {
+	if a.inFlightCommit == nil {
+		return reservationLedger{}
+	}
+
+	logger.Info("waiting-for-in-flight-commit")
+	ledger := <-a.inFlightCommit
+	a.inFlightCommit = nil
+	return ledger
+}
+
+func (a *auctionRunner) shutdown() {
+	logger := a.logger.Session("shutdown")
+	a.batch.Close()
+	a.waitForInFlightCommit(logger)
+	defer a.reportTurnedAwayAuctions()
+
+	switch a.shutdownMode {
+	case ShutdownDrain:
+		if !a.batch.hasPendingWork() {
+			break
+		}
+
+		logger.Info("holding-final-auction", lager.Data{"deadline": a.shutdownDeadline.String()})
+		final := &finalAuction{lock: &sync.Mutex{}}
+		finished := make(chan struct{})
+		go func() {
+			a.auction(final.claim)
+			a.waitForInFlightCommit(logger)
+			close(finished)
+		}()
+
+		timer := a.clock.NewTimer(a.shutdownDeadline)
+		select {
+		case <-finished:
+			timer.Stop()
+			logger.Info("held-final-auction")
+		case <-timer.C():
+			// once abandoned, the final auction no longer touches the batch
+			if final.abandon() {
+				logger.Info("abandoning-final-auction")
 				break
 			}
+			logger.Info("final-auction-exceeded-deadline")
+			<-finished
+		}
 
-			logger.Info("scheduling")
-			auctionRequest := auctiontypes.AuctionRequest{
-				LRPs:  lrpAuctions,
-				Tasks: taskAuctions,
-			}
+		a.handBackPendingAuctions(logger)
+	case ShutdownHandBack:
+		a.handBackPendingAuctions(logger)
+	}
+}
 
-			scheduler := NewScheduler(a.workPool, zones, a.clock, logger, a.binPackFirstFitWeight, a.startingContainerWeight, a.startingContainerCountMaximum)
-			auctionResults := scheduler.Schedule(auctionRequest)
-			logger.Info("scheduled", lager.Data{
-				"successful-lrp-start-auctions": len(auctionResults.SuccessfulLRPs),
-				"successful-task-auctions":      len(auctionResults.SuccessfulTasks),
-				"failed-lrp-start-auctions":     len(auctionResults.FailedLRPs),
-				"failed-task-auctions":          len(auctionResults.FailedTasks),
-			})
-
-			a.metricEmitter.AuctionCompleted(auctionResults)
-			a.delegate.AuctionCompleted(auctionResults)
-		case <-signals:
-			return nil
-		}
+// finalAuction settles whether the final auction of a draining shutdown gets
+// to drain the batch before the deadline abandons it.
+type finalAuction struct {
+	claimed   bool
+	abandoned bool
+	lock      *sync.Mutex
+}
+
+func (f *finalAuction) claim() bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.claimed = !f.abandoned
+	return f.claimed
+}
+
+func (f *finalAuction) abandon() bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.abandoned = !f.claimed
+	return f.abandoned
+}
+
+// handBackPendingAuctions reports every auction left in the batch as failed so
+// that another auctioneer can pick them up.
+func (a *auctionRunner) handBackPendingAuctions(logger lager.Logger) {
+	lrpAuctions, taskAuctions := a.batch.DedupeAndDrain()
+	if len(lrpAuctions) == 0 && len(taskAuctions) == 0 {
+		return
+	}
+
+	results := auctiontypes.AuctionResults{
+		FailedLRPs:  lrpAuctions,
+		FailedTasks: taskAuctions,
+	}
+	for i := range results.FailedLRPs {
+		results.FailedLRPs[i].PlacementError = auctiontypes.ErrorShuttingDown.Error()
+	}
+	for i := range results.FailedTasks {
+		results.FailedTasks[i].PlacementError = auctiontypes.ErrorShuttingDown.Error()
+	}
+
+	logger.Info("handing-back-pending-auctions", lager.Data{
+		"lrp-start-auctions": len(results.FailedLRPs),
+		"task-auctions":      len(results.FailedTasks),
+	})
+	a.auctionCompleted(results)
+}
+
+// ScheduleLRPsForAuctions returns ErrorBatchFull when the batch could not hold
+// all of the work, and ErrorShuttingDown once the runner is shutting down.
+// Every auction the batch turned away, which when dropping the oldest work are
+// previously queued auctions, is reported to the delegate as failed by the
+// running runner, never by the caller.
+func (a *auctionRunner) ScheduleLRPsForAuctions(lrpStarts []auctioneer.LRPStartRequest) error {
+	return a.batch.AddLRPStarts(lrpStarts)
+}
+
+func (a *auctionRunner) ScheduleTasksForAuctions(tasks []auctioneer.TaskStartRequest) error {
+	return a.batch.AddTasks(tasks)
+}
+
+// reportTurnedAwayAuctions reports the auctions the batch turned away as
+// failed.
+func (a *auctionRunner) reportTurnedAwayAuctions() {
+	results := a.batch.DrainTurnedAway()
+	if len(results.FailedLRPs) == 0 && len(results.FailedTasks) == 0 {
+		return
+	}
+
+	a.logger.Info("batch-full", lager.Data{
+		"rejected-lrp-start-auctions": len(results.FailedLRPs),
+		"rejected-task-auctions":      len(results.FailedTasks),
+	})
+	a.reportResults(results)
+}
+
+func (a *auctionRunner) replayWriteAheadLog() {
+	logger := a.logger.Session("replay-write-ahead-log")
+
+	lrpAuctions, taskAuctions, err := a.wal.Replay()
+	if err != nil {
+		logger.Error("failed-to-replay", err)
+		return
+	}
+
+	logger.Info("replayed", lager.Data{
+		"lrp-start-auctions": len(lrpAuctions),
+		"task-auctions":      len(taskAuctions),
+	})
+	err = a.batch.requeue(lrpAuctions, taskAuctions)
+	if err != nil {
+		logger.Error("failed-to-requeue-all-auctions", err)
+	}
+}
+
+func (a *auctionRunner) auctionCompleted(results auctiontypes.AuctionResults) {
+	if a.wal != nil {
+		a.wal.RecordResults(results)
+	}
+	a.reportResults(results)
+}
+
+// reportResults reports completed auctions without touching the write-ahead
+// log.  The batch completes the log entries of the auctions it turns away
+// itself, as a rejected auction never had one.
+func (a *auctionRunner) reportResults(results auctiontypes.AuctionResults) {
+	a.completionLock.Lock()
+	defer a.completionLock.Unlock()
+
+	a.queueAges.Record(results)
+	a.metricEmitter.AuctionCompleted(results)
+	a.delegate.AuctionCompleted(results)
+}
+
+func (a *auctionRunner) PendingAuctions() auctiontypes.AuctionRequest {
+	lrpAuctions, taskAuctions := a.batch.Pending()
+	return auctiontypes.AuctionRequest{
+		LRPs:  lrpAuctions,
+		Tasks: taskAuctions,
+	}
+}
+
+// ExportPendingAuctions serializes the auctions waiting in the batch, along
+// with their AuctionRecords, so that a new leader can import them.  The batch
+// is left untouched.
+func (a *auctionRunner) ExportPendingAuctions() ([]byte, error) {
+	return json.Marshal(a.PendingAuctions())
+}
+
+// ImportPendingAuctions queues auctions exported by another auction runner.
+// They keep their queue time and attempts.  Imported work that does not fit in
+// the batch is turned away in the same way ScheduleLRPsForAuctions turns it
+// away.
+func (a *auctionRunner) ImportPendingAuctions(payload []byte) error {
+	var pending auctiontypes.AuctionRequest
+	err := json.Unmarshal(payload, &pending)
+	if err != nil {
+		a.logger.Error("failed-to-import-pending-auctions", err)
+		return err
+	}
+
+	a.logger.Info("importing-pending-auctions", lager.Data{
+		"lrp-start-auctions": len(pending.LRPs),
+		"task-auctions":      len(pending.Tasks),
+	})
+
+	err = a.batch.AddLRPAuctions(pending.LRPs)
+	taskErr := a.batch.AddTaskAuctions(pending.Tasks)
+	if err == nil {
+		err = taskErr
 	}
+	return err
 }
 
-func (a *auctionRunner) ScheduleLRPsForAuctions(lrpStarts []auctioneer.LRPStartRequest) {
-	a.batch.AddLRPStarts(lrpStarts)
+func (a *auctionRunner) CancelLRPAuctions(processGuid string, indices []int) []auctiontypes.LRPAuction {
+	cancelled := a.batch.CancelLRPStarts(processGuid, indices)
+	a.logger.Info("cancelled-lrp-auctions", lager.Data{"process-guid": processGuid, "indices": indices, "cancelled-count": len(cancelled)})
+	return cancelled
 }
 
-func (a *auctionRunner) ScheduleTasksForAuctions(tasks []auctioneer.TaskStartRequest) {
-	a.batch.AddTasks(tasks)
+func (a *auctionRunner) CancelTaskAuctions(taskGuids []string) []auctiontypes.TaskAuction {
+	cancelled := a.batch.CancelTasks(taskGuids)
+	a.logger.Info("cancelled-task-auctions", lager.Data{"task-guids": taskGuids, "cancelled-count": len(cancelled)})
+	return cancelled
 }
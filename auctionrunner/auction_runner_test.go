@@ -0,0 +1,916 @@
+package auctionrunner_test
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"code.cloudfoundry.org/auction/auctionrunner"
+	"code.cloudfoundry.org/auction/auctiontypes"
+	"code.cloudfoundry.org/auction/auctiontypes/fakes"
+	"code.cloudfoundry.org/auctioneer"
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/rep"
+	"code.cloudfoundry.org/rep/repfakes"
+	"code.cloudfoundry.org/workpool"
+	"github.com/tedsuo/ifrit"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("AuctionRunner", func() {
+	var (
+		clock         *fakeclock.FakeClock
+		workPool      *workpool.WorkPool
+		delegate      *fakes.FakeAuctionRunnerDelegate
+		metricEmitter *fakes.FakeAuctionMetricEmitterDelegate
+		options       []auctionrunner.Option
+		runner        auctiontypes.AuctionRunner
+	)
+
+	BeforeEach(func() {
+		clock = fakeclock.NewFakeClock(time.Now())
+
+		var err error
+		workPool, err = workpool.NewWorkPool(5)
+		Expect(err).NotTo(HaveOccurred())
+
+		delegate = &fakes.FakeAuctionRunnerDelegate{}
+		metricEmitter = &fakes.FakeAuctionMetricEmitterDelegate{}
+		options = nil
+	})
+
+	JustBeforeEach(func() {
+		runner = auctionrunner.New(logger, delegate, metricEmitter, clock, workPool, 0.0, 0.0, 0, options...)
+	})
+
+	AfterEach(func() {
+		workPool.Stop()
+	})
+
+	Describe("scheduling work into a full batch", func() {
+		BeforeEach(func() {
+			options = append(options, auctionrunner.WithBatchCapacity(1, auctionrunner.RejectNewest))
+		})
+
+		JustBeforeEach(func() {
+			err := runner.ScheduleTasksForAuctions([]auctioneer.TaskStartRequest{
+				BuildTaskStartRequest("tg-1", "domain", "linux", 10, 10, 10),
+			})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("tells the submitter the work was rejected", func() {
+			err := runner.ScheduleLRPsForAuctions([]auctioneer.LRPStartRequest{
+				BuildLRPStartRequest("pg-1", "domain", []int{0}, "linux", 10, 10, 10, []string{}, []string{}),
+			})
+			Expect(err).To(Equal(auctiontypes.ErrorBatchFull))
+		})
+
+		It("reports the rejected auctions as failed from the runner", func() {
+			runner.ScheduleLRPsForAuctions([]auctioneer.LRPStartRequest{
+				BuildLRPStartRequest("pg-1", "domain", []int{0}, "linux", 10, 10, 10, []string{}, []string{}),
+			})
+			Expect(delegate.AuctionCompletedCallCount()).To(Equal(0))
+
+			process := ifrit.Invoke(runner)
+			defer func() {
+				process.Signal(os.Interrupt)
+				Eventually(process.Wait()).Should(Receive())
+			}()
+
+			Eventually(delegate.AuctionCompletedCallCount).Should(BeNumerically(">=", 1))
+			results := delegate.AuctionCompletedArgsForCall(0)
+			Expect(results.SuccessfulLRPs).To(BeEmpty())
+			Expect(results.FailedTasks).To(BeEmpty())
+			Expect(results.FailedLRPs).To(HaveLen(1))
+			Expect(results.FailedLRPs[0].Identifier()).To(Equal("pg-1.0"))
+			Expect(results.FailedLRPs[0].PlacementError).To(Equal(auctiontypes.ErrorBatchFull.Error()))
+
+			Expect(metricEmitter.AuctionCompletedCallCount()).To(BeNumerically(">=", 1))
+			Expect(metricEmitter.AuctionCompletedArgsForCall(0)).To(Equal(results))
+		})
+
+		It("does not report anything when the work fits", func() {
+			runner.CancelTaskAuctions([]string{"tg-1"})
+			err := runner.ScheduleLRPsForAuctions([]auctioneer.LRPStartRequest{
+				BuildLRPStartRequest("pg-1", "domain", []int{0}, "linux", 10, 10, 10, []string{}, []string{}),
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(delegate.AuctionCompletedCallCount()).To(Equal(0))
+		})
+
+		Context("when dropping the oldest work", func() {
+			BeforeEach(func() {
+				options = []auctionrunner.Option{auctionrunner.WithBatchCapacity(1, auctionrunner.DropOldest)}
+			})
+
+			It("accepts the new work", func() {
+				err := runner.ScheduleLRPsForAuctions([]auctioneer.LRPStartRequest{
+					BuildLRPStartRequest("pg-1", "domain", []int{0}, "linux", 10, 10, 10, []string{}, []string{}),
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				pending := runner.PendingAuctions()
+				Expect(pending.LRPs).To(HaveLen(1))
+				Expect(pending.Tasks).To(BeEmpty())
+			})
+		})
+
+		Context("when blocking until the batch is drained", func() {
+			BeforeEach(func() {
+				options = []auctionrunner.Option{auctionrunner.WithBatchCapacity(1, auctionrunner.BlockUntilDrained)}
+			})
+
+			It("refuses the waiting work once the runner stops", func() {
+				scheduled := make(chan error)
+				go func() {
+					scheduled <- runner.ScheduleLRPsForAuctions([]auctioneer.LRPStartRequest{
+						BuildLRPStartRequest("pg-1", "domain", []int{0}, "linux", 10, 10, 10, []string{}, []string{}),
+					})
+				}()
+				Consistently(scheduled).ShouldNot(Receive())
+
+				signals := make(chan os.Signal, 1)
+				signals <- os.Interrupt
+				Expect(runner.Run(signals, make(chan struct{}, 1))).To(Succeed())
+
+				var err error
+				Eventually(scheduled).Should(Receive(&err))
+				Expect(err).To(Equal(auctiontypes.ErrorShuttingDown))
+			})
+		})
+	})
+
+	Describe("auctioning from a cell state cache", func() {
+		var (
+			client  *repfakes.FakeSimClient
+			process ifrit.Process
+		)
+
+		BeforeEach(func() {
+			client = &repfakes.FakeSimClient{}
+			client.StateReturns(BuildCellState("cell", 0, "the-zone", 100, 100, 10, false, 0, linuxOnlyRootFSProviders, nil, nil, nil, nil, 0), nil)
+			delegate.FetchCellRepsReturns(map[string]rep.Client{"cell": client}, nil)
+
+			cache := auctionrunner.NewCellStateCache(logger, workPool, metricEmitter, clock, time.Minute)
+			options = append(options, auctionrunner.WithCellStateCache(cache))
+		})
+
+		JustBeforeEach(func() {
+			process = ifrit.Invoke(runner)
+		})
+
+		AfterEach(func() {
+			process.Signal(os.Interrupt)
+			Eventually(process.Wait()).Should(Receive())
+		})
+
+		It("only fetches the cell state when it is not cached", func() {
+			runner.ScheduleLRPsForAuctions([]auctioneer.LRPStartRequest{
+				BuildLRPStartRequest("pg-1", "domain", []int{0}, linuxRootFSURL, 60, 10, 10, []string{}, []string{}),
+			})
+			Eventually(delegate.AuctionCompletedCallCount).Should(Equal(1))
+			Expect(delegate.AuctionCompletedArgsForCall(0).SuccessfulLRPs).To(HaveLen(1))
+
+			runner.ScheduleLRPsForAuctions([]auctioneer.LRPStartRequest{
+				BuildLRPStartRequest("pg-2", "domain", []int{0}, linuxRootFSURL, 60, 10, 10, []string{}, []string{}),
+			})
+			Eventually(delegate.AuctionCompletedCallCount).Should(Equal(2))
+
+			results := delegate.AuctionCompletedArgsForCall(1)
+			Expect(results.FailedLRPs).To(HaveLen(1))
+			Expect(results.FailedLRPs[0].PlacementError).To(Equal("insufficient resources: memory"))
+			Expect(client.StateCallCount()).To(Equal(1))
+		})
+	})
+
+	Describe("auctioning a partition of the cells", func() {
+		var (
+			ownedClient, otherClient *repfakes.FakeSimClient
+			process                  ifrit.Process
+		)
+
+		BeforeEach(func() {
+			ownedClient = &repfakes.FakeSimClient{}
+			ownedClient.StateReturns(BuildCellState("owned", 0, "z1", 100, 100, 10, false, 0, linuxOnlyRootFSProviders, nil, nil, nil, nil, 0), nil)
+			otherClient = &repfakes.FakeSimClient{}
+			otherClient.StateReturns(BuildCellState("other", 0, "z2", 1000, 1000, 100, false, 0, linuxOnlyRootFSProviders, nil, nil, nil, nil, 0), nil)
+			delegate.FetchCellRepsReturns(map[string]rep.Client{"owned": ownedClient, "other": otherClient}, nil)
+
+			options = append(options, auctionrunner.WithCellPartition(auctionrunner.NewZonePartition("z1")))
+		})
+
+		JustBeforeEach(func() {
+			process = ifrit.Invoke(runner)
+		})
+
+		AfterEach(func() {
+			process.Signal(os.Interrupt)
+			Eventually(process.Wait()).Should(Receive())
+		})
+
+		It("only places work on the cells it owns", func() {
+			runner.ScheduleLRPsForAuctions([]auctioneer.LRPStartRequest{
+				BuildLRPStartRequest("pg-1", "domain", []int{0, 1}, linuxRootFSURL, 10, 10, 10, []string{}, []string{}),
+			})
+			Eventually(delegate.AuctionCompletedCallCount).Should(Equal(1))
+			Expect(delegate.AuctionCompletedArgsForCall(0).SuccessfulLRPs).To(HaveLen(2))
+
+			Expect(ownedClient.PerformCallCount()).To(Equal(1))
+			Expect(otherClient.PerformCallCount()).To(Equal(0))
+		})
+	})
+
+	Describe("co-scheduling task groups", func() {
+		var process ifrit.Process
+
+		BeforeEach(func() {
+			clients := map[string]rep.Client{}
+			for _, name := range []string{"cell-a", "cell-b"} {
+				client := &repfakes.FakeSimClient{}
+				client.StateReturns(BuildCellState(name, 0, "the-zone", 100, 100, 10, false, 0, linuxOnlyRootFSProviders, nil, nil, nil, nil, 0), nil)
+				clients[name] = client
+			}
+			delegate.FetchCellRepsReturns(clients, nil)
+
+			options = append(options, auctionrunner.WithTaskGroups(func(task *rep.Task) string { return task.Domain }))
+		})
+
+		JustBeforeEach(func() {
+			process = ifrit.Invoke(runner)
+		})
+
+		AfterEach(func() {
+			process.Signal(os.Interrupt)
+			Eventually(process.Wait()).Should(Receive())
+		})
+
+		It("places the tasks of a group on the same cell", func() {
+			runner.ScheduleTasksForAuctions([]auctioneer.TaskStartRequest{
+				BuildTaskStartRequest("tg-1", "workflow", linuxRootFSURL, 10, 10, 10),
+				BuildTaskStartRequest("tg-2", "workflow", linuxRootFSURL, 10, 10, 10),
+			})
+			Eventually(delegate.AuctionCompletedCallCount).Should(Equal(1))
+
+			results := delegate.AuctionCompletedArgsForCall(0)
+			Expect(results.SuccessfulTasks).To(HaveLen(2))
+			Expect(results.SuccessfulTasks[0].TaskGroup).To(Equal("workflow"))
+			Expect(results.SuccessfulTasks[0].Winner).To(Equal(results.SuccessfulTasks[1].Winner))
+		})
+	})
+
+	Describe("balancing tasks by grouping key", func() {
+		var process ifrit.Process
+
+		BeforeEach(func() {
+			bigClient := &repfakes.FakeSimClient{}
+			bigClient.StateReturns(BuildCellState("big-cell", 0, "z1", 1000, 1000, 100, false, 0, linuxOnlyRootFSProviders, nil, nil, nil, nil, 0), nil)
+			smallClient := &repfakes.FakeSimClient{}
+			smallClient.StateReturns(BuildCellState("small-cell", 1, "z2", 100, 100, 100, false, 0, linuxOnlyRootFSProviders, nil, nil, nil, nil, 0), nil)
+			delegate.FetchCellRepsReturns(map[string]rep.Client{"big-cell": bigClient, "small-cell": smallClient}, nil)
+
+			options = append(options, auctionrunner.WithTaskGroupingKeys(func(task *rep.Task) string { return task.Domain }))
+		})
+
+		JustBeforeEach(func() {
+			process = ifrit.Invoke(runner)
+		})
+
+		AfterEach(func() {
+			process.Signal(os.Interrupt)
+			Eventually(process.Wait()).Should(Receive())
+		})
+
+		It("balances the submitted tasks sharing a grouping key across zones", func() {
+			runner.ScheduleTasksForAuctions([]auctioneer.TaskStartRequest{
+				BuildTaskStartRequest("tg-1", "job-1", linuxRootFSURL, 10, 10, 10),
+				BuildTaskStartRequest("tg-2", "job-1", linuxRootFSURL, 10, 10, 10),
+			})
+			Eventually(delegate.AuctionCompletedCallCount).Should(Equal(1))
+
+			results := delegate.AuctionCompletedArgsForCall(0)
+			Expect(results.SuccessfulTasks).To(HaveLen(2))
+			Expect(results.SuccessfulTasks[0].GroupingKey).To(Equal("job-1"))
+			Expect(results.SuccessfulTasks[0].Winner).NotTo(Equal(results.SuccessfulTasks[1].Winner))
+		})
+	})
+
+	Describe("pipelining auctions", func() {
+		var (
+			client        *repfakes.FakeSimClient
+			releaseCommit chan struct{}
+			process       ifrit.Process
+		)
+
+		BeforeEach(func() {
+			client = &repfakes.FakeSimClient{}
+			client.StateReturns(BuildCellState("cell", 0, "the-zone", 100, 100, 10, false, 0, linuxOnlyRootFSProviders, nil, nil, nil, nil, 0), nil)
+			delegate.FetchCellRepsReturns(map[string]rep.Client{"cell": client}, nil)
+
+			releaseCommit = make(chan struct{})
+			client.PerformStub = func(lager.Logger, rep.Work) (rep.Work, error) {
+				<-releaseCommit
+				return rep.Work{}, nil
+			}
+
+			options = append(options, auctionrunner.WithPipelining())
+		})
+
+		JustBeforeEach(func() {
+			process = ifrit.Invoke(runner)
+
+			runner.ScheduleLRPsForAuctions([]auctioneer.LRPStartRequest{
+				BuildLRPStartRequest("pg-1", "domain", []int{0}, linuxRootFSURL, 60, 10, 10, []string{}, []string{}),
+			})
+			Eventually(client.PerformCallCount).Should(Equal(1))
+		})
+
+		AfterEach(func() {
+			process.Signal(os.Interrupt)
+			Eventually(process.Wait()).Should(Receive())
+		})
+
+		scheduleWhileCommitting := func(memoryMB int32) auctiontypes.AuctionResults {
+			runner.ScheduleLRPsForAuctions([]auctioneer.LRPStartRequest{
+				BuildLRPStartRequest("pg-2", "domain", []int{0}, linuxRootFSURL, memoryMB, 10, 10, []string{}, []string{}),
+			})
+			Eventually(client.StateCallCount).Should(Equal(2))
+			Expect(delegate.AuctionCompletedCallCount()).To(Equal(0))
+
+			close(releaseCommit)
+			Eventually(delegate.AuctionCompletedCallCount).Should(Equal(2))
+			return delegate.AuctionCompletedArgsForCall(1)
+		}
+
+		It("fetches the cell states for the next auction while committing the current one", func() {
+			results := scheduleWhileCommitting(10)
+			Expect(results.SuccessfulLRPs).To(HaveLen(1))
+		})
+
+		It("accounts for the work that is still being committed", func() {
+			results := scheduleWhileCommitting(60)
+			Expect(delegate.AuctionCompletedArgsForCall(0).SuccessfulLRPs).To(HaveLen(1))
+			Expect(results.FailedLRPs).To(HaveLen(1))
+			Expect(results.FailedLRPs[0].PlacementError).To(Equal("insufficient resources: memory"))
+		})
+
+		Context("when the fetched state already shows the committed work", func() {
+			BeforeEach(func() {
+				lrp := BuildLRP("pg-1", "domain", 0, linuxRootFSURL, 60, 10, 10, []string{})
+				client.StateReturnsOnCall(1, BuildCellState("cell", 0, "the-zone", 100, 100, 10, false, 0, linuxOnlyRootFSProviders, []rep.LRP{*lrp}, nil, nil, nil, 0), nil)
+			})
+
+			It("does not count it twice", func() {
+				results := scheduleWhileCommitting(30)
+				Expect(results.SuccessfulLRPs).To(HaveLen(1))
+			})
+		})
+
+		Context("when the cell rejects the committed work", func() {
+			BeforeEach(func() {
+				client.PerformStub = func(_ lager.Logger, work rep.Work) (rep.Work, error) {
+					<-releaseCommit
+					if client.PerformCallCount() == 1 {
+						return work, nil
+					}
+					return rep.Work{}, nil
+				}
+			})
+
+			It("does not hold on to it", func() {
+				results := scheduleWhileCommitting(60)
+				Expect(delegate.AuctionCompletedArgsForCall(0).FailedLRPs).To(HaveLen(1))
+				Expect(results.SuccessfulLRPs).To(HaveLen(1))
+			})
+		})
+	})
+
+	Describe("expiring auctions", func() {
+		var (
+			client  *repfakes.FakeSimClient
+			process ifrit.Process
+		)
+
+		BeforeEach(func() {
+			client = &repfakes.FakeSimClient{}
+			client.StateReturns(BuildCellState("cell", 0, "the-zone", 50, 50, 10, false, 0, linuxOnlyRootFSProviders, nil, nil, nil, nil, 0), nil)
+			delegate.FetchCellRepsReturns(map[string]rep.Client{"cell": client}, nil)
+
+			options = append(options, auctionrunner.WithMaxQueueAge(time.Minute, 0))
+		})
+
+		JustBeforeEach(func() {
+			process = ifrit.Invoke(runner)
+		})
+
+		AfterEach(func() {
+			process.Signal(os.Interrupt)
+			Eventually(process.Wait()).Should(Receive())
+		})
+
+		completedAuction := func(call int) auctiontypes.AuctionResults {
+			Eventually(delegate.AuctionCompletedCallCount).Should(Equal(call + 1))
+			return delegate.AuctionCompletedArgsForCall(call)
+		}
+
+		It("keeps the original queue time and attempts of resubmitted auctions until they expire", func() {
+			queueTime := clock.Now()
+			bigLRP := BuildLRPStartRequest("pg-1", "domain", []int{0}, linuxRootFSURL, 100, 10, 10, []string{}, []string{})
+
+			runner.ScheduleLRPsForAuctions([]auctioneer.LRPStartRequest{bigLRP})
+			results := completedAuction(0)
+			Expect(results.FailedLRPs).To(HaveLen(1))
+			Expect(results.FailedLRPs[0].Attempts).To(Equal(1))
+			Expect(results.FailedLRPs[0].PlacementError).To(Equal("insufficient resources: memory"))
+
+			clock.Increment(40 * time.Second)
+			runner.ScheduleLRPsForAuctions([]auctioneer.LRPStartRequest{bigLRP})
+			results = completedAuction(1)
+			Expect(results.FailedLRPs).To(HaveLen(1))
+			Expect(results.FailedLRPs[0].Attempts).To(Equal(2))
+			Expect(results.FailedLRPs[0].QueueTime).To(BeTemporally("==", queueTime))
+
+			clock.Increment(40 * time.Second)
+			runner.ScheduleLRPsForAuctions([]auctioneer.LRPStartRequest{bigLRP})
+			results = completedAuction(2)
+			Expect(results.FailedLRPs).To(HaveLen(1))
+			Expect(results.FailedLRPs[0].Attempts).To(Equal(2))
+			Expect(results.FailedLRPs[0].QueueTime).To(BeTemporally("==", queueTime))
+			Expect(results.FailedLRPs[0].PlacementError).To(Equal(auctiontypes.ErrorAuctionExpired.Error()))
+			Expect(client.PerformCallCount()).To(Equal(0))
+		})
+
+		It("expires auctions that are resubmitted less often than the maximum age", func() {
+			bigLRP := BuildLRPStartRequest("pg-1", "domain", []int{0}, linuxRootFSURL, 100, 10, 10, []string{}, []string{})
+
+			runner.ScheduleLRPsForAuctions([]auctioneer.LRPStartRequest{bigLRP})
+			completedAuction(0)
+
+			clock.Increment(70 * time.Second)
+			runner.ScheduleLRPsForAuctions([]auctioneer.LRPStartRequest{
+				BuildLRPStartRequest("pg-2", "domain", []int{0}, linuxRootFSURL, 10, 10, 10, []string{}, []string{}),
+			})
+			completedAuction(1)
+
+			runner.ScheduleLRPsForAuctions([]auctioneer.LRPStartRequest{bigLRP})
+			results := completedAuction(2)
+			Expect(results.FailedLRPs).To(HaveLen(1))
+			Expect(results.FailedLRPs[0].PlacementError).To(Equal(auctiontypes.ErrorAuctionExpired.Error()))
+		})
+
+		It("starts afresh once an expired auction is submitted again", func() {
+			bigLRP := BuildLRPStartRequest("pg-1", "domain", []int{0}, linuxRootFSURL, 100, 10, 10, []string{}, []string{})
+
+			runner.ScheduleLRPsForAuctions([]auctioneer.LRPStartRequest{bigLRP})
+			completedAuction(0)
+			clock.Increment(50 * time.Second)
+			runner.ScheduleLRPsForAuctions([]auctioneer.LRPStartRequest{bigLRP})
+			completedAuction(1)
+			clock.Increment(50 * time.Second)
+			runner.ScheduleLRPsForAuctions([]auctioneer.LRPStartRequest{bigLRP})
+			Expect(completedAuction(2).FailedLRPs[0].PlacementError).To(Equal(auctiontypes.ErrorAuctionExpired.Error()))
+
+			runner.ScheduleLRPsForAuctions([]auctioneer.LRPStartRequest{bigLRP})
+			results := completedAuction(3)
+			Expect(results.FailedLRPs[0].Attempts).To(Equal(1))
+			Expect(results.FailedLRPs[0].QueueTime).To(BeTemporally("==", clock.Now()))
+		})
+
+		It("forgets auctions that are not resubmitted within twice the maximum age", func() {
+			bigLRP := BuildLRPStartRequest("pg-1", "domain", []int{0}, linuxRootFSURL, 100, 10, 10, []string{}, []string{})
+
+			runner.ScheduleLRPsForAuctions([]auctioneer.LRPStartRequest{bigLRP})
+			completedAuction(0)
+
+			clock.Increment(130 * time.Second)
+			runner.ScheduleLRPsForAuctions([]auctioneer.LRPStartRequest{
+				BuildLRPStartRequest("pg-2", "domain", []int{0}, linuxRootFSURL, 10, 10, 10, []string{}, []string{}),
+			})
+			completedAuction(1)
+
+			runner.ScheduleLRPsForAuctions([]auctioneer.LRPStartRequest{bigLRP})
+			results := completedAuction(2)
+			Expect(results.FailedLRPs).To(HaveLen(1))
+			Expect(results.FailedLRPs[0].Attempts).To(Equal(1))
+			Expect(results.FailedLRPs[0].PlacementError).To(Equal("insufficient resources: memory"))
+		})
+
+		It("does not expire auctions of a type without a maximum age", func() {
+			task := BuildTaskStartRequest("tg-1", "domain", linuxRootFSURL, 100, 10, 10)
+
+			runner.ScheduleTasksForAuctions([]auctioneer.TaskStartRequest{task})
+			completedAuction(0)
+			clock.Increment(2 * time.Minute)
+			runner.ScheduleTasksForAuctions([]auctioneer.TaskStartRequest{task})
+			results := completedAuction(1)
+			Expect(results.FailedTasks).To(HaveLen(1))
+			Expect(results.FailedTasks[0].Attempts).To(Equal(1))
+			Expect(results.FailedTasks[0].PlacementError).To(Equal("insufficient resources: memory"))
+		})
+
+		Context("when co-scheduling task groups", func() {
+			BeforeEach(func() {
+				options = append(options,
+					auctionrunner.WithMaxQueueAge(0, time.Minute),
+					auctionrunner.WithTaskGroups(func(task *rep.Task) string { return task.Domain }),
+				)
+			})
+
+			It("expires the whole group once one of its tasks expires", func() {
+				bigTask := BuildTaskStartRequest("tg-1", "workflow", linuxRootFSURL, 100, 10, 10)
+
+				runner.ScheduleTasksForAuctions([]auctioneer.TaskStartRequest{bigTask})
+				completedAuction(0)
+
+				clock.Increment(70 * time.Second)
+				runner.ScheduleTasksForAuctions([]auctioneer.TaskStartRequest{
+					bigTask,
+					BuildTaskStartRequest("tg-2", "workflow", linuxRootFSURL, 10, 10, 10),
+				})
+				results := completedAuction(1)
+				Expect(results.FailedTasks).To(HaveLen(2))
+				for _, task := range results.FailedTasks {
+					Expect(task.PlacementError).To(Equal(auctiontypes.ErrorAuctionExpired.Error()))
+				}
+				Expect(client.PerformCallCount()).To(Equal(0))
+			})
+		})
+	})
+
+	Describe("journaling the batch", func() {
+		var (
+			tmpDir string
+			path   string
+			wal    *auctionrunner.WriteAheadLog
+		)
+
+		BeforeEach(func() {
+			var err error
+			tmpDir, err = ioutil.TempDir("", "auction-runner")
+			Expect(err).NotTo(HaveOccurred())
+			path = filepath.Join(tmpDir, "batch.wal")
+
+			wal, err = auctionrunner.OpenWriteAheadLog(logger, path)
+			Expect(err).NotTo(HaveOccurred())
+			options = append(options, auctionrunner.WithWriteAheadLog(wal))
+		})
+
+		AfterEach(func() {
+			wal.Close()
+			os.RemoveAll(tmpDir)
+		})
+
+		It("replays auctions that were queued before a restart with their original queue time", func() {
+			queueTime := clock.Now().Add(-time.Minute)
+			wal.RecordLRPAuctions([]auctiontypes.LRPAuction{
+				BuildLRPAuction("pg-1", "domain", 0, linuxRootFSURL, 10, 10, 10, queueTime, []string{}, []string{}),
+			})
+
+			client := &repfakes.FakeSimClient{}
+			client.StateReturns(BuildCellState("cell", 0, "the-zone", 100, 100, 10, false, 0, linuxOnlyRootFSProviders, nil, nil, nil, nil, 0), nil)
+			delegate.FetchCellRepsReturns(map[string]rep.Client{"cell": client}, nil)
+
+			process := ifrit.Invoke(runner)
+			defer func() {
+				process.Signal(os.Interrupt)
+				Eventually(process.Wait()).Should(Receive())
+			}()
+
+			Eventually(delegate.AuctionCompletedCallCount).Should(Equal(1))
+			results := delegate.AuctionCompletedArgsForCall(0)
+			Expect(results.SuccessfulLRPs).To(HaveLen(1))
+			Expect(results.SuccessfulLRPs[0].QueueTime).To(BeTemporally("==", queueTime))
+			Expect(results.SuccessfulLRPs[0].WaitDuration).To(Equal(time.Minute))
+
+			info, err := os.Stat(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info.Size()).To(BeZero())
+		})
+
+		It("journals queued auctions until they are cancelled", func() {
+			runner.ScheduleLRPsForAuctions([]auctioneer.LRPStartRequest{
+				BuildLRPStartRequest("pg-1", "domain", []int{0, 1}, linuxRootFSURL, 10, 10, 10, []string{}, []string{}),
+			})
+			runner.ScheduleTasksForAuctions([]auctioneer.TaskStartRequest{
+				BuildTaskStartRequest("tg-1", "domain", linuxRootFSURL, 10, 10, 10),
+			})
+			runner.CancelLRPAuctions("pg-1", []int{1})
+
+			lrpAuctions, taskAuctions, err := wal.Replay()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(lrpAuctions).To(HaveLen(1))
+			Expect(lrpAuctions[0].Identifier()).To(Equal("pg-1.0"))
+			Expect(taskAuctions).To(HaveLen(1))
+			Expect(taskAuctions[0].Identifier()).To(Equal("tg-1"))
+		})
+
+		Context("when the batch is full", func() {
+			BeforeEach(func() {
+				options = append(options, auctionrunner.WithBatchCapacity(1, auctionrunner.RejectNewest))
+			})
+
+			It("keeps journaling a queued auction when a duplicate of it is rejected", func() {
+				delegate.FetchCellRepsReturns(nil, errors.New("boom"))
+				process := ifrit.Invoke(runner)
+				defer func() {
+					process.Signal(os.Interrupt)
+					Eventually(process.Wait()).Should(Receive())
+				}()
+
+				lrpStart := BuildLRPStartRequest("pg-1", "domain", []int{0}, linuxRootFSURL, 10, 10, 10, []string{}, []string{})
+				Expect(runner.ScheduleLRPsForAuctions([]auctioneer.LRPStartRequest{lrpStart})).To(Succeed())
+				Expect(runner.ScheduleLRPsForAuctions([]auctioneer.LRPStartRequest{lrpStart})).To(Equal(auctiontypes.ErrorBatchFull))
+
+				Eventually(delegate.AuctionCompletedCallCount).Should(Equal(1))
+				Expect(delegate.AuctionCompletedArgsForCall(0).FailedLRPs).To(HaveLen(1))
+
+				lrpAuctions, _, err := wal.Replay()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(lrpAuctions).To(HaveLen(1))
+				Expect(lrpAuctions[0].Identifier()).To(Equal("pg-1.0"))
+			})
+		})
+
+		Context("when blocking until the batch is drained", func() {
+			BeforeEach(func() {
+				options = append(options, auctionrunner.WithBatchCapacity(1, auctionrunner.BlockUntilDrained))
+			})
+
+			It("replays more auctions than the batch can hold without waiting for room", func() {
+				wal.RecordLRPAuctions([]auctiontypes.LRPAuction{
+					BuildLRPAuction("pg-1", "domain", 0, linuxRootFSURL, 10, 10, 10, clock.Now(), []string{}, []string{}),
+					BuildLRPAuction("pg-1", "domain", 1, linuxRootFSURL, 10, 10, 10, clock.Now(), []string{}, []string{}),
+					BuildLRPAuction("pg-1", "domain", 2, linuxRootFSURL, 10, 10, 10, clock.Now(), []string{}, []string{}),
+				})
+
+				client := &repfakes.FakeSimClient{}
+				client.StateReturns(BuildCellState("cell", 0, "the-zone", 100, 100, 10, false, 0, linuxOnlyRootFSProviders, nil, nil, nil, nil, 0), nil)
+				delegate.FetchCellRepsReturns(map[string]rep.Client{"cell": client}, nil)
+
+				process := ifrit.Background(runner)
+				defer func() {
+					process.Signal(os.Interrupt)
+					Eventually(process.Wait()).Should(Receive())
+				}()
+
+				Eventually(process.Ready()).Should(BeClosed())
+				Eventually(delegate.AuctionCompletedCallCount).Should(Equal(1))
+				Expect(delegate.AuctionCompletedArgsForCall(0).SuccessfulLRPs).To(HaveLen(3))
+			})
+		})
+	})
+
+	Describe("handing pending auctions over to a new leader", func() {
+		var (
+			queueTime        time.Time
+			newLeaderOptions []auctionrunner.Option
+			newLeader        auctiontypes.AuctionRunner
+		)
+
+		BeforeEach(func() {
+			queueTime = clock.Now()
+			newLeaderOptions = nil
+		})
+
+		JustBeforeEach(func() {
+			newLeader = auctionrunner.New(logger, delegate, metricEmitter, clock, workPool, 0.0, 0.0, 0, newLeaderOptions...)
+
+			runner.ScheduleLRPsForAuctions([]auctioneer.LRPStartRequest{
+				BuildLRPStartRequest("pg-1", "domain", []int{0}, "linux", 10, 10, 10, []string{}, []string{}),
+			})
+			clock.Increment(time.Minute)
+			runner.ScheduleTasksForAuctions([]auctioneer.TaskStartRequest{
+				BuildTaskStartRequest("tg-1", "domain", "linux", 10, 10, 10),
+			})
+			clock.Increment(time.Minute)
+		})
+
+		It("preserves their queue times", func() {
+			exported, err := runner.ExportPendingAuctions()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(newLeader.ImportPendingAuctions(exported)).To(Succeed())
+
+			imported := newLeader.PendingAuctions()
+			Expect(imported.LRPs).To(HaveLen(1))
+			Expect(imported.LRPs[0].Identifier()).To(Equal("pg-1.0"))
+			Expect(imported.LRPs[0].QueueTime.Equal(queueTime)).To(BeTrue())
+			Expect(imported.Tasks).To(HaveLen(1))
+			Expect(imported.Tasks[0].Identifier()).To(Equal("tg-1"))
+			Expect(imported.Tasks[0].QueueTime.Equal(queueTime.Add(time.Minute))).To(BeTrue())
+		})
+
+		It("preserves their attempts", func() {
+			task := BuildTaskAuction(BuildTask("tg-2", "domain", "linux", 10, 10, 10, []string{}, []string{}), queueTime)
+			task.Attempts = 4
+			payload, err := json.Marshal(auctiontypes.AuctionRequest{Tasks: []auctiontypes.TaskAuction{task}})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(runner.ImportPendingAuctions(payload)).To(Succeed())
+
+			exported, err := runner.ExportPendingAuctions()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(newLeader.ImportPendingAuctions(exported)).To(Succeed())
+
+			imported := newLeader.PendingAuctions()
+			Expect(imported.Tasks).To(HaveLen(2))
+			Expect(imported.Tasks[1].Identifier()).To(Equal("tg-2"))
+			Expect(imported.Tasks[1].Attempts).To(Equal(4))
+		})
+
+		It("leaves the exported auctions in the batch", func() {
+			_, err := runner.ExportPendingAuctions()
+			Expect(err).NotTo(HaveOccurred())
+
+			pending := runner.PendingAuctions()
+			Expect(pending.LRPs).To(HaveLen(1))
+			Expect(pending.Tasks).To(HaveLen(1))
+		})
+
+		It("fails to import a malformed payload", func() {
+			Expect(newLeader.ImportPendingAuctions([]byte("{"))).NotTo(Succeed())
+			Expect(newLeader.PendingAuctions().LRPs).To(BeEmpty())
+		})
+
+		Context("when the new leader cannot hold all of the work", func() {
+			BeforeEach(func() {
+				newLeaderOptions = append(newLeaderOptions, auctionrunner.WithBatchCapacity(1, auctionrunner.RejectNewest))
+			})
+
+			It("reports the auctions that did not fit", func() {
+				exported, err := runner.ExportPendingAuctions()
+				Expect(err).NotTo(HaveOccurred())
+
+				err = newLeader.ImportPendingAuctions(exported)
+				Expect(err).To(Equal(auctiontypes.ErrorBatchFull))
+				Expect(newLeader.PendingAuctions().LRPs).To(HaveLen(1))
+				Expect(delegate.AuctionCompletedCallCount()).To(Equal(0))
+
+				process := ifrit.Invoke(newLeader)
+				defer func() {
+					process.Signal(os.Interrupt)
+					Eventually(process.Wait()).Should(Receive())
+				}()
+
+				Eventually(delegate.AuctionCompletedCallCount).Should(BeNumerically(">=", 1))
+				results := delegate.AuctionCompletedArgsForCall(0)
+				Expect(results.FailedTasks).To(HaveLen(1))
+				Expect(results.FailedTasks[0].Identifier()).To(Equal("tg-1"))
+				Expect(results.FailedTasks[0].PlacementError).To(Equal(auctiontypes.ErrorBatchFull.Error()))
+			})
+		})
+	})
+
+	Describe("shutting down", func() {
+		var (
+			client         *repfakes.FakeSimClient
+			releaseAuction chan struct{}
+			signals        chan os.Signal
+			exited         chan error
+		)
+
+		BeforeEach(func() {
+			client = &repfakes.FakeSimClient{}
+			client.StateReturns(BuildCellState("cell", 0, "the-zone", 100, 100, 10, false, 0, linuxOnlyRootFSProviders, nil, nil, nil, nil, 0), nil)
+			delegate.FetchCellRepsReturns(map[string]rep.Client{"cell": client}, nil)
+
+			releaseAuction = make(chan struct{})
+			delegate.AuctionCompletedStub = func(auctiontypes.AuctionResults) {
+				<-releaseAuction
+			}
+		})
+
+		JustBeforeEach(func() {
+			signals = make(chan os.Signal, 1)
+			exited = make(chan error, 1)
+			ready := make(chan struct{})
+			go func() {
+				exited <- runner.Run(signals, ready)
+			}()
+			Eventually(ready).Should(BeClosed())
+
+			runner.ScheduleLRPsForAuctions([]auctioneer.LRPStartRequest{
+				BuildLRPStartRequest("pg-1", "domain", []int{0}, linuxRootFSURL, 10, 10, 10, []string{}, []string{}),
+			})
+			Eventually(delegate.AuctionCompletedCallCount).Should(Equal(1))
+
+			runner.ScheduleTasksForAuctions([]auctioneer.TaskStartRequest{
+				BuildTaskStartRequest("tg-1", "domain", linuxRootFSURL, 10, 10, 10),
+			})
+			signals <- os.Interrupt
+			close(releaseAuction)
+		})
+
+		Context("by default", func() {
+			It("drops the pending auctions", func() {
+				Eventually(exited).Should(Receive(BeNil()))
+
+				Expect(delegate.AuctionCompletedCallCount()).To(Equal(1))
+				Expect(delegate.AuctionCompletedArgsForCall(0).SuccessfulLRPs).To(HaveLen(1))
+			})
+		})
+
+		Context("when handing back pending auctions", func() {
+			BeforeEach(func() {
+				options = append(options, auctionrunner.WithGracefulShutdown(auctionrunner.ShutdownHandBack, 0))
+			})
+
+			It("reports them as failed because the runner is shutting down", func() {
+				Eventually(exited).Should(Receive(BeNil()))
+
+				Expect(delegate.AuctionCompletedCallCount()).To(Equal(2))
+				results := delegate.AuctionCompletedArgsForCall(1)
+				Expect(results.SuccessfulTasks).To(BeEmpty())
+				Expect(results.FailedTasks).To(HaveLen(1))
+				Expect(results.FailedTasks[0].Identifier()).To(Equal("tg-1"))
+				Expect(results.FailedTasks[0].PlacementError).To(Equal(auctiontypes.ErrorShuttingDown.Error()))
+				Expect(results.FailedTasks[0].Attempts).To(Equal(0))
+			})
+		})
+
+		Context("when draining the batch", func() {
+			BeforeEach(func() {
+				options = append(options, auctionrunner.WithGracefulShutdown(auctionrunner.ShutdownDrain, time.Minute))
+			})
+
+			It("holds a final auction for the pending work", func() {
+				Eventually(exited).Should(Receive(BeNil()))
+
+				Expect(delegate.AuctionCompletedCallCount()).To(Equal(2))
+				results := delegate.AuctionCompletedArgsForCall(1)
+				Expect(results.SuccessfulTasks).To(HaveLen(1))
+				Expect(results.SuccessfulTasks[0].Identifier()).To(Equal("tg-1"))
+			})
+
+			Context("when the final auction takes longer than the deadline", func() {
+				var releaseFetch chan struct{}
+
+				BeforeEach(func() {
+					releaseFetch = make(chan struct{})
+					// the abandoned auction may still be fetching once the test is over
+					fetchDelegate, cellClient, release := delegate, client, releaseFetch
+					delegate.FetchCellRepsStub = func() (map[string]rep.Client, error) {
+						if fetchDelegate.FetchCellRepsCallCount() == 1 {
+							return map[string]rep.Client{"cell": cellClient}, nil
+						}
+						<-release
+						return nil, errors.New("boom")
+					}
+				})
+
+				It("abandons it and hands back whatever is still pending without waiting for it", func() {
+					defer close(releaseFetch)
+
+					Eventually(clock.WatcherCount).Should(Equal(1))
+					clock.Increment(time.Minute)
+					Eventually(exited).Should(Receive(BeNil()))
+
+					Expect(delegate.AuctionCompletedCallCount()).To(Equal(2))
+					results := delegate.AuctionCompletedArgsForCall(1)
+					Expect(results.FailedTasks).To(HaveLen(1))
+					Expect(results.FailedTasks[0].Identifier()).To(Equal("tg-1"))
+					Expect(results.FailedTasks[0].PlacementError).To(Equal(auctiontypes.ErrorShuttingDown.Error()))
+				})
+			})
+
+			Context("when the deadline passes while the final auction commits its work", func() {
+				var (
+					performing     chan struct{}
+					releasePerform chan struct{}
+				)
+
+				BeforeEach(func() {
+					performing = make(chan struct{})
+					releasePerform = make(chan struct{})
+					client.PerformStub = func(lager.Logger, rep.Work) (rep.Work, error) {
+						if client.PerformCallCount() > 1 {
+							close(performing)
+							<-releasePerform
+						}
+						return rep.Work{}, nil
+					}
+				})
+
+				It("waits for the final auction before returning", func() {
+					Eventually(performing).Should(BeClosed())
+					Eventually(clock.WatcherCount).Should(Equal(1))
+					clock.Increment(time.Minute)
+					Consistently(exited).ShouldNot(Receive())
+
+					close(releasePerform)
+					Eventually(exited).Should(Receive(BeNil()))
+
+					Expect(delegate.AuctionCompletedCallCount()).To(Equal(2))
+					results := delegate.AuctionCompletedArgsForCall(1)
+					Expect(results.SuccessfulTasks).To(HaveLen(1))
+					Expect(results.SuccessfulTasks[0].Identifier()).To(Equal("tg-1"))
+					Consistently(delegate.AuctionCompletedCallCount).Should(Equal(2))
+				})
+			})
+		})
+	})
+})
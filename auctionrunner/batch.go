@@ -11,24 +11,65 @@ import (
 	"code.cloudfoundry.org/clock"
 )
 
+// OverflowPolicy decides what a bounded Batch does with work that arrives
+// once it is holding as many auctions as its capacity allows.
+type OverflowPolicy int
+
+const (
+	// RejectNewest turns away the incoming auctions.
+	RejectNewest OverflowPolicy = iota
+	// DropOldest evicts the auctions that have been queued the longest to make
+	// room for the incoming ones.
+	DropOldest
+	// BlockUntilDrained makes the caller wait until the auction runner drains
+	// the batch.
+	BlockUntilDrained
+)
+
 type Batch struct {
-	lrpAuctions  []auctiontypes.LRPAuction
-	taskAuctions []auctiontypes.TaskAuction
-	lock         *sync.Mutex
-	HasWork      chan struct{}
-	clock        clock.Clock
+	lrpAuctions    []auctiontypes.LRPAuction
+	taskAuctions   []auctiontypes.TaskAuction
+	lock           *sync.Mutex
+	spaceAvailable *sync.Cond
+	HasWork        chan struct{}
+	clock          clock.Clock
+
+	capacity        int // <=0 means no limit
+	overflowPolicy  OverflowPolicy
+	wal             *WriteAheadLog
+	taskGroup       func(task *rep.Task) string // nil leaves tasks ungrouped
+	taskGroupingKey func(task *rep.Task) string // nil leaves tasks without a grouping key
+
+	// turnedAway holds the auctions that were rejected or evicted, failed with
+	// ErrorBatchFull, until the auction runner reports them.
+	turnedAway auctiontypes.AuctionResults
+	closed     bool
 }
 
 func NewBatch(clock clock.Clock) *Batch {
+	lock := &sync.Mutex{}
 	return &Batch{
-		lrpAuctions: []auctiontypes.LRPAuction{},
-		lock:        &sync.Mutex{},
-		clock:       clock,
-		HasWork:     make(chan struct{}, 1),
+		lrpAuctions:    []auctiontypes.LRPAuction{},
+		lock:           lock,
+		spaceAvailable: sync.NewCond(lock),
+		clock:          clock,
+		HasWork:        make(chan struct{}, 1),
 	}
 }
 
-func (b *Batch) AddLRPStarts(starts []auctioneer.LRPStartRequest) {
+func NewBoundedBatch(clock clock.Clock, capacity int, overflowPolicy OverflowPolicy) *Batch {
+	batch := NewBatch(clock)
+	batch.capacity = capacity
+	batch.overflowPolicy = overflowPolicy
+	return batch
+}
+
+// AddLRPStarts queues an auction for every requested index.  It returns
+// ErrorBatchFull when some of them did not fit in the batch, and
+// ErrorShuttingDown once the batch is closed.  The auctions turned away, which
+// when dropping the oldest work are previously queued ones, are kept as
+// failures for DrainTurnedAway.
+func (b *Batch) AddLRPStarts(starts []auctioneer.LRPStartRequest) error {
 	auctions := make([]auctiontypes.LRPAuction, 0, len(starts))
 	now := b.clock.Now()
 	for i := range starts {
@@ -40,23 +81,47 @@ func (b *Batch) AddLRPStarts(starts []auctioneer.LRPStartRequest) {
 		}
 	}
 
-	b.lock.Lock()
-	b.lrpAuctions = append(b.lrpAuctions, auctions...)
-	b.claimToHaveWork()
-	b.lock.Unlock()
+	return b.AddLRPAuctions(auctions)
 }
 
-func (b *Batch) AddTasks(tasks []auctioneer.TaskStartRequest) {
+// AddTasks queues an auction for every task, turning work away the same way
+// AddLRPStarts does when the batch is full.  The tasks of a task group are
+// queued or turned away together.  Each task is given its task group and
+// grouping key, when the batch has functions for them.
+func (b *Batch) AddTasks(tasks []auctioneer.TaskStartRequest) error {
 	auctions := make([]auctiontypes.TaskAuction, 0, len(tasks))
 	now := b.clock.Now()
 	for i := range tasks {
-		auctions = append(auctions, auctiontypes.NewTaskAuction(tasks[i].Task, now))
+		auction := auctiontypes.NewTaskAuction(tasks[i].Task, now)
+		if b.taskGroup != nil {
+			auction.TaskGroup = b.taskGroup(&auction.Task)
+		}
+		if b.taskGroupingKey != nil {
+			auction.GroupingKey = b.taskGroupingKey(&auction.Task)
+		}
+		auctions = append(auctions, auction)
 	}
 
+	return b.AddTaskAuctions(auctions)
+}
+
+// AddLRPAuctions queues auctions that already have an AuctionRecord, such as
+// ones handed over by another auction runner, keeping their queue time and
+// attempts.
+func (b *Batch) AddLRPAuctions(auctions []auctiontypes.LRPAuction) error {
+	defer b.syncWriteAheadLog()
+
 	b.lock.Lock()
-	b.taskAuctions = append(b.taskAuctions, auctions...)
-	b.claimToHaveWork()
-	b.lock.Unlock()
+	defer b.lock.Unlock()
+	return b.addLRPAuctions(auctions, false)
+}
+
+func (b *Batch) AddTaskAuctions(auctions []auctiontypes.TaskAuction) error {
+	defer b.syncWriteAheadLog()
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.addTaskAuctions(auctions, false)
 }
 
 func (b *Batch) DedupeAndDrain() ([]auctiontypes.LRPAuction, []auctiontypes.TaskAuction) {
@@ -65,40 +130,430 @@ func (b *Batch) DedupeAndDrain() ([]auctiontypes.LRPAuction, []auctiontypes.Task
 	taskAuctions := b.taskAuctions
 	b.lrpAuctions = []auctiontypes.LRPAuction{}
 	b.taskAuctions = []auctiontypes.TaskAuction{}
-	select {
-	case <-b.HasWork:
-	default:
-	}
+	b.releaseWorkIfEmpty()
+	b.spaceAvailable.Broadcast()
 	b.lock.Unlock()
 
+	duplicateLRPAuctions := []auctiontypes.LRPAuction{}
 	dedupedLRPAuctions := []auctiontypes.LRPAuction{}
 	presentLRPAuctions := map[string]bool{}
 	for _, startAuction := range lrpAuctions {
 		id := startAuction.Identifier()
 		if presentLRPAuctions[id] {
+			duplicateLRPAuctions = append(duplicateLRPAuctions, startAuction)
 			continue
 		}
 		presentLRPAuctions[id] = true
 		dedupedLRPAuctions = append(dedupedLRPAuctions, startAuction)
 	}
 
+	duplicateTaskAuctions := []auctiontypes.TaskAuction{}
 	dedupedTaskAuctions := []auctiontypes.TaskAuction{}
 	presentTaskAuctions := map[string]bool{}
 	for _, taskAuction := range taskAuctions {
 		id := taskAuction.Identifier()
 		if presentTaskAuctions[id] {
+			duplicateTaskAuctions = append(duplicateTaskAuctions, taskAuction)
 			continue
 		}
 		presentTaskAuctions[id] = true
 		dedupedTaskAuctions = append(dedupedTaskAuctions, taskAuction)
 	}
 
+	if b.wal != nil {
+		b.wal.RecordCompleted(duplicateLRPAuctions, duplicateTaskAuctions)
+	}
+
 	return dedupedLRPAuctions, dedupedTaskAuctions
 }
 
+// DrainTurnedAway returns the auctions turned away since it was last called,
+// as failures.
+func (b *Batch) DrainTurnedAway() auctiontypes.AuctionResults {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	turnedAway := b.turnedAway
+	b.turnedAway = auctiontypes.AuctionResults{}
+	b.releaseWorkIfEmpty()
+	return turnedAway
+}
+
+// Close turns away the work added from then on with ErrorShuttingDown,
+// including the work of callers waiting for room.  Turned away work is not
+// kept for DrainTurnedAway, as nobody is left to report it.
+func (b *Batch) Close() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.closed = true
+	b.spaceAvailable.Broadcast()
+}
+
+func (b *Batch) Pending() ([]auctiontypes.LRPAuction, []auctiontypes.TaskAuction) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	lrpAuctions := make([]auctiontypes.LRPAuction, len(b.lrpAuctions))
+	copy(lrpAuctions, b.lrpAuctions)
+	taskAuctions := make([]auctiontypes.TaskAuction, len(b.taskAuctions))
+	copy(taskAuctions, b.taskAuctions)
+
+	return lrpAuctions, taskAuctions
+}
+
+func (b *Batch) CancelLRPStarts(processGuid string, indices []int) []auctiontypes.LRPAuction {
+	cancelledIndices := map[int32]bool{}
+	for _, index := range indices {
+		cancelledIndices[int32(index)] = true
+	}
+
+	defer b.syncWriteAheadLog()
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	cancelled := []auctiontypes.LRPAuction{}
+	remaining := make([]auctiontypes.LRPAuction, 0, len(b.lrpAuctions))
+	for _, auction := range b.lrpAuctions {
+		if auction.ProcessGuid == processGuid && cancelledIndices[auction.Index] {
+			cancelled = append(cancelled, auction)
+			continue
+		}
+		remaining = append(remaining, auction)
+	}
+	b.lrpAuctions = remaining
+	if b.wal != nil {
+		b.wal.writeCompleted(cancelled, nil)
+	}
+	b.releaseWorkIfEmpty()
+	b.spaceAvailable.Broadcast()
+
+	return cancelled
+}
+
+// CancelTasks removes the auctions of the tasks from the batch and returns
+// them.  Cancelling a task of a task group cancels the whole group.
+func (b *Batch) CancelTasks(taskGuids []string) []auctiontypes.TaskAuction {
+	cancelledGuids := map[string]bool{}
+	for _, guid := range taskGuids {
+		cancelledGuids[guid] = true
+	}
+
+	defer b.syncWriteAheadLog()
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	cancelled := b.removeTasks(func(auction *auctiontypes.TaskAuction) bool {
+		return cancelledGuids[auction.TaskGuid]
+	}, nil)
+	if b.wal != nil {
+		b.wal.writeCompleted(nil, cancelled)
+	}
+	b.releaseWorkIfEmpty()
+	b.spaceAvailable.Broadcast()
+
+	return cancelled
+}
+
+// requeue adds auctions that were replayed from the write-ahead log without
+// recording them a second time.  It runs before the auction runner starts
+// draining the batch, so when the overflow policy is BlockUntilDrained the
+// auctions are admitted even past the capacity rather than waiting for room.
+func (b *Batch) requeue(lrpAuctions []auctiontypes.LRPAuction, taskAuctions []auctiontypes.TaskAuction) error {
+	defer b.syncWriteAheadLog()
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	err := b.addLRPAuctions(lrpAuctions, true)
+	taskErr := b.addTaskAuctions(taskAuctions, true)
+	if err == nil {
+		err = taskErr
+	}
+	return err
+}
+
+// admission counts the auctions of a single call that are still queued.  They
+// are the newest ones in the batch, so an evicted auction is one of them only
+// once they are all that is left, in which case the call's own work was turned
+// away.
+type admission struct {
+	lrps       int
+	tasks      int
+	turnedAway bool
+}
+
+// addLRPAuctions must be called with the lock held.  Accepted auctions are
+// written to the write-ahead log, if any, before the lock can be released; see
+// syncWriteAheadLog.  Replayed auctions are already in the log, so only the
+// ones that are turned away are written, as completed.
+func (b *Batch) addLRPAuctions(auctions []auctiontypes.LRPAuction, replayed bool) error {
+	admitted := &admission{}
+	accepted := make([]auctiontypes.LRPAuction, 0, len(auctions))
+	recordAccepted := func() {
+		if b.wal != nil && !replayed {
+			b.wal.writeLRPAuctions(accepted)
+		}
+		accepted = accepted[:0]
+	}
+	defer recordAccepted()
+
+	for _, auction := range auctions {
+		if !b.makeRoom(1, admitted, recordAccepted, replayed) {
+			if b.closed {
+				return auctiontypes.ErrorShuttingDown
+			}
+			if b.wal != nil && replayed {
+				b.wal.writeCompleted([]auctiontypes.LRPAuction{auction}, nil)
+			}
+			auction.PlacementError = auctiontypes.ErrorBatchFull.Error()
+			b.turnedAway.FailedLRPs = append(b.turnedAway.FailedLRPs, auction)
+			admitted.turnedAway = true
+			continue
+		}
+		b.lrpAuctions = append(b.lrpAuctions, auction)
+		admitted.lrps++
+		accepted = append(accepted, auction)
+		b.claimToHaveWork()
+	}
+
+	return admitted.err()
+}
+
+// addTaskAuctions must be called with the lock held.  Accepted auctions are
+// written to the write-ahead log, if any, before the lock can be released; see
+// syncWriteAheadLog.  Replayed auctions are already in the log, so only the
+// ones that are turned away are written, as completed.  The auctions of a task
+// group are added together, and when they are turned away the auctions of the
+// group that were already queued are turned away with them.
+func (b *Batch) addTaskAuctions(auctions []auctiontypes.TaskAuction, replayed bool) error {
+	admitted := &admission{}
+	accepted := make([]auctiontypes.TaskAuction, 0, len(auctions))
+	recordAccepted := func() {
+		if b.wal != nil && !replayed {
+			b.wal.writeTaskAuctions(accepted)
+		}
+		accepted = accepted[:0]
+	}
+	defer recordAccepted()
+
+	for _, unit := range taskAdmissionUnits(auctions) {
+		if !b.makeRoom(len(unit), admitted, recordAccepted, replayed) {
+			if b.closed {
+				return auctiontypes.ErrorShuttingDown
+			}
+			if b.wal != nil && replayed {
+				b.wal.writeCompleted(nil, unit)
+			}
+			b.turnAwayTasks(unit)
+			if group := unit[0].TaskGroup; group != "" {
+				b.evictTasks(func(auction *auctiontypes.TaskAuction) bool {
+					return auction.TaskGroup == group
+				}, admitted)
+			}
+			admitted.turnedAway = true
+			continue
+		}
+		b.taskAuctions = append(b.taskAuctions, unit...)
+		admitted.tasks += len(unit)
+		accepted = append(accepted, unit...)
+		b.claimToHaveWork()
+	}
+
+	return admitted.err()
+}
+
+// taskAdmissionUnits splits the task auctions into the ones that are queued or
+// turned away together: the auctions of each task group, in place of the
+// first of them, and every other auction on its own.
+func taskAdmissionUnits(auctions []auctiontypes.TaskAuction) [][]auctiontypes.TaskAuction {
+	units := make([][]auctiontypes.TaskAuction, 0, len(auctions))
+	groupUnits := map[string]int{}
+	for _, auction := range auctions {
+		if auction.TaskGroup == "" {
+			units = append(units, []auctiontypes.TaskAuction{auction})
+			continue
+		}
+
+		i, ok := groupUnits[auction.TaskGroup]
+		if !ok {
+			i = len(units)
+			groupUnits[auction.TaskGroup] = i
+			units = append(units, nil)
+		}
+		units[i] = append(units[i], auction)
+	}
+	return units
+}
+
+// removeTasks must be called with the lock held.  It removes the queued task
+// auctions pick picks, along with the rest of their task groups, and returns
+// them.  When admitted is given, the auctions of that call which are removed
+// no longer count as admitted.
+func (b *Batch) removeTasks(pick func(auction *auctiontypes.TaskAuction) bool, admitted *admission) []auctiontypes.TaskAuction {
+	groups := map[string]bool{}
+	picked := make([]bool, len(b.taskAuctions))
+	for i := range b.taskAuctions {
+		auction := &b.taskAuctions[i]
+		if pick(auction) {
+			picked[i] = true
+			if auction.TaskGroup != "" {
+				groups[auction.TaskGroup] = true
+			}
+		}
+	}
+
+	own := len(b.taskAuctions)
+	if admitted != nil {
+		own -= admitted.tasks
+	}
+
+	removed := []auctiontypes.TaskAuction{}
+	remaining := make([]auctiontypes.TaskAuction, 0, len(b.taskAuctions))
+	for i, auction := range b.taskAuctions {
+		if !picked[i] && !(auction.TaskGroup != "" && groups[auction.TaskGroup]) {
+			remaining = append(remaining, auction)
+			continue
+		}
+		if admitted != nil && i >= own {
+			admitted.tasks--
+			admitted.turnedAway = true
+		}
+		removed = append(removed, auction)
+	}
+	b.taskAuctions = remaining
+	return removed
+}
+
+// evictTasks must be called with the lock held.  It turns away the queued
+// task auctions pick picks, along with the rest of their task groups,
+// completing them in the write-ahead log.
+func (b *Batch) evictTasks(pick func(auction *auctiontypes.TaskAuction) bool, admitted *admission) {
+	evicted := b.removeTasks(pick, admitted)
+	if len(evicted) == 0 {
+		return
+	}
+	if b.wal != nil {
+		b.wal.writeCompleted(nil, evicted)
+	}
+	b.turnAwayTasks(evicted)
+}
+
+// turnAwayTasks must be called with the lock held.  It keeps the auctions as
+// failures with ErrorBatchFull for DrainTurnedAway.
+func (b *Batch) turnAwayTasks(auctions []auctiontypes.TaskAuction) {
+	for _, auction := range auctions {
+		auction.PlacementError = auctiontypes.ErrorBatchFull.Error()
+		b.turnedAway.FailedTasks = append(b.turnedAway.FailedTasks, auction)
+	}
+}
+
+func (a *admission) err() error {
+	if a.turnedAway {
+		return auctiontypes.ErrorBatchFull
+	}
+	return nil
+}
+
+// makeRoom must be called with the lock held.  It reports whether n more
+// auctions can be added, evicting or waiting as the overflow policy dictates.
+// recordAccepted is called before the lock is released to wait for room, and
+// before evicting, so an evicted auction is never completed in the write-ahead
+// log ahead of its own entry.  Nothing can be added once the batch is closed,
+// nor more auctions at once than the batch can hold.  Replayed auctions never
+// wait, as nothing drains the batch until they are requeued.
+func (b *Batch) makeRoom(n int, admitted *admission, recordAccepted func(), replayed bool) bool {
+	if b.closed {
+		return false
+	}
+	if b.capacity <= 0 || (replayed && b.overflowPolicy == BlockUntilDrained) {
+		return true
+	}
+	if n > b.capacity {
+		return false
+	}
+
+	for len(b.lrpAuctions)+len(b.taskAuctions)+n > b.capacity {
+		switch b.overflowPolicy {
+		case DropOldest:
+			recordAccepted()
+			b.evictOldest(admitted)
+		case BlockUntilDrained:
+			recordAccepted()
+			b.spaceAvailable.Wait()
+			if b.closed {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// evictOldest must be called with the lock held.  Evicting a task of a task
+// group evicts the whole group.
+func (b *Batch) evictOldest(admitted *admission) {
+	evictLRP := len(b.taskAuctions) == 0 ||
+		(len(b.lrpAuctions) > 0 && !b.taskAuctions[0].QueueTime.Before(b.lrpAuctions[0].QueueTime))
+
+	if evictLRP {
+		if len(b.lrpAuctions) == admitted.lrps {
+			admitted.lrps--
+			admitted.turnedAway = true
+		}
+		evicted := b.lrpAuctions[0]
+		if b.wal != nil {
+			b.wal.writeCompleted([]auctiontypes.LRPAuction{evicted}, nil)
+		}
+		evicted.PlacementError = auctiontypes.ErrorBatchFull.Error()
+		b.turnedAway.FailedLRPs = append(b.turnedAway.FailedLRPs, evicted)
+		b.lrpAuctions = b.lrpAuctions[1:]
+	} else {
+		oldest := &b.taskAuctions[0]
+		b.evictTasks(func(auction *auctiontypes.TaskAuction) bool {
+			return auction == oldest
+		}, admitted)
+	}
+	b.claimToHaveWork()
+}
+
+// syncWriteAheadLog waits for what was written to the write-ahead log, if any,
+// to reach the disk.  It must be called without the lock held, so that the
+// auction runner is not held up by the fsync.
+func (b *Batch) syncWriteAheadLog() {
+	if b.wal != nil {
+		b.wal.sync()
+	}
+}
+
 func (b *Batch) claimToHaveWork() {
 	select {
 	case b.HasWork <- struct{}{}:
 	default:
 	}
 }
+
+func (b *Batch) hasPendingWork() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return len(b.lrpAuctions) > 0 || len(b.taskAuctions) > 0
+}
+
+// releaseWorkIfEmpty must be called with the lock held.  Turned away auctions
+// that have not been drained count as work.
+func (b *Batch) releaseWorkIfEmpty() {
+	if len(b.lrpAuctions) > 0 || len(b.taskAuctions) > 0 {
+		return
+	}
+	if len(b.turnedAway.FailedLRPs) > 0 || len(b.turnedAway.FailedTasks) > 0 {
+		return
+	}
+
+	select {
+	case <-b.HasWork:
+	default:
+	}
+}
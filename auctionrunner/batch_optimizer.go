@@ -0,0 +1,638 @@
+package auctionrunner
+
+import (
+	"sort"
+	"time"
+
+	"code.cloudfoundry.org/auction/auctiontypes"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/rep"
+)
+
+// WithBatchOptimizer plans the placement of the whole batch before placing any
+// of it, and uses the plan when it places more auctions than greedy
+// scheduling.  Planning gives up and leaves the batch to greedy scheduling once
+// it has taken longer than budget.  Each placement looks at the next lookahead
+// auctions to avoid stranding them; 0 plans best-fit-decreasing without
+// lookahead.
+func WithBatchOptimizer(budget time.Duration, lookahead int) SchedulerOption {
+	return func(s *Scheduler) {
+		s.optimizerBudget = budget
+		s.optimizerLookahead = lookahead
+	}
+}
+
+// plannedAuction is an auction of the batch along with the cell the plan puts
+// it on, or no cell when the plan leaves it unplaced.
+type plannedAuction struct {
+	lrp   *auctiontypes.LRPAuction
+	task  *auctiontypes.TaskAuction
+	phase int
+	cell  *Cell
+	zone  string
+}
+
+func (a *plannedAuction) resource() rep.Resource {
+	if a.lrp != nil {
+		return a.lrp.Resource
+	}
+	return a.task.Resource
+}
+
+func (a *plannedAuction) placementConstraint() rep.PlacementConstraint {
+	if a.lrp != nil {
+		return a.lrp.PlacementConstraint
+	}
+	return a.task.PlacementConstraint
+}
+
+type batchPlan struct {
+	auctions []plannedAuction
+	placed   int
+}
+
+// planBin tracks the resources of a cell as the plan fills it.
+type planBin struct {
+	cell      *Cell
+	zone      string
+	order     int
+	available binResources
+	// instances counts the planned LRP instances of each process guid
+	instances map[string]int
+	// tasks counts the planned tasks
+	tasks int
+	// extended counts the extended resources the planned auctions take up
+	extended auctiontypes.ExtendedResources
+}
+
+// footprint is what the auction takes up on the cell of the bin, overhead
+// included, just like when it is scored and reserved.
+func (b *planBin) footprint(auction *plannedAuction) rep.Resource {
+	if auction.lrp != nil {
+		return b.cell.lrpFootprint(&auction.lrp.LRP)
+	}
+	return b.cell.taskFootprint(&auction.task.Task)
+}
+
+// fits reports whether the footprint of the auction fits in what is left of
+// the bin once consumed has been taken out of it.  Only urgent work fits in
+// the headroom of the cell.  Extended resources are checked against the
+// planned auctions only, not consumed.
+func (b *planBin) fits(auction *plannedAuction, consumed binResources) bool {
+	resource := b.footprint(auction)
+	memoryMB := resource.MemoryMB
+	diskMB := resource.DiskMB
+	containers := 1
+	if !b.mayUseHeadroom(auction) {
+		memoryMB += b.cell.headroom.MemoryMB
+		diskMB += b.cell.headroom.DiskMB
+		containers += b.cell.headroom.Containers
+	}
+
+	left := b.available.minus(consumed)
+	return left.memoryMB >= memoryMB &&
+		left.diskMB >= diskMB &&
+		left.containers >= containers &&
+		(!b.cell.tracksPids() || left.pids >= resource.MaxPids) &&
+		b.fitsExtended(auction)
+}
+
+func (b *planBin) mayUseHeadroom(auction *plannedAuction) bool {
+	if auction.lrp != nil {
+		return b.cell.lrpMayUseHeadroom(&auction.lrp.LRP)
+	}
+	return b.cell.taskMayUseHeadroom(&auction.task.Task)
+}
+
+func (b *planBin) fitsExtended(auction *plannedAuction) bool {
+	pc := auction.placementConstraint()
+	for name, amount := range b.cell.extendedRequest(&pc) {
+		if b.cell.availableExtended[name]-b.extended[name] < amount {
+			return false
+		}
+	}
+	return true
+}
+
+func (b *planBin) processInstances(processGuid string) int {
+	return b.cell.processInstances[processGuid] + b.instances[processGuid]
+}
+
+func (b *planBin) runningTasks() int {
+	return len(b.cell.state.Tasks) + b.tasks
+}
+
+// binResources are the resources of a bin, including its pids.
+type binResources struct {
+	memoryMB   int32
+	diskMB     int32
+	containers int
+	pids       int32
+}
+
+// plus adds the resources a container for resource takes up.
+func (r binResources) plus(resource rep.Resource) binResources {
+	r.memoryMB += resource.MemoryMB
+	r.diskMB += resource.DiskMB
+	r.containers++
+	r.pids += resource.MaxPids
+	return r
+}
+
+func (r binResources) minus(other binResources) binResources {
+	r.memoryMB -= other.memoryMB
+	r.diskMB -= other.diskMB
+	r.containers -= other.containers
+	r.pids -= other.pids
+	return r
+}
+
+/*
+batchPlanner places the auctions of a batch on simulated cells in the order it
+is given.  An auction goes to the cell it fits best, in a zone with the fewest instances
+of its process and on a cell with the fewest instances of it for LRPs.  With
+lookahead, the best few cells are weighed by how many of the auctions that
+follow would still fit after placing the auction on them.
+*/
+type batchPlanner struct {
+	scheduler     *Scheduler
+	zoneNames     []string
+	bins          map[*Cell]*planBin
+	zoneInstances map[string]map[string]int
+	// zoneTasks counts the planned tasks like Scheduler.zoneTasks
+	zoneTasks       zoneTaskIndex
+	lookahead       int
+	currentInflight int
+}
+
+func (s *Scheduler) newBatchPlanner(currentInflight int) *batchPlanner {
+	p := &batchPlanner{
+		scheduler:       s,
+		zoneNames:       sortedZoneNames(s.zones),
+		bins:            map[*Cell]*planBin{},
+		zoneInstances:   map[string]map[string]int{},
+		zoneTasks:       zoneTaskIndex{},
+		lookahead:       s.optimizerLookahead,
+		currentInflight: currentInflight,
+	}
+
+	for _, name := range p.zoneNames {
+		p.zoneInstances[name] = map[string]int{}
+		p.zoneTasks[name] = map[string]int{}
+		for _, cell := range s.zones[name] {
+			p.bins[cell] = &planBin{
+				cell:  cell,
+				zone:  name,
+				order: len(p.bins),
+				available: binResources{
+					memoryMB:   cell.state.AvailableResources.MemoryMB,
+					diskMB:     cell.state.AvailableResources.DiskMB,
+					containers: cell.state.AvailableResources.Containers,
+					pids:       cell.availablePids,
+				},
+			}
+		}
+	}
+
+	return p
+}
+
+/*
+planBatch plans the batch best-fit-decreasing: index 0 LRPs, tasks and the
+remaining LRPs are planned in that order, as they are scheduled, but each of
+these phases is planned from the largest auction down.  When the cells cannot
+take the whole batch this leaves the smallest auctions unplaced, so the
+largest auctions that make up for the memory left unplaced are deferred to the
+end of their phase and the batch is planned again, for as long as that places
+more auctions.
+
+It returns the plan when one can be made within the budget and it places more
+auctions than greedy scheduling, and nil otherwise.
+*/
+func (s *Scheduler) planBatch(
+	lrpsBeforeTasks []auctiontypes.LRPAuction,
+	tasks []auctiontypes.TaskAuction,
+	lrpsAfterTasks []auctiontypes.LRPAuction,
+	currentInflight int,
+) *batchPlan {
+	if s.optimizerBudget <= 0 {
+		return nil
+	}
+
+	logger := s.logger.Session("batch-optimizer")
+	start := s.clock.Now()
+
+	exceeded := func() bool {
+		return s.clock.Now().Sub(start) > s.optimizerBudget
+	}
+
+	plan := s.newBatchPlanner(currentInflight).plan(orderBatch(lrpsBeforeTasks, tasks, lrpsAfterTasks), exceeded)
+	if plan == nil {
+		logger.Info("exceeded-budget", lager.Data{"budget": s.optimizerBudget.String()})
+		return nil
+	}
+
+	for plan.placed < len(plan.auctions) {
+		deferred := s.newBatchPlanner(currentInflight).plan(deferLargest(plan.auctions), exceeded)
+		if deferred == nil || deferred.placed <= plan.placed {
+			break
+		}
+		plan = deferred
+	}
+
+	greedyPlaced, ok := s.greedyPlacements(lrpsBeforeTasks, tasks, lrpsAfterTasks, exceeded)
+	if !ok {
+		logger.Info("exceeded-budget", lager.Data{"budget": s.optimizerBudget.String()})
+		return nil
+	}
+	logger.Debug("planned-batch", lager.Data{
+		"planned":  plan.placed,
+		"greedy":   greedyPlaced,
+		"duration": s.clock.Now().Sub(start).String(),
+	})
+	if plan.placed <= greedyPlaced {
+		return nil
+	}
+
+	logger.Info("using-plan", lager.Data{"planned": plan.placed, "greedy": greedyPlaced})
+	return plan
+}
+
+func orderBatch(
+	lrpsBeforeTasks []auctiontypes.LRPAuction,
+	tasks []auctiontypes.TaskAuction,
+	lrpsAfterTasks []auctiontypes.LRPAuction,
+) []plannedAuction {
+	auctions := make([]plannedAuction, 0, len(lrpsBeforeTasks)+len(tasks)+len(lrpsAfterTasks))
+	for i := range lrpsBeforeTasks {
+		auctions = append(auctions, plannedAuction{lrp: &lrpsBeforeTasks[i], phase: 0})
+	}
+	for i := range tasks {
+		auctions = append(auctions, plannedAuction{task: &tasks[i], phase: 1})
+	}
+	for i := range lrpsAfterTasks {
+		auctions = append(auctions, plannedAuction{lrp: &lrpsAfterTasks[i], phase: 2})
+	}
+
+	sort.SliceStable(auctions, func(i, j int) bool {
+		if auctions[i].phase != auctions[j].phase {
+			return auctions[i].phase < auctions[j].phase
+		}
+		ri, rj := auctions[i].resource(), auctions[j].resource()
+		if ri.MemoryMB == rj.MemoryMB {
+			return ri.DiskMB > rj.DiskMB
+		}
+		return ri.MemoryMB > rj.MemoryMB
+	})
+	return auctions
+}
+
+// deferLargest moves the largest planned auctions, taken from the last phases
+// first, to the end of their phase until they hold as much memory as the
+// auctions the plan left unplaced.  Only auctions larger than the smallest
+// unplaced one are deferred, as deferring any other cannot place more.
+func deferLargest(planned []plannedAuction) []plannedAuction {
+	var unplacedMB int32
+	smallestUnplacedMB := int32(-1)
+	for i := range planned {
+		if planned[i].cell != nil {
+			continue
+		}
+		memoryMB := planned[i].resource().MemoryMB
+		unplacedMB += memoryMB
+		if smallestUnplacedMB < 0 || memoryMB < smallestUnplacedMB {
+			smallestUnplacedMB = memoryMB
+		}
+	}
+
+	victims := []int{}
+	for i := range planned {
+		if planned[i].cell != nil && planned[i].resource().MemoryMB > smallestUnplacedMB {
+			victims = append(victims, i)
+		}
+	}
+	sort.SliceStable(victims, func(i, j int) bool {
+		vi, vj := &planned[victims[i]], &planned[victims[j]]
+		if vi.phase != vj.phase {
+			return vi.phase > vj.phase
+		}
+		return vi.resource().MemoryMB > vj.resource().MemoryMB
+	})
+
+	deferred := map[int]bool{}
+	var deferredMB int32
+	for _, i := range victims {
+		if deferredMB >= unplacedMB {
+			break
+		}
+		deferred[i] = true
+		deferredMB += planned[i].resource().MemoryMB
+	}
+
+	auctions := make([]plannedAuction, 0, len(planned))
+	for phase := 0; phase <= 2; phase++ {
+		for _, last := range []bool{false, true} {
+			for i := range planned {
+				if planned[i].phase == phase && deferred[i] == last {
+					auctions = append(auctions, plannedAuction{lrp: planned[i].lrp, task: planned[i].task, phase: phase})
+				}
+			}
+		}
+	}
+	return auctions
+}
+
+// greedyPlacements counts the auctions greedy scheduling places, by scheduling
+// copies of them on copies of the cells with a clone of the scheduler.  It
+// gives up as soon as exceeded reports the budget has run out.
+func (s *Scheduler) greedyPlacements(
+	lrpsBeforeTasks []auctiontypes.LRPAuction,
+	tasks []auctiontypes.TaskAuction,
+	lrpsAfterTasks []auctiontypes.LRPAuction,
+	exceeded func() bool,
+) (int, bool) {
+	logger := lager.NewLogger("batch-optimizer-trial")
+
+	zones := make(map[string]Zone, len(s.zones))
+	for name, zone := range s.zones {
+		cells := make(Zone, 0, len(zone))
+		for _, cell := range zone {
+			cells = append(cells, cell.copy(logger))
+		}
+		zones[name] = cells
+	}
+
+	request := auctiontypes.AuctionRequest{
+		LRPs:  make([]auctiontypes.LRPAuction, 0, len(lrpsBeforeTasks)+len(lrpsAfterTasks)),
+		Tasks: append([]auctiontypes.TaskAuction(nil), tasks...),
+	}
+	request.LRPs = append(request.LRPs, lrpsBeforeTasks...)
+	request.LRPs = append(request.LRPs, lrpsAfterTasks...)
+
+	trial := s.clone(zones, logger)
+	trial.optimizerBudget = 0
+	trial.stopped = exceeded
+	p := trial.place(request)
+	if exceeded() {
+		return 0, false
+	}
+	return len(p.successfulLRPs) + len(p.successfulTasks), true
+}
+
+// plan returns nil as soon as exceeded reports the budget has run out.
+func (p *batchPlanner) plan(auctions []plannedAuction, exceeded func() bool) *batchPlan {
+	plan := &batchPlan{auctions: auctions}
+
+	for i := range plan.auctions {
+		if exceeded() {
+			return nil
+		}
+
+		if p.scheduler.exceededInflightContainerCreation(p.currentInflight) {
+			continue
+		}
+
+		auction := &plan.auctions[i]
+		candidates := p.candidates(auction)
+		if len(candidates) == 0 {
+			continue
+		}
+
+		p.place(auction, p.choose(auction, candidates, plan.auctions[i+1:]))
+		plan.placed++
+		p.currentInflight++
+	}
+
+	return plan
+}
+
+// candidates returns the bins the auction fits in, in the order sortByFit
+// gives them.  LRPs are only planned in the zones with the fewest instances of
+// their process that have room for them, tasks with a grouping key in the
+// zones with the fewest tasks sharing it, and other tasks balanced across
+// zones in the zones with the fewest tasks.
+func (p *batchPlanner) candidates(auction *plannedAuction) []*planBin {
+	var groupingKey string
+	if auction.task != nil {
+		groupingKey = p.scheduler.groupingKey(auction.task)
+	}
+
+	if auction.task != nil && groupingKey == "" && p.scheduler.taskStrategy != BalanceTasksAcrossZones {
+		candidates := p.fittingBins(p.zoneNames, auction)
+		p.sortByFit(candidates, auction)
+		return candidates
+	}
+
+	balanced := map[string]int{}
+	for _, name := range p.zoneNames {
+		if auction.task != nil {
+			balanced[name] = p.tasksInZone(name, groupingKey)
+		} else {
+			balanced[name] = p.instancesInZone(name, auction.lrp.ProcessGuid)
+		}
+	}
+
+	zoneNames := append([]string(nil), p.zoneNames...)
+	sort.SliceStable(zoneNames, func(i, j int) bool {
+		return balanced[zoneNames[i]] < balanced[zoneNames[j]]
+	})
+
+	for start := 0; start < len(zoneNames); {
+		end := start + 1
+		for end < len(zoneNames) && balanced[zoneNames[end]] == balanced[zoneNames[start]] {
+			end++
+		}
+
+		candidates := p.fittingBins(zoneNames[start:end], auction)
+		if len(candidates) > 0 {
+			p.sortByFit(candidates, auction)
+			return candidates
+		}
+		start = end
+	}
+
+	return nil
+}
+
+func (p *batchPlanner) instancesInZone(name, processGuid string) int {
+	return p.scheduler.zoneInstances[name][processGuid] + p.zoneInstances[name][processGuid]
+}
+
+func (p *batchPlanner) tasksInZone(name, groupingKey string) int {
+	return p.scheduler.zoneTasks[name][groupingKey] + p.zoneTasks[name][groupingKey]
+}
+
+func (p *batchPlanner) fittingBins(zoneNames []string, auction *plannedAuction) []*planBin {
+	bins := []*planBin{}
+	for _, name := range zoneNames {
+		filtered := p.scheduler.filters.filter(name, p.scheduler.zones[name], auction.placementConstraint())
+		for _, cell := range filtered.cells {
+			bin := p.bins[cell]
+			if bin.fits(auction, binResources{}) {
+				bins = append(bins, bin)
+			}
+		}
+	}
+	return bins
+}
+
+// sortByFit orders the bins by the instances of the LRP's process they hold,
+// then by the memory and disk left once the auction is placed on them, least
+// first.  Tasks follow the task placement strategy: packed tasks are ordered
+// like LRPs, while other tasks are spread over the bins running the fewest
+// tasks, then over the ones with the most memory and disk left.
+func (p *batchPlanner) sortByFit(bins []*planBin, auction *plannedAuction) {
+	spread := auction.task != nil && p.scheduler.taskStrategy != PackTasks
+
+	sort.Slice(bins, func(i, j int) bool {
+		if auction.lrp != nil {
+			ii, ij := bins[i].processInstances(auction.lrp.ProcessGuid), bins[j].processInstances(auction.lrp.ProcessGuid)
+			if ii != ij {
+				return ii < ij
+			}
+		}
+		if spread {
+			ti, tj := bins[i].runningTasks(), bins[j].runningTasks()
+			if ti != tj {
+				return ti < tj
+			}
+		}
+
+		ri, rj := bins[i].footprint(auction), bins[j].footprint(auction)
+		mi, mj := bins[i].available.memoryMB-ri.MemoryMB, bins[j].available.memoryMB-rj.MemoryMB
+		if mi != mj {
+			return (mi < mj) != spread
+		}
+		di, dj := bins[i].available.diskMB-ri.DiskMB, bins[j].available.diskMB-rj.DiskMB
+		if di != dj {
+			return (di < dj) != spread
+		}
+		return bins[i].order < bins[j].order
+	})
+}
+
+// choose picks the candidate that leaves room for the most of the next
+// auctions, preferring the better fit on a tie.
+func (p *batchPlanner) choose(auction *plannedAuction, candidates []*planBin, next []plannedAuction) *planBin {
+	if p.lookahead <= 0 || len(candidates) == 1 || len(next) == 0 {
+		return candidates[0]
+	}
+
+	if len(candidates) > p.lookahead {
+		candidates = candidates[:p.lookahead]
+	}
+	if len(next) > p.lookahead {
+		next = next[:p.lookahead]
+	}
+
+	best, bestFits := candidates[0], -1
+	for _, candidate := range candidates {
+		fits := p.lookaheadFits(candidate, auction, next)
+		if fits > bestFits {
+			best, bestFits = candidate, fits
+		}
+	}
+	return best
+}
+
+// lookaheadFits counts how many of the next auctions still fit, first fit,
+// once the auction has been placed on the candidate.
+func (p *batchPlanner) lookaheadFits(candidate *planBin, auction *plannedAuction, next []plannedAuction) int {
+	consumed := map[*planBin]binResources{candidate: binResources{}.plus(candidate.footprint(auction))}
+
+	fits := 0
+	for i := range next {
+		nextAuction := &next[i]
+	zones:
+		for _, name := range p.zoneNames {
+			filtered := p.scheduler.filters.filter(name, p.scheduler.zones[name], nextAuction.placementConstraint())
+			for _, cell := range filtered.cells {
+				bin := p.bins[cell]
+				if bin.fits(nextAuction, consumed[bin]) {
+					consumed[bin] = consumed[bin].plus(bin.footprint(nextAuction))
+					fits++
+					break zones
+				}
+			}
+		}
+	}
+	return fits
+}
+
+func (p *batchPlanner) place(auction *plannedAuction, bin *planBin) {
+	bin.available = bin.available.minus(binResources{}.plus(bin.footprint(auction)))
+
+	pc := auction.placementConstraint()
+	for name, amount := range bin.cell.extendedRequest(&pc) {
+		if bin.extended == nil {
+			bin.extended = auctiontypes.ExtendedResources{}
+		}
+		bin.extended[name] += amount
+	}
+
+	auction.cell = bin.cell
+	auction.zone = bin.zone
+
+	if auction.lrp != nil {
+		if bin.instances == nil {
+			bin.instances = map[string]int{}
+		}
+		bin.instances[auction.lrp.ProcessGuid]++
+		p.zoneInstances[bin.zone][auction.lrp.ProcessGuid]++
+	} else {
+		bin.tasks++
+		p.zoneTasks.addTask(bin.zone, p.scheduler.groupingKey(auction.task))
+	}
+}
+
+// placePlannedLRP reserves the LRP on the cell the plan put it on.  LRPs the
+// plan left unplaced, or that the planned cell cannot take after all, are
+// scheduled as usual.
+func (s *Scheduler) placePlannedLRP(lrpAuction *auctiontypes.LRPAuction, planned *plannedAuction) (*auctiontypes.LRPAuction, error) {
+	if planned == nil || planned.cell == nil {
+		return s.scheduleLRPAuction(lrpAuction)
+	}
+
+	err := planned.cell.ReserveLRP(&lrpAuction.LRP)
+	if err != nil {
+		s.logger.Info("planned-cell-cannot-take-lrp", lager.Data{"cell-guid": planned.cell.Guid, "lrp-guid": lrpAuction.Identifier(), "error": err.Error()})
+		return s.scheduleLRPAuction(lrpAuction)
+	}
+
+	s.zoneInstances[planned.zone][lrpAuction.ProcessGuid]++
+	if planned.cell.exhausted() {
+		s.filters.invalidate()
+	}
+
+	winningAuction := lrpAuction.Copy()
+	winningAuction.Winner = planned.cell.Guid
+	winningAuction.Overcommitted = planned.cell.overcommitted()
+	return &winningAuction, nil
+}
+
+// placePlannedTask reserves the task on the cell the plan put it on, like
+// placePlannedLRP.
+func (s *Scheduler) placePlannedTask(taskAuction *auctiontypes.TaskAuction, planned *plannedAuction) (*auctiontypes.TaskAuction, error) {
+	if planned == nil || planned.cell == nil {
+		return s.scheduleTaskAuction(taskAuction, s.startingContainerWeight)
+	}
+
+	err := planned.cell.ReserveTask(&taskAuction.Task)
+	if err != nil {
+		s.logger.Info("planned-cell-cannot-take-task", lager.Data{"cell-guid": planned.cell.Guid, "task-guid": taskAuction.Identifier(), "error": err.Error()})
+		return s.scheduleTaskAuction(taskAuction, s.startingContainerWeight)
+	}
+
+	groupingKey := s.groupingKey(taskAuction)
+	planned.cell.addToTaskGroup(groupingKey, 1)
+	s.zoneTasks.addTask(planned.zone, groupingKey)
+
+	if planned.cell.exhausted() {
+		s.filters.invalidate()
+	}
+
+	winningAuction := taskAuction.Copy()
+	winningAuction.Winner = planned.cell.Guid
+	winningAuction.Overcommitted = planned.cell.overcommitted()
+	return &winningAuction, nil
+}
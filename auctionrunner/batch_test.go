@@ -23,6 +23,20 @@ var _ = Describe("Batch", func() {
 		batch = auctionrunner.NewBatch(clock)
 	})
 
+	groupedTaskAuction := func(taskGuid, taskGroup string) auctiontypes.TaskAuction {
+		auction := BuildTaskAuction(BuildTask(taskGuid, "domain", "linux", 10, 10, 10, []string{}, []string{}), clock.Now())
+		auction.TaskGroup = taskGroup
+		return auction
+	}
+
+	taskGuids := func(auctions []auctiontypes.TaskAuction) []string {
+		guids := []string{}
+		for _, auction := range auctions {
+			guids = append(guids, auction.TaskGuid)
+		}
+		return guids
+	}
+
 	It("should start off empty", func() {
 		Expect(batch.HasWork).NotTo(Receive())
 		starts, tasks := batch.DedupeAndDrain()
@@ -64,6 +78,342 @@ var _ = Describe("Batch", func() {
 		})
 	})
 
+	Describe("adding auctions with an existing record", func() {
+		var lrpAuction auctiontypes.LRPAuction
+		var taskAuction auctiontypes.TaskAuction
+
+		BeforeEach(func() {
+			queueTime := clock.Now().Add(-time.Hour)
+
+			lrpAuction = BuildLRPAuction("pg-1", "domain", 0, "linux", 10, 10, 10, queueTime, []string{}, []string{})
+			lrpAuction.Attempts = 3
+			taskAuction = BuildTaskAuction(BuildTask("tg-1", "domain", "linux", 10, 10, 10, []string{}, []string{}), queueTime)
+			taskAuction.Attempts = 2
+
+			batch.AddLRPAuctions([]auctiontypes.LRPAuction{lrpAuction})
+			batch.AddTaskAuctions([]auctiontypes.TaskAuction{taskAuction})
+		})
+
+		It("keeps their queue time and attempts", func() {
+			lrpAuctions, taskAuctions := batch.DedupeAndDrain()
+			Expect(lrpAuctions).To(Equal([]auctiontypes.LRPAuction{lrpAuction}))
+			Expect(taskAuctions).To(Equal([]auctiontypes.TaskAuction{taskAuction}))
+		})
+
+		It("should have work", func() {
+			Expect(batch.HasWork).To(Receive())
+		})
+	})
+
+	Describe("Pending", func() {
+		BeforeEach(func() {
+			batch.AddLRPStarts([]auctioneer.LRPStartRequest{
+				BuildLRPStartRequest("pg-1", "domain", []int{0, 1}, "linux", 10, 10, 10, []string{}, []string{}),
+			})
+			clock.Increment(time.Minute)
+			batch.AddTasks([]auctioneer.TaskStartRequest{
+				BuildTaskStartRequest("tg-1", "domain", "linux", 10, 10, 10),
+			})
+		})
+
+		It("returns the pending auctions along with their queue times", func() {
+			lrpAuctions, taskAuctions := batch.Pending()
+			Expect(lrpAuctions).To(Equal([]auctiontypes.LRPAuction{
+				BuildLRPAuction("pg-1", "domain", 0, "linux", 10, 10, 10, clock.Now().Add(-time.Minute), []string{}, []string{}),
+				BuildLRPAuction("pg-1", "domain", 1, "linux", 10, 10, 10, clock.Now().Add(-time.Minute), []string{}, []string{}),
+			}))
+			Expect(taskAuctions).To(Equal([]auctiontypes.TaskAuction{
+				BuildTaskAuction(BuildTask("tg-1", "domain", "linux", 10, 10, 10, []string{}, []string{}), clock.Now()),
+			}))
+		})
+
+		It("does not drain the batch", func() {
+			batch.Pending()
+			Expect(batch.HasWork).To(Receive())
+			lrpAuctions, taskAuctions := batch.DedupeAndDrain()
+			Expect(lrpAuctions).To(HaveLen(2))
+			Expect(taskAuctions).To(HaveLen(1))
+		})
+	})
+
+	Describe("cancelling work", func() {
+		BeforeEach(func() {
+			batch.AddLRPStarts([]auctioneer.LRPStartRequest{
+				BuildLRPStartRequest("pg-1", "domain", []int{0, 1, 2}, "linux", 10, 10, 10, []string{}, []string{}),
+				BuildLRPStartRequest("pg-2", "domain", []int{1}, "linux", 10, 10, 10, []string{}, []string{}),
+			})
+			batch.AddTasks([]auctioneer.TaskStartRequest{
+				BuildTaskStartRequest("tg-1", "domain", "linux", 10, 10, 10),
+				BuildTaskStartRequest("tg-2", "domain", "linux", 10, 10, 10),
+			})
+		})
+
+		Describe("CancelLRPStarts", func() {
+			It("removes only the requested indices of the process", func() {
+				cancelled := batch.CancelLRPStarts("pg-1", []int{1, 2, 5})
+				Expect(cancelled).To(Equal([]auctiontypes.LRPAuction{
+					BuildLRPAuction("pg-1", "domain", 1, "linux", 10, 10, 10, clock.Now(), []string{}, []string{}),
+					BuildLRPAuction("pg-1", "domain", 2, "linux", 10, 10, 10, clock.Now(), []string{}, []string{}),
+				}))
+
+				lrpAuctions, _ := batch.DedupeAndDrain()
+				Expect(lrpAuctions).To(Equal([]auctiontypes.LRPAuction{
+					BuildLRPAuction("pg-1", "domain", 0, "linux", 10, 10, 10, clock.Now(), []string{}, []string{}),
+					BuildLRPAuction("pg-2", "domain", 1, "linux", 10, 10, 10, clock.Now(), []string{}, []string{}),
+				}))
+			})
+
+			It("returns nothing when no pending auction matches", func() {
+				Expect(batch.CancelLRPStarts("pg-3", []int{0})).To(BeEmpty())
+			})
+		})
+
+		Describe("CancelTasks", func() {
+			It("removes the requested tasks", func() {
+				cancelled := batch.CancelTasks([]string{"tg-2", "tg-3"})
+				Expect(cancelled).To(Equal([]auctiontypes.TaskAuction{
+					BuildTaskAuction(BuildTask("tg-2", "domain", "linux", 10, 10, 10, []string{}, []string{}), clock.Now()),
+				}))
+
+				_, taskAuctions := batch.DedupeAndDrain()
+				Expect(taskAuctions).To(Equal([]auctiontypes.TaskAuction{
+					BuildTaskAuction(BuildTask("tg-1", "domain", "linux", 10, 10, 10, []string{}, []string{}), clock.Now()),
+				}))
+			})
+
+			It("cancels the whole task group of a cancelled task", func() {
+				batch.AddTaskAuctions([]auctiontypes.TaskAuction{
+					groupedTaskAuction("wf-1", "workflow"),
+					groupedTaskAuction("wf-2", "workflow"),
+				})
+
+				cancelled := batch.CancelTasks([]string{"wf-2"})
+				Expect(taskGuids(cancelled)).To(Equal([]string{"wf-1", "wf-2"}))
+
+				_, taskAuctions := batch.DedupeAndDrain()
+				Expect(taskGuids(taskAuctions)).To(Equal([]string{"tg-1", "tg-2"}))
+			})
+		})
+
+		It("should no longer have work once everything has been cancelled", func() {
+			batch.CancelLRPStarts("pg-1", []int{0, 1, 2})
+			batch.CancelLRPStarts("pg-2", []int{1})
+			batch.CancelTasks([]string{"tg-1", "tg-2"})
+			Expect(batch.HasWork).NotTo(Receive())
+		})
+
+		It("should still have work while anything remains", func() {
+			batch.CancelLRPStarts("pg-1", []int{0, 1, 2})
+			batch.CancelTasks([]string{"tg-1", "tg-2"})
+			Expect(batch.HasWork).To(Receive())
+		})
+	})
+
+	Describe("bounded batches", func() {
+		var overflowPolicy auctionrunner.OverflowPolicy
+
+		JustBeforeEach(func() {
+			batch = auctionrunner.NewBoundedBatch(clock, 2, overflowPolicy)
+			batch.AddLRPStarts([]auctioneer.LRPStartRequest{
+				BuildLRPStartRequest("pg-1", "domain", []int{0}, "linux", 10, 10, 10, []string{}, []string{}),
+			})
+			clock.Increment(time.Second)
+			batch.AddTasks([]auctioneer.TaskStartRequest{
+				BuildTaskStartRequest("tg-1", "domain", "linux", 10, 10, 10),
+			})
+			clock.Increment(time.Second)
+		})
+
+		Context("when rejecting the newest work", func() {
+			BeforeEach(func() {
+				overflowPolicy = auctionrunner.RejectNewest
+			})
+
+			It("turns away the auctions that do not fit", func() {
+				err := batch.AddLRPStarts([]auctioneer.LRPStartRequest{
+					BuildLRPStartRequest("pg-2", "domain", []int{0, 1}, "linux", 10, 10, 10, []string{}, []string{}),
+				})
+				Expect(err).To(Equal(auctiontypes.ErrorBatchFull))
+
+				turnedAway := batch.DrainTurnedAway()
+				Expect(turnedAway.FailedLRPs).To(Equal([]auctiontypes.LRPAuction{
+					BuildLRPAuctionWithPlacementError("pg-2", "domain", 0, "linux", 10, 10, 10, clock.Now(), auctiontypes.ErrorBatchFull.Error(), []string{}, []string{}),
+					BuildLRPAuctionWithPlacementError("pg-2", "domain", 1, "linux", 10, 10, 10, clock.Now(), auctiontypes.ErrorBatchFull.Error(), []string{}, []string{}),
+				}))
+				Expect(turnedAway.FailedTasks).To(BeEmpty())
+				Expect(batch.DrainTurnedAway().FailedLRPs).To(BeEmpty())
+
+				lrpAuctions, taskAuctions := batch.DedupeAndDrain()
+				Expect(lrpAuctions).To(HaveLen(1))
+				Expect(lrpAuctions[0].ProcessGuid).To(Equal("pg-1"))
+				Expect(taskAuctions).To(HaveLen(1))
+			})
+
+			It("turns away a task group that does not fit as a whole", func() {
+				batch.DedupeAndDrain()
+				err := batch.AddTaskAuctions([]auctiontypes.TaskAuction{
+					groupedTaskAuction("wf-1", "workflow"),
+					groupedTaskAuction("wf-2", "workflow"),
+					groupedTaskAuction("wf-3", "workflow"),
+				})
+				Expect(err).To(Equal(auctiontypes.ErrorBatchFull))
+				Expect(taskGuids(batch.DrainTurnedAway().FailedTasks)).To(Equal([]string{"wf-1", "wf-2", "wf-3"}))
+
+				_, taskAuctions := batch.DedupeAndDrain()
+				Expect(taskAuctions).To(BeEmpty())
+			})
+
+			It("turns away the queued tasks of a task group with the ones that do not fit", func() {
+				batch.DedupeAndDrain()
+				batch.AddTaskAuctions([]auctiontypes.TaskAuction{groupedTaskAuction("wf-1", "workflow")})
+				batch.AddTasks([]auctioneer.TaskStartRequest{BuildTaskStartRequest("tg-2", "domain", "linux", 10, 10, 10)})
+
+				err := batch.AddTaskAuctions([]auctiontypes.TaskAuction{groupedTaskAuction("wf-2", "workflow")})
+				Expect(err).To(Equal(auctiontypes.ErrorBatchFull))
+				Expect(taskGuids(batch.DrainTurnedAway().FailedTasks)).To(ConsistOf("wf-1", "wf-2"))
+
+				_, taskAuctions := batch.DedupeAndDrain()
+				Expect(taskGuids(taskAuctions)).To(Equal([]string{"tg-2"}))
+			})
+
+			It("accepts work again once the batch has been drained", func() {
+				batch.DedupeAndDrain()
+				err := batch.AddTasks([]auctioneer.TaskStartRequest{
+					BuildTaskStartRequest("tg-2", "domain", "linux", 10, 10, 10),
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				turnedAway := batch.DrainTurnedAway()
+				Expect(turnedAway.FailedLRPs).To(BeEmpty())
+				Expect(turnedAway.FailedTasks).To(BeEmpty())
+			})
+		})
+
+		Context("when dropping the oldest work", func() {
+			BeforeEach(func() {
+				overflowPolicy = auctionrunner.DropOldest
+			})
+
+			It("evicts the longest queued auctions to make room", func() {
+				err := batch.AddTasks([]auctioneer.TaskStartRequest{
+					BuildTaskStartRequest("tg-2", "domain", "linux", 10, 10, 10),
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				turnedAway := batch.DrainTurnedAway()
+				Expect(turnedAway.FailedLRPs).To(Equal([]auctiontypes.LRPAuction{
+					BuildLRPAuctionWithPlacementError("pg-1", "domain", 0, "linux", 10, 10, 10, clock.Now().Add(-2*time.Second), auctiontypes.ErrorBatchFull.Error(), []string{}, []string{}),
+				}))
+				Expect(turnedAway.FailedTasks).To(BeEmpty())
+
+				err = batch.AddTasks([]auctioneer.TaskStartRequest{
+					BuildTaskStartRequest("tg-3", "domain", "linux", 10, 10, 10),
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				evictedTask := BuildTaskAuction(BuildTask("tg-1", "domain", "linux", 10, 10, 10, []string{}, []string{}), clock.Now().Add(-time.Second))
+				evictedTask.PlacementError = auctiontypes.ErrorBatchFull.Error()
+				Expect(batch.DrainTurnedAway().FailedTasks).To(Equal([]auctiontypes.TaskAuction{evictedTask}))
+
+				_, taskAuctions := batch.DedupeAndDrain()
+				Expect(taskAuctions).To(HaveLen(2))
+				Expect(taskAuctions[0].TaskGuid).To(Equal("tg-2"))
+				Expect(taskAuctions[1].TaskGuid).To(Equal("tg-3"))
+			})
+
+			It("evicts a task group as a whole", func() {
+				batch.DedupeAndDrain()
+				batch.AddTaskAuctions([]auctiontypes.TaskAuction{
+					groupedTaskAuction("wf-1", "workflow"),
+					groupedTaskAuction("wf-2", "workflow"),
+				})
+
+				err := batch.AddTasks([]auctioneer.TaskStartRequest{BuildTaskStartRequest("tg-2", "domain", "linux", 10, 10, 10)})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(taskGuids(batch.DrainTurnedAway().FailedTasks)).To(Equal([]string{"wf-1", "wf-2"}))
+
+				_, taskAuctions := batch.DedupeAndDrain()
+				Expect(taskGuids(taskAuctions)).To(Equal([]string{"tg-2"}))
+			})
+
+			It("returns ErrorBatchFull when the work being added is itself evicted", func() {
+				err := batch.AddTasks([]auctioneer.TaskStartRequest{
+					BuildTaskStartRequest("tg-2", "domain", "linux", 10, 10, 10),
+					BuildTaskStartRequest("tg-3", "domain", "linux", 10, 10, 10),
+					BuildTaskStartRequest("tg-4", "domain", "linux", 10, 10, 10),
+				})
+				Expect(err).To(Equal(auctiontypes.ErrorBatchFull))
+
+				turnedAway := batch.DrainTurnedAway()
+				Expect(turnedAway.FailedLRPs).To(HaveLen(1))
+				Expect(turnedAway.FailedTasks).To(HaveLen(2))
+				Expect(turnedAway.FailedTasks[1].TaskGuid).To(Equal("tg-2"))
+			})
+		})
+
+		Context("when blocking until the batch is drained", func() {
+			BeforeEach(func() {
+				overflowPolicy = auctionrunner.BlockUntilDrained
+			})
+
+			It("waits for room before adding the work", func() {
+				added := make(chan error)
+				go func() {
+					added <- batch.AddTasks([]auctioneer.TaskStartRequest{
+						BuildTaskStartRequest("tg-2", "domain", "linux", 10, 10, 10),
+					})
+				}()
+
+				Consistently(added).ShouldNot(Receive())
+
+				lrpAuctions, taskAuctions := batch.DedupeAndDrain()
+				Expect(lrpAuctions).To(HaveLen(1))
+				Expect(taskAuctions).To(HaveLen(1))
+
+				var err error
+				Eventually(added).Should(Receive(&err))
+				Expect(err).NotTo(HaveOccurred())
+
+				_, taskAuctions = batch.DedupeAndDrain()
+				Expect(taskAuctions).To(HaveLen(1))
+				Expect(taskAuctions[0].TaskGuid).To(Equal("tg-2"))
+			})
+
+			It("wakes up when pending work is cancelled", func() {
+				added := make(chan error)
+				go func() {
+					added <- batch.AddTasks([]auctioneer.TaskStartRequest{
+						BuildTaskStartRequest("tg-2", "domain", "linux", 10, 10, 10),
+					})
+				}()
+
+				Consistently(added).ShouldNot(Receive())
+				batch.CancelTasks([]string{"tg-1"})
+				Eventually(added).Should(Receive())
+			})
+
+			It("wakes up and refuses the work when the batch is closed", func() {
+				added := make(chan error)
+				go func() {
+					added <- batch.AddTasks([]auctioneer.TaskStartRequest{
+						BuildTaskStartRequest("tg-2", "domain", "linux", 10, 10, 10),
+					})
+				}()
+
+				Consistently(added).ShouldNot(Receive())
+				batch.Close()
+
+				var err error
+				Eventually(added).Should(Receive(&err))
+				Expect(err).To(Equal(auctiontypes.ErrorShuttingDown))
+
+				_, taskAuctions := batch.DedupeAndDrain()
+				Expect(taskAuctions).To(HaveLen(1))
+				Expect(taskAuctions[0].TaskGuid).To(Equal("tg-1"))
+			})
+		})
+	})
+
 	Describe("DedupeAndDrain", func() {
 		BeforeEach(func() {
 			batch.AddLRPStarts([]auctioneer.LRPStartRequest{
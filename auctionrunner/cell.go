@@ -1,6 +1,7 @@
 package auctionrunner
 
 import (
+	"code.cloudfoundry.org/auction/auctiontypes"
 	"code.cloudfoundry.org/lager"
 	"code.cloudfoundry.org/rep"
 )
@@ -14,18 +15,304 @@ type Cell struct {
 	state  rep.CellState
 	Index  int
 
-	workToCommit rep.Work
+	workToCommit  rep.Work
+	committedWork rep.Work
+	failedWork    rep.Work
+	staleWork     rep.Work
+	rejectedWork  bool
+
+	// processInstances counts the LRP instances of each process guid on the cell
+	processInstances map[string]int
+
+	// pidCapacity is the number of pids the containers on the cell may use in
+	// total, 0 when the pids of the cell are not tracked
+	pidCapacity   int32
+	availablePids int32
+
+	// extendedRequests gives the extended resources work on the cell takes up,
+	// nil when the extended resources of the cell are not tracked
+	extendedRequests  auctiontypes.ExtendedResourceRequests
+	extendedCapacity  auctiontypes.ExtendedResources
+	availableExtended auctiontypes.ExtendedResources
+
+	// overcommit is what overcommitting the cell adds to the resources in its
+	// state
+	overcommit rep.Resources
+
+	// headroom is the part of the resources of the cell only the work
+	// urgentLRP and urgentTask pick may use
+	headroom   rep.Resources
+	urgentLRP  func(lrp *rep.LRP) bool
+	urgentTask func(task *rep.Task) bool
+
+	// overhead is what each container takes up on the cell on top of its own
+	// resources
+	overhead auctiontypes.ContainerOverhead
+
+	// taskGroups counts the tasks of each grouping key on the cell
+	taskGroups map[string]int
+
+	// version is the version of the state of cells with a VersionedCellClient
+	version         uint64
+	cacheGeneration uint64
 }
 
 func NewCell(logger lager.Logger, guid string, client rep.Client, state rep.CellState) *Cell {
+	processInstances := map[string]int{}
+	for i := range state.LRPs {
+		processInstances[state.LRPs[i].ProcessGuid]++
+	}
+
 	return &Cell{
-		logger:       logger,
-		Guid:         guid,
-		client:       client,
-		state:        state,
-		Index:        state.CellIndex,
-		workToCommit: rep.Work{CellID: guid},
+		logger:           logger,
+		Guid:             guid,
+		client:           client,
+		state:            state,
+		Index:            state.CellIndex,
+		workToCommit:     rep.Work{CellID: guid},
+		processInstances: processInstances,
+	}
+}
+
+// copy returns a cell with the same state that work can be reserved on
+// without affecting this one.
+func (c *Cell) copy(logger lager.Logger) *Cell {
+	state := c.state
+	state.LRPs = append([]rep.LRP(nil), c.state.LRPs...)
+	state.Tasks = append([]rep.Task(nil), c.state.Tasks...)
+
+	cell := NewCell(logger, c.Guid, c.client, state)
+	cell.Index = c.Index
+	cell.pidCapacity = c.pidCapacity
+	cell.availablePids = c.availablePids
+	cell.extendedRequests = c.extendedRequests
+	cell.extendedCapacity = c.extendedCapacity
+	cell.availableExtended = copyExtendedResources(c.availableExtended)
+	cell.overcommit = c.overcommit
+	cell.headroom = c.headroom
+	cell.urgentLRP = c.urgentLRP
+	cell.urgentTask = c.urgentTask
+	cell.overhead = c.overhead
+	for key, tasks := range c.taskGroups {
+		cell.addToTaskGroup(key, tasks)
+	}
+	return cell
+}
+
+// trackPids limits the pids the containers on the cell may use in total to
+// capacity, taking out the ones its LRPs and tasks already use.  A capacity
+// of 0 or less leaves them unlimited.
+func (c *Cell) trackPids(capacity int32) {
+	if capacity <= 0 {
+		c.pidCapacity = 0
+		c.availablePids = 0
+		return
+	}
+
+	c.pidCapacity = capacity
+	c.availablePids = capacity
+	for i := range c.state.LRPs {
+		c.availablePids -= c.state.LRPs[i].MaxPids
+	}
+	for i := range c.state.Tasks {
+		c.availablePids -= c.state.Tasks[i].MaxPids
+	}
+}
+
+func (c *Cell) tracksPids() bool {
+	return c.pidCapacity > 0
+}
+
+// trackExtendedResources gives the cell the extended resources in capacity,
+// taking out the ones its LRPs and tasks already take up as given by
+// requests.
+func (c *Cell) trackExtendedResources(capacity auctiontypes.ExtendedResources, requests auctiontypes.ExtendedResourceRequests) {
+	c.extendedRequests = requests
+	c.extendedCapacity = capacity
+	c.availableExtended = auctiontypes.ExtendedResources{}
+	for name, amount := range capacity {
+		c.availableExtended[name] = amount
+	}
+	for i := range c.state.LRPs {
+		c.useExtendedResources(&c.state.LRPs[i].PlacementConstraint)
+	}
+	for i := range c.state.Tasks {
+		c.useExtendedResources(&c.state.Tasks[i].PlacementConstraint)
+	}
+}
+
+// applyOvercommit adds what overcommitting the resources the cell reports by
+// ratios gives to its total and available resources, in place of what any
+// earlier ratios added.
+func (c *Cell) applyOvercommit(ratios OvercommitRatios) {
+	reported := c.reportedResources()
+	extra := ratios.overcommit(reported)
+	c.state.TotalResources = rep.NewResources(
+		reported.MemoryMB+extra.MemoryMB,
+		reported.DiskMB+extra.DiskMB,
+		reported.Containers+extra.Containers,
+	)
+	c.state.AvailableResources = rep.NewResources(
+		c.state.AvailableResources.MemoryMB-c.overcommit.MemoryMB+extra.MemoryMB,
+		c.state.AvailableResources.DiskMB-c.overcommit.DiskMB+extra.DiskMB,
+		c.state.AvailableResources.Containers-c.overcommit.Containers+extra.Containers,
+	)
+	c.overcommit = extra
+}
+
+// overcommitted reports whether the work on the cell takes up more of a
+// resource than the cell reports.
+func (c *Cell) overcommitted() bool {
+	available := c.state.AvailableResources
+	return available.MemoryMB < c.overcommit.MemoryMB ||
+		available.DiskMB < c.overcommit.DiskMB ||
+		available.Containers < c.overcommit.Containers
+}
+
+// extendedRequest is the extended resources work with the placement
+// constraint takes up on the cell.
+func (c *Cell) extendedRequest(pc *rep.PlacementConstraint) auctiontypes.ExtendedResources {
+	if c.extendedRequests == nil {
+		return nil
+	}
+	return c.extendedRequests(*pc)
+}
+
+// MatchExtendedResources reports whether the cell has every extended resource
+// work with the placement constraint asks for, regardless of how much of it
+// is left.
+func (c *Cell) MatchExtendedResources(pc *rep.PlacementConstraint) bool {
+	for name, amount := range c.extendedRequest(pc) {
+		if _, ok := c.extendedCapacity[name]; amount > 0 && !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// reportedResources are the total resources the cell reports, before any
+// overcommit.
+func (c *Cell) reportedResources() rep.Resources {
+	return rep.NewResources(
+		c.state.TotalResources.MemoryMB-c.overcommit.MemoryMB,
+		c.state.TotalResources.DiskMB-c.overcommit.DiskMB,
+		c.state.TotalResources.Containers-c.overcommit.Containers,
+	)
+}
+
+// reportedState is the state of the cell, with the work reserved on it, as the
+// cell reports it: without any overcommit.
+func (c *Cell) reportedState() rep.CellState {
+	state := c.state
+	state.TotalResources = c.reportedResources()
+	state.AvailableResources = rep.NewResources(
+		c.state.AvailableResources.MemoryMB-c.overcommit.MemoryMB,
+		c.state.AvailableResources.DiskMB-c.overcommit.DiskMB,
+		c.state.AvailableResources.Containers-c.overcommit.Containers,
+	)
+	return state
+}
+
+// resourceMatch is rep.CellState.ResourceMatch, which also reports the
+// "pids" problem when the cell tracks its pids and has too few left, and a
+// problem named after each extended resource the work needs more of than is
+// left.  Unless useHeadroom is set, the headroom of the cell counts as taken.
+func (c *Cell) resourceMatch(res *rep.Resource, pc *rep.PlacementConstraint, useHeadroom bool) error {
+	var problems map[string]struct{}
+	addProblem := func(problem string) {
+		if problems == nil {
+			problems = map[string]struct{}{}
+		}
+		problems[problem] = struct{}{}
+	}
+
+	if !useHeadroom {
+		padded := *res
+		padded.MemoryMB += c.headroom.MemoryMB
+		padded.DiskMB += c.headroom.DiskMB
+		res = &padded
+
+		if c.state.AvailableResources.Containers-c.headroom.Containers < 1 {
+			addProblem("containers")
+		}
+	}
+	err := c.state.ResourceMatch(res)
+
+	if c.tracksPids() && c.availablePids < res.MaxPids {
+		addProblem("pids")
+	}
+	for name, amount := range c.extendedRequest(pc) {
+		if c.availableExtended[name] < amount {
+			addProblem(name)
+		}
+	}
+	if problems == nil {
+		return err
+	}
+
+	if ierr, ok := err.(rep.InsufficientResourcesError); ok {
+		for problem := range ierr.Problems {
+			problems[problem] = struct{}{}
+		}
+	}
+	return rep.InsufficientResourcesError{Problems: problems}
+}
+
+// lrpMayUseHeadroom reports whether the LRP is urgent enough to be placed in
+// the headroom of the cell.
+func (c *Cell) lrpMayUseHeadroom(lrp *rep.LRP) bool {
+	return c.urgentLRP != nil && c.urgentLRP(lrp)
+}
+
+// taskMayUseHeadroom reports whether the task is urgent enough to be placed
+// in the headroom of the cell.
+func (c *Cell) taskMayUseHeadroom(task *rep.Task) bool {
+	return c.urgentTask != nil && c.urgentTask(task)
+}
+
+// usePids takes the pids of a container placed on the cell out of the ones
+// left.
+func (c *Cell) usePids(res *rep.Resource) {
+	if c.tracksPids() {
+		c.availablePids -= res.MaxPids
+	}
+}
+
+// useExtendedResources takes the extended resources of work placed on the
+// cell out of the ones left.
+func (c *Cell) useExtendedResources(pc *rep.PlacementConstraint) {
+	for name, amount := range c.extendedRequest(pc) {
+		c.availableExtended[name] -= amount
+	}
+}
+
+// extendedShares is the share of each extended resource work with the
+// placement constraint asks for that the cell would have in use once the
+// work is placed.
+func (c *Cell) extendedShares(pc *rep.PlacementConstraint) map[string]float64 {
+	var shares map[string]float64
+	for name, amount := range c.extendedRequest(pc) {
+		capacity := c.extendedCapacity[name]
+		if amount <= 0 || capacity <= 0 {
+			continue
+		}
+		if shares == nil {
+			shares = map[string]float64{}
+		}
+		shares[name] = 1.0 - float64(c.availableExtended[name]-amount)/float64(capacity)
+	}
+	return shares
+}
+
+func copyExtendedResources(resources auctiontypes.ExtendedResources) auctiontypes.ExtendedResources {
+	if resources == nil {
+		return nil
+	}
+	copied := make(auctiontypes.ExtendedResources, len(resources))
+	for name, amount := range resources {
+		copied[name] = amount
 	}
+	return copied
 }
 
 func (c *Cell) StartingContainerCount() int {
@@ -44,32 +331,78 @@ func (c *Cell) MatchPlacementTags(placementTags []string) bool {
 	return c.state.MatchPlacementTags(placementTags)
 }
 
+// exhausted reports whether the cell has no room for another container.
+func (c *Cell) exhausted() bool {
+	return c.state.AvailableResources.Containers < 1
+}
+
 func (c *Cell) State() rep.CellState {
 	return c.state
 }
 
-func (c *Cell) ScoreForLRP(lrp *rep.LRP, startingContainerWeight, binPackFirstFitWeight float64) (float64, error) {
-	proxiedLRP := rep.Resource{
-		MemoryMB: lrp.Resource.MemoryMB + int32(c.state.ProxyMemoryAllocationMB),
-		DiskMB:   lrp.Resource.DiskMB,
-		MaxPids:  lrp.Resource.MaxPids,
+// trackTaskGroups counts the tasks on the cell by the grouping key key gives
+// them.
+func (c *Cell) trackTaskGroups(key func(task *rep.Task) string) {
+	c.taskGroups = nil
+	for i := range c.state.Tasks {
+		c.addToTaskGroup(key(&c.state.Tasks[i]), 1)
 	}
+}
 
-	err := c.state.ResourceMatch(&proxiedLRP)
-	if err != nil {
-		return 0, err
+func (c *Cell) addToTaskGroup(key string, tasks int) {
+	if key == "" {
+		return
+	}
+	if c.taskGroups == nil {
+		c.taskGroups = map[string]int{}
 	}
+	c.taskGroups[key] += tasks
+}
 
-	numberOfInstancesWithMatchingProcessGuid := 0
-	for i := range c.state.LRPs {
-		if c.state.LRPs[i].ProcessGuid == lrp.ProcessGuid {
-			numberOfInstancesWithMatchingProcessGuid++
-		}
+// lrpFootprint is what the LRP takes up on the cell, overhead included.
+func (c *Cell) lrpFootprint(lrp *rep.LRP) rep.Resource {
+	return c.overhead.LRPResource(lrp.Resource, c.state.ProxyMemoryAllocationMB)
+}
+
+// taskFootprint is what the task takes up on the cell, overhead included.
+func (c *Cell) taskFootprint(task *rep.Task) rep.Resource {
+	return c.overhead.TaskResource(task.Resource)
+}
+
+// addLRP adds the LRP to the state of the cell along with its overhead.
+func (c *Cell) addLRP(lrp *rep.LRP) {
+	c.state.AddLRP(lrp)
+	c.takeOverhead(c.lrpFootprint(lrp), lrp.Resource)
+}
+
+// addTask adds the task to the state of the cell along with its overhead.
+func (c *Cell) addTask(task *rep.Task) {
+	c.state.AddTask(task)
+	c.takeOverhead(c.taskFootprint(task), task.Resource)
+}
+
+func (c *Cell) takeOverhead(footprint, res rep.Resource) {
+	c.state.AvailableResources.MemoryMB -= footprint.MemoryMB - res.MemoryMB
+	c.state.AvailableResources.DiskMB -= footprint.DiskMB - res.DiskMB
+}
+
+func (c *Cell) ScoreForLRP(lrp *rep.LRP, startingContainerWeight, binPackFirstFitWeight float64) (float64, error) {
+	return c.scoreForLRP(lrp, startingContainerWeight, binPackFirstFitWeight, nil)
+}
+
+// scoreForLRP scores the cell like ScoreForLRP, weighing its resources with
+// weights when they are given.
+func (c *Cell) scoreForLRP(lrp *rep.LRP, startingContainerWeight, binPackFirstFitWeight float64, weights *ResourceWeights) (float64, error) {
+	footprint := c.lrpFootprint(lrp)
+
+	err := c.resourceMatch(&footprint, &lrp.PlacementConstraint, c.lrpMayUseHeadroom(lrp))
+	if err != nil {
+		return 0, err
 	}
 
-	localityScore := LocalityOffset * numberOfInstancesWithMatchingProcessGuid
+	localityScore := LocalityOffset * c.processInstances[lrp.ProcessGuid]
 
-	resourceScore := c.state.ComputeScore(&proxiedLRP, startingContainerWeight)
+	resourceScore := c.resourceScore(&footprint, &lrp.PlacementConstraint, startingContainerWeight, weights)
 
 	indexScore := float64(c.Index) * binPackFirstFitWeight
 
@@ -85,50 +418,200 @@ func (c *Cell) ScoreForLRP(lrp *rep.LRP, startingContainerWeight, binPackFirstFi
 }
 
 func (c *Cell) ScoreForTask(task *rep.Task, startingContainerWeight float64) (float64, error) {
-	err := c.state.ResourceMatch(&task.Resource)
+	return c.scoreForTask(task, startingContainerWeight, nil)
+}
+
+func (c *Cell) scoreForTask(task *rep.Task, startingContainerWeight float64, weights *ResourceWeights) (float64, error) {
+	footprint := c.taskFootprint(task)
+
+	err := c.resourceMatch(&footprint, &task.PlacementConstraint, c.taskMayUseHeadroom(task))
 	if err != nil {
 		return 0, err
 	}
 
 	localityScore := LocalityOffset * len(c.state.Tasks)
-	resourceScore := c.state.ComputeScore(&task.Resource, startingContainerWeight)
+	resourceScore := c.resourceScore(&footprint, &task.PlacementConstraint, startingContainerWeight, weights)
 	return resourceScore + float64(localityScore), nil
 }
 
+// scoreForTaskGroup scores the cell for the last of the tasks as if the others
+// were already reserved on it, so that only cells that can take them all
+// together score.
+func (c *Cell) scoreForTaskGroup(tasks []*rep.Task, pack bool, startingContainerWeight, binPackFirstFitWeight float64, weights *ResourceWeights) (float64, error) {
+	trial := c.copy(c.logger)
+	last := len(tasks) - 1
+	for _, task := range tasks[:last] {
+		err := trial.ReserveTask(task)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if pack {
+		return trial.packScoreForTask(tasks[last], startingContainerWeight, binPackFirstFitWeight, weights)
+	}
+	return trial.scoreForTask(tasks[last], startingContainerWeight, weights)
+}
+
+// packScoreForTask scores the cell for packing tasks: the fuller the cell
+// would be, and the lower its index when binPackFirstFitWeight is given, the
+// better, regardless of the tasks it already runs.
+func (c *Cell) packScoreForTask(task *rep.Task, startingContainerWeight, binPackFirstFitWeight float64, weights *ResourceWeights) (float64, error) {
+	footprint := c.taskFootprint(task)
+
+	err := c.resourceMatch(&footprint, &task.PlacementConstraint, c.taskMayUseHeadroom(task))
+	if err != nil {
+		return 0, err
+	}
+
+	freeScore := 1.0 - c.resourceScore(&footprint, &task.PlacementConstraint, 0, weights)
+	startingContainerScore := float64(c.state.StartingContainerCount) * startingContainerWeight
+	indexScore := float64(c.Index) * binPackFirstFitWeight
+	return freeScore + startingContainerScore + indexScore, nil
+}
+
+// resourceScore is rep.CellState.ComputeScore with the resources weighed by
+// weights, when they are given.  The extended resources the work asks for
+// count alongside memory, disk and containers.
+func (c *Cell) resourceScore(res *rep.Resource, pc *rep.PlacementConstraint, startingContainerWeight float64, weights *ResourceWeights) float64 {
+	extendedShares := c.extendedShares(pc)
+	if weights == nil && len(extendedShares) == 0 {
+		return c.state.ComputeScore(res, startingContainerWeight)
+	}
+	if weights == nil {
+		weights = equalResourceWeights(extendedShares)
+	}
+
+	remainingResources := c.state.AvailableResources.Copy()
+	remainingResources.Subtract(res)
+	startingContainerScore := float64(c.state.StartingContainerCount) * startingContainerWeight
+	return weights.score(&remainingResources, &c.state.TotalResources, extendedShares) + startingContainerScore
+}
+
 func (c *Cell) ReserveLRP(lrp *rep.LRP) error {
-	err := c.state.ResourceMatch(&lrp.Resource)
+	footprint := c.lrpFootprint(lrp)
+	err := c.resourceMatch(&footprint, &lrp.PlacementConstraint, c.lrpMayUseHeadroom(lrp))
 	if err != nil {
 		return err
 	}
 
-	c.state.AddLRP(lrp)
+	c.addLRP(lrp)
+	c.usePids(&lrp.Resource)
+	c.useExtendedResources(&lrp.PlacementConstraint)
+	c.processInstances[lrp.ProcessGuid]++
 	c.workToCommit.LRPs = append(c.workToCommit.LRPs, *lrp)
 	return nil
 }
 
 func (c *Cell) ReserveTask(task *rep.Task) error {
-	err := c.state.ResourceMatch(&task.Resource)
+	footprint := c.taskFootprint(task)
+	err := c.resourceMatch(&footprint, &task.PlacementConstraint, c.taskMayUseHeadroom(task))
 	if err != nil {
 		return err
 	}
 
-	c.state.AddTask(task)
+	c.addTask(task)
+	c.usePids(&task.Resource)
+	c.useExtendedResources(&task.PlacementConstraint)
 	c.workToCommit.Tasks = append(c.workToCommit.Tasks, *task)
 	return nil
 }
 
+// ReserveTasks reserves all of the tasks on the cell, or none of them when it
+// cannot take them all.
+func (c *Cell) ReserveTasks(tasks []*rep.Task) error {
+	trial := c.copy(c.logger)
+	for _, task := range tasks {
+		err := trial.ReserveTask(task)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, task := range tasks {
+		// cannot fail once the copy of the cell took them all
+		_ = c.ReserveTask(task)
+	}
+	return nil
+}
+
+// applyInFlightWork accounts for work committed to the cell that its state
+// does not show yet.
+func (c *Cell) applyInFlightWork(work *rep.Work) {
+	presentLRPs := map[string]bool{}
+	for i := range c.state.LRPs {
+		presentLRPs[c.state.LRPs[i].Identifier()] = true
+	}
+	presentTasks := map[string]bool{}
+	for i := range c.state.Tasks {
+		presentTasks[c.state.Tasks[i].Identifier()] = true
+	}
+
+	for i := range work.LRPs {
+		if !presentLRPs[work.LRPs[i].Identifier()] {
+			c.addLRP(&work.LRPs[i])
+			c.usePids(&work.LRPs[i].Resource)
+			c.useExtendedResources(&work.LRPs[i].PlacementConstraint)
+			c.processInstances[work.LRPs[i].ProcessGuid]++
+		}
+	}
+	for i := range work.Tasks {
+		if !presentTasks[work.Tasks[i].Identifier()] {
+			c.addTask(&work.Tasks[i])
+			c.usePids(&work.Tasks[i].Resource)
+			c.useExtendedResources(&work.Tasks[i].PlacementConstraint)
+		}
+	}
+}
+
+// Commit sends the work reserved on the cell since the last commit.  It
+// returns the work the cell failed to place.  Work rejected because the state
+// of the cell moved on is not returned; it is kept in staleWork so that it can
+// be placed again.
 func (c *Cell) Commit() rep.Work {
 	if len(c.workToCommit.LRPs) == 0 && len(c.workToCommit.Tasks) == 0 {
 		return rep.Work{}
 	}
 
-	failedWork, err := c.client.Perform(c.logger, c.workToCommit)
+	work := c.workToCommit
+	c.workToCommit = rep.Work{CellID: c.Guid}
+
+	failedWork, err := c.perform(work)
+	if err == auctiontypes.ErrorStaleCellState {
+		c.logger.Info("stale-cell-state", lager.Data{"cell-guid": c.Guid, "version": c.version})
+		c.staleWork = work
+		c.rejectedWork = true
+		return rep.Work{}
+	}
+
+	c.committedWork.LRPs = append(c.committedWork.LRPs, work.LRPs...)
+	c.committedWork.Tasks = append(c.committedWork.Tasks, work.Tasks...)
 	if err != nil {
 		c.logger.Error("failed-to-commit", err, lager.Data{"cell-guid": c.Guid})
+		c.rejectedWork = true
 		//an error may indicate partial failure
 		//in this case we don't reschedule work in order to make sure we don't
 		//create duplicates of things -- we'll let the converger figure things out for us later
 		return rep.Work{}
 	}
+
+	c.failedWork.LRPs = append(c.failedWork.LRPs, failedWork.LRPs...)
+	c.failedWork.Tasks = append(c.failedWork.Tasks, failedWork.Tasks...)
+	if len(failedWork.LRPs) > 0 || len(failedWork.Tasks) > 0 {
+		c.rejectedWork = true
+	}
 	return failedWork
 }
+
+func (c *Cell) perform(work rep.Work) (rep.Work, error) {
+	versionedClient, ok := c.client.(auctiontypes.VersionedCellClient)
+	if !ok {
+		return c.client.Perform(c.logger, work)
+	}
+
+	failedWork, version, err := versionedClient.PerformVersioned(c.logger, work, c.version)
+	if err == nil {
+		c.version = version
+	}
+	return failedWork, err
+}
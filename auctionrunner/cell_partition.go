@@ -0,0 +1,155 @@
+package auctionrunner
+
+import (
+	"hash/fnv"
+
+	"code.cloudfoundry.org/rep"
+)
+
+// CellPartition is a disjoint share of the cells of a foundation, owned by a
+// single auction runner.
+type CellPartition interface {
+	// OwnsCell reports whether the cell belongs to the partition.
+	OwnsCell(cellID string, state rep.CellState) bool
+	// CanSatisfy reports whether the cells of the partition may be able to
+	// meet the placement constraint.
+	CanSatisfy(constraint rep.PlacementConstraint) bool
+}
+
+// cellIDPartition is implemented by partitions that can tell which cells they
+// own from the cell ID alone, which lets the runner skip fetching the state of
+// cells it does not own.
+type cellIDPartition interface {
+	OwnsCellID(cellID string) bool
+}
+
+type placementTagPartition struct {
+	requiredTags map[string]struct{}
+	cell         rep.CellState
+}
+
+// NewPlacementTagPartition owns the cells whose required placement tags are
+// exactly the given tags.  It can satisfy the constraints such cells would
+// match when they also carry the optional tags.
+func NewPlacementTagPartition(requiredTags, optionalTags []string) CellPartition {
+	return placementTagPartition{
+		requiredTags: toTagSet(requiredTags),
+		cell: rep.CellState{
+			PlacementTags:         requiredTags,
+			OptionalPlacementTags: optionalTags,
+		},
+	}
+}
+
+func (p placementTagPartition) OwnsCell(cellID string, state rep.CellState) bool {
+	cellTags := toTagSet(state.PlacementTags)
+	if len(cellTags) != len(p.requiredTags) {
+		return false
+	}
+	for tag := range cellTags {
+		if _, ok := p.requiredTags[tag]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (p placementTagPartition) CanSatisfy(constraint rep.PlacementConstraint) bool {
+	return p.cell.MatchPlacementTags(constraint.PlacementTags)
+}
+
+type zonePartition struct {
+	zones map[string]struct{}
+}
+
+// NewZonePartition owns the cells in the given zones.  Any constraint may be
+// satisfied by it.  Behind a shard router, the instances of a process are
+// balanced over zone partitions by their index rather than by where they run;
+// see shardRouter.
+func NewZonePartition(zones ...string) CellPartition {
+	return zonePartition{zones: toTagSet(zones)}
+}
+
+func (p zonePartition) OwnsCell(cellID string, state rep.CellState) bool {
+	_, ok := p.zones[state.Zone]
+	return ok
+}
+
+func (p zonePartition) CanSatisfy(constraint rep.PlacementConstraint) bool {
+	return true
+}
+
+type hashPartition struct {
+	index int
+	count int
+}
+
+// NewHashPartition owns the cells whose ID hashes to the given index out of
+// count partitions.  Any constraint may be satisfied by it.
+func NewHashPartition(index, count int) CellPartition {
+	return hashPartition{index: index, count: count}
+}
+
+func (p hashPartition) OwnsCell(cellID string, state rep.CellState) bool {
+	return p.OwnsCellID(cellID)
+}
+
+func (p hashPartition) OwnsCellID(cellID string) bool {
+	return hashIndex(cellID, p.count) == p.index
+}
+
+func (p hashPartition) CanSatisfy(constraint rep.PlacementConstraint) bool {
+	return true
+}
+
+func hashIndex(key string, count int) int {
+	if count <= 1 {
+		return 0
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(count))
+}
+
+func toTagSet(tags []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(tags))
+	for _, tag := range tags {
+		set[tag] = struct{}{}
+	}
+	return set
+}
+
+// partitionClients drops the clients of cells the partition can tell it does
+// not own from their ID.
+func partitionClients(partition CellPartition, clients map[string]rep.Client) map[string]rep.Client {
+	idPartition, ok := partition.(cellIDPartition)
+	if !ok {
+		return clients
+	}
+
+	owned := make(map[string]rep.Client, len(clients))
+	for guid, client := range clients {
+		if idPartition.OwnsCellID(guid) {
+			owned[guid] = client
+		}
+	}
+	return owned
+}
+
+// partitionZones drops the cells the partition does not own, and any zone left
+// without cells.
+func partitionZones(partition CellPartition, zones map[string]Zone) map[string]Zone {
+	owned := map[string]Zone{}
+	for zone, cells := range zones {
+		ownedCells := make(Zone, 0, len(cells))
+		for _, cell := range cells {
+			if partition.OwnsCell(cell.Guid, cell.state) {
+				ownedCells = append(ownedCells, cell)
+			}
+		}
+		if len(ownedCells) > 0 {
+			owned[zone] = ownedCells
+		}
+	}
+	return owned
+}
@@ -0,0 +1,87 @@
+package auctionrunner_test
+
+import (
+	"fmt"
+
+	"code.cloudfoundry.org/auction/auctionrunner"
+	"code.cloudfoundry.org/rep"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CellPartition", func() {
+	var partition auctionrunner.CellPartition
+
+	Describe("partitioning by placement tag", func() {
+		BeforeEach(func() {
+			partition = auctionrunner.NewPlacementTagPartition([]string{"gpu", "large"}, []string{"ssd"})
+		})
+
+		It("owns the cells with exactly the required tags", func() {
+			Expect(partition.OwnsCell("a", BuildCellState("a", 0, "z1", 100, 100, 10, false, 0, linuxOnlyRootFSProviders, nil, nil, []string{"large", "gpu"}, nil, 0))).To(BeTrue())
+			Expect(partition.OwnsCell("b", BuildCellState("b", 0, "z1", 100, 100, 10, false, 0, linuxOnlyRootFSProviders, nil, nil, []string{"gpu"}, nil, 0))).To(BeFalse())
+			Expect(partition.OwnsCell("c", BuildCellState("c", 0, "z1", 100, 100, 10, false, 0, linuxOnlyRootFSProviders, nil, nil, []string{"gpu", "large", "ssd"}, nil, 0))).To(BeFalse())
+		})
+
+		It("satisfies the constraints its cells would match", func() {
+			Expect(partition.CanSatisfy(rep.NewPlacementConstraint(linuxRootFSURL, []string{"gpu", "large"}, nil))).To(BeTrue())
+			Expect(partition.CanSatisfy(rep.NewPlacementConstraint(linuxRootFSURL, []string{"gpu", "large", "ssd"}, nil))).To(BeTrue())
+			Expect(partition.CanSatisfy(rep.NewPlacementConstraint(linuxRootFSURL, []string{"gpu"}, nil))).To(BeFalse())
+			Expect(partition.CanSatisfy(rep.NewPlacementConstraint(linuxRootFSURL, nil, nil))).To(BeFalse())
+		})
+
+		Context("without tags", func() {
+			BeforeEach(func() {
+				partition = auctionrunner.NewPlacementTagPartition(nil, nil)
+			})
+
+			It("owns and satisfies untagged work", func() {
+				Expect(partition.OwnsCell("a", BuildCellState("a", 0, "z1", 100, 100, 10, false, 0, linuxOnlyRootFSProviders, nil, nil, nil, nil, 0))).To(BeTrue())
+				Expect(partition.CanSatisfy(rep.NewPlacementConstraint(linuxRootFSURL, nil, nil))).To(BeTrue())
+				Expect(partition.CanSatisfy(rep.NewPlacementConstraint(linuxRootFSURL, []string{"gpu"}, nil))).To(BeFalse())
+			})
+		})
+	})
+
+	Describe("partitioning by zone", func() {
+		BeforeEach(func() {
+			partition = auctionrunner.NewZonePartition("z1", "z2")
+		})
+
+		It("owns the cells in its zones", func() {
+			Expect(partition.OwnsCell("a", BuildCellState("a", 0, "z2", 100, 100, 10, false, 0, linuxOnlyRootFSProviders, nil, nil, nil, nil, 0))).To(BeTrue())
+			Expect(partition.OwnsCell("b", BuildCellState("b", 0, "z3", 100, 100, 10, false, 0, linuxOnlyRootFSProviders, nil, nil, nil, nil, 0))).To(BeFalse())
+		})
+
+		It("satisfies any constraint", func() {
+			Expect(partition.CanSatisfy(rep.NewPlacementConstraint(linuxRootFSURL, []string{"gpu"}, nil))).To(BeTrue())
+		})
+	})
+
+	Describe("partitioning by hash", func() {
+		It("gives every cell to exactly one partition", func() {
+			partitions := []auctionrunner.CellPartition{}
+			for i := 0; i < 3; i++ {
+				partitions = append(partitions, auctionrunner.NewHashPartition(i, 3))
+			}
+
+			owned := make([]int, 3)
+			for c := 0; c < 60; c++ {
+				cellID := fmt.Sprintf("cell-%d", c)
+				owners := 0
+				for i, partition := range partitions {
+					if partition.OwnsCell(cellID, rep.CellState{}) {
+						owners++
+						owned[i]++
+					}
+				}
+				Expect(owners).To(Equal(1))
+			}
+
+			for i := range owned {
+				Expect(owned[i]).To(BeNumerically(">", 0))
+			}
+		})
+	})
+})
@@ -0,0 +1,214 @@
+package auctionrunner
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/auction/auctiontypes"
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/rep"
+	"code.cloudfoundry.org/workpool"
+)
+
+type cachedCellState struct {
+	state      rep.CellState
+	version    uint64
+	fetchedAt  time.Time
+	generation uint64
+	stale      bool
+
+	// recorded counts the auctions recorded against the state, so that a
+	// fetch that started before one of them does not overwrite it.
+	recorded uint64
+}
+
+/*
+CellStateCache keeps the most recent state of every cell so that an auction can
+start from a recent snapshot instead of asking every cell for its state.
+
+Run refreshes every known cell in the background once per refresh interval.
+After an auction, Record applies the work each cell accepted to its cached
+state; cells that rejected work, or whose state changed while the auction was
+running, are marked stale.  A state fetched from a cell is dropped when an
+auction was recorded against the cell while it was being fetched, as it may
+not show the work that auction committed.  BuildZones only fetches the cells
+that are unknown, stale, or have not been refreshed for two refresh intervals.
+*/
+type CellStateCache struct {
+	logger          lager.Logger
+	workPool        *workpool.WorkPool
+	metricEmitter   auctiontypes.AuctionMetricEmitterDelegate
+	clock           clock.Clock
+	refreshInterval time.Duration
+
+	clients    map[string]rep.Client
+	cells      map[string]*cachedCellState
+	generation uint64
+	lock       *sync.Mutex
+}
+
+func NewCellStateCache(
+	logger lager.Logger,
+	workPool *workpool.WorkPool,
+	metricEmitter auctiontypes.AuctionMetricEmitterDelegate,
+	clock clock.Clock,
+	refreshInterval time.Duration,
+) *CellStateCache {
+	return &CellStateCache{
+		logger:          logger.Session("cell-state-cache"),
+		workPool:        workPool,
+		metricEmitter:   metricEmitter,
+		clock:           clock,
+		refreshInterval: refreshInterval,
+		clients:         map[string]rep.Client{},
+		cells:           map[string]*cachedCellState{},
+		lock:            &sync.Mutex{},
+	}
+}
+
+func (c *CellStateCache) Run(signals <-chan os.Signal, ready chan<- struct{}) error {
+	ticker := c.clock.NewTicker(c.refreshInterval)
+	defer ticker.Stop()
+
+	close(ready)
+
+	for {
+		select {
+		case <-ticker.C():
+			c.lock.Lock()
+			clients := make(map[string]rep.Client, len(c.clients))
+			for guid, client := range c.clients {
+				clients[guid] = client
+			}
+			c.lock.Unlock()
+
+			c.refresh(c.logger.Session("refresh"), clients)
+		case <-signals:
+			return nil
+		}
+	}
+}
+
+// BuildZones returns the zones of the given cells from their cached state,
+// fetching the state of any cell that has no usable cached state first.  Cells
+// that are no longer present are forgotten.
+func (c *CellStateCache) BuildZones(logger lager.Logger, clients map[string]rep.Client, binPackFirstFitWeight float64) map[string]Zone {
+	now := c.clock.Now()
+	toFetch := map[string]rep.Client{}
+
+	c.lock.Lock()
+	c.clients = clients
+	for guid := range c.cells {
+		if _, ok := clients[guid]; !ok {
+			delete(c.cells, guid)
+		}
+	}
+	for guid, client := range clients {
+		cached, ok := c.cells[guid]
+		if !ok || cached.stale || now.Sub(cached.fetchedAt) >= 2*c.refreshInterval {
+			toFetch[guid] = client
+		}
+	}
+	c.lock.Unlock()
+
+	logger.Info("refreshing-cell-states", lager.Data{"cell-count": len(clients), "refresh-count": len(toFetch)})
+	c.refresh(logger, toFetch)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	zones := map[string]Zone{}
+	for guid, client := range clients {
+		cached, ok := c.cells[guid]
+		if !ok {
+			continue
+		}
+
+		cell := NewCell(logger, guid, client, copyCellState(cached.state))
+		cell.version = cached.version
+		cell.cacheGeneration = cached.generation
+		zones[cached.state.Zone] = append(zones[cached.state.Zone], cell)
+	}
+
+	if isBinPackFirstFitWeightProvided(binPackFirstFitWeight) {
+		return normaliseCellIndices(zones)
+	}
+
+	return zones
+}
+
+// Record applies the work committed during an auction to the cached state of
+// the cells in zones, which must have been built by BuildZones.  The state is
+// cached as the cells report it, without the resources overcommit adds.
+func (c *CellStateCache) Record(zones map[string]Zone) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for _, zone := range zones {
+		for _, cell := range zone {
+			cached, ok := c.cells[cell.Guid]
+			if !ok {
+				continue
+			}
+			cached.recorded++
+
+			if cell.rejectedWork || cached.generation != cell.cacheGeneration {
+				cached.stale = true
+				continue
+			}
+
+			cached.state = copyCellState(cell.reportedState())
+			cached.version = cell.version
+		}
+	}
+}
+
+func (c *CellStateCache) refresh(logger lager.Logger, clients map[string]rep.Client) {
+	wg := &sync.WaitGroup{}
+	wg.Add(len(clients))
+
+	for guid, client := range clients {
+		guid, client := guid, client
+		c.workPool.Submit(func() {
+			defer wg.Done()
+
+			c.lock.Lock()
+			var recorded uint64
+			if cached, ok := c.cells[guid]; ok {
+				recorded = cached.recorded
+			}
+			c.lock.Unlock()
+
+			state, version, ok := fetchCellState(logger, guid, client, c.metricEmitter)
+
+			c.lock.Lock()
+			defer c.lock.Unlock()
+			if !ok {
+				delete(c.cells, guid)
+				return
+			}
+			if cached, ok := c.cells[guid]; ok && cached.recorded != recorded {
+				logger.Debug("dropping-state-superseded-by-auction", lager.Data{"cell-guid": guid})
+				return
+			}
+			c.generation++
+			c.cells[guid] = &cachedCellState{state: state, version: version, fetchedAt: c.clock.Now(), generation: c.generation, recorded: recorded}
+		})
+	}
+
+	wg.Wait()
+}
+
+func copyCellState(state rep.CellState) rep.CellState {
+	lrps := make([]rep.LRP, len(state.LRPs))
+	copy(lrps, state.LRPs)
+	state.LRPs = lrps
+
+	tasks := make([]rep.Task, len(state.Tasks))
+	copy(tasks, state.Tasks)
+	state.Tasks = tasks
+
+	return state
+}
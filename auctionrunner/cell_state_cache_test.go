@@ -0,0 +1,261 @@
+package auctionrunner_test
+
+import (
+	"errors"
+	"os"
+	"time"
+
+	"code.cloudfoundry.org/auction/auctionrunner"
+	"code.cloudfoundry.org/auction/auctiontypes"
+	"code.cloudfoundry.org/auction/auctiontypes/fakes"
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/rep"
+	"code.cloudfoundry.org/rep/repfakes"
+	"code.cloudfoundry.org/workpool"
+	"github.com/tedsuo/ifrit"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CellStateCache", func() {
+	var (
+		clock         *fakeclock.FakeClock
+		workPool      *workpool.WorkPool
+		metricEmitter *fakes.FakeAuctionMetricEmitterDelegate
+		repA, repB    *repfakes.FakeSimClient
+		clients       map[string]rep.Client
+		cache         *auctionrunner.CellStateCache
+	)
+
+	BeforeEach(func() {
+		clock = fakeclock.NewFakeClock(time.Now())
+
+		var err error
+		workPool, err = workpool.NewWorkPool(5)
+		Expect(err).NotTo(HaveOccurred())
+
+		metricEmitter = &fakes.FakeAuctionMetricEmitterDelegate{}
+
+		repA = &repfakes.FakeSimClient{}
+		repA.StateReturns(BuildCellState("A", 0, "the-zone", 100, 100, 10, false, 0, linuxOnlyRootFSProviders, nil, nil, nil, nil, 0), nil)
+		repB = &repfakes.FakeSimClient{}
+		repB.StateReturns(BuildCellState("B", 1, "other-zone", 100, 100, 10, false, 0, linuxOnlyRootFSProviders, nil, nil, nil, nil, 0), nil)
+		clients = map[string]rep.Client{"A": repA, "B": repB}
+
+		cache = auctionrunner.NewCellStateCache(logger, workPool, metricEmitter, clock, 10*time.Second)
+	})
+
+	AfterEach(func() {
+		workPool.Stop()
+	})
+
+	scheduleLRP := func(zones map[string]auctionrunner.Zone, processGuid string) auctiontypes.AuctionResults {
+		scheduler := auctionrunner.NewScheduler(workPool, zones, clock, logger, 0.0, 0.0, 0)
+		return scheduler.Schedule(auctiontypes.AuctionRequest{
+			LRPs: []auctiontypes.LRPAuction{
+				BuildLRPAuction(processGuid, "domain", 0, linuxRootFSURL, 10, 10, 10, clock.Now(), nil, nil),
+			},
+		})
+	}
+
+	cellState := func(zones map[string]auctionrunner.Zone, guid string) rep.CellState {
+		for _, zone := range zones {
+			for _, cell := range zone {
+				if cell.Guid == guid {
+					return cell.State()
+				}
+			}
+		}
+		Fail("no cell " + guid)
+		return rep.CellState{}
+	}
+
+	It("fetches the state of every cell the first time", func() {
+		zones := cache.BuildZones(logger, clients, 0.0)
+		Expect(zones).To(HaveLen(2))
+		Expect(zones["the-zone"]).To(HaveLen(1))
+		Expect(zones["other-zone"]).To(HaveLen(1))
+		Expect(repA.StateCallCount()).To(Equal(1))
+		Expect(repB.StateCallCount()).To(Equal(1))
+	})
+
+	It("reuses recently fetched states", func() {
+		cache.BuildZones(logger, clients, 0.0)
+		clock.Increment(15 * time.Second)
+
+		zones := cache.BuildZones(logger, clients, 0.0)
+		Expect(zones).To(HaveLen(2))
+		Expect(repA.StateCallCount()).To(Equal(1))
+		Expect(repB.StateCallCount()).To(Equal(1))
+	})
+
+	It("fetches states that have not been refreshed for two refresh intervals", func() {
+		cache.BuildZones(logger, clients, 0.0)
+		clock.Increment(20 * time.Second)
+
+		cache.BuildZones(logger, clients, 0.0)
+		Expect(repA.StateCallCount()).To(Equal(2))
+		Expect(repB.StateCallCount()).To(Equal(2))
+	})
+
+	It("forgets cells that are no longer present", func() {
+		cache.BuildZones(logger, clients, 0.0)
+
+		zones := cache.BuildZones(logger, map[string]rep.Client{"A": repA}, 0.0)
+		Expect(zones).To(HaveLen(1))
+		Expect(zones["the-zone"]).To(HaveLen(1))
+
+		zones = cache.BuildZones(logger, clients, 0.0)
+		Expect(zones).To(HaveLen(2))
+		Expect(repB.StateCallCount()).To(Equal(2))
+	})
+
+	It("does not cache cells whose state could not be fetched", func() {
+		repB.StateReturns(rep.CellState{}, errors.New("boom"))
+		zones := cache.BuildZones(logger, clients, 0.0)
+		Expect(zones).To(HaveLen(1))
+		Expect(metricEmitter.FailedCellStateRequestCallCount()).To(Equal(1))
+
+		cache.BuildZones(logger, clients, 0.0)
+		Expect(repB.StateCallCount()).To(Equal(2))
+	})
+
+	It("normalises cell indices when a bin pack first fit weight is provided", func() {
+		repB.StateReturns(BuildCellState("B", 5, "the-zone", 100, 100, 10, false, 0, linuxOnlyRootFSProviders, nil, nil, nil, nil, 0), nil)
+		zones := cache.BuildZones(logger, clients, 0.5)
+		Expect(zones["the-zone"]).To(HaveLen(2))
+		Expect(zones["the-zone"][0].Index).To(Equal(0))
+		Expect(zones["the-zone"][1].Index).To(Equal(1))
+	})
+
+	Describe("recording an auction", func() {
+		It("applies the committed work to the cached state", func() {
+			zones := cache.BuildZones(logger, clients, 0.0)
+			results := scheduleLRP(zones, "pg-1")
+			Expect(results.SuccessfulLRPs).To(HaveLen(1))
+			winner := results.SuccessfulLRPs[0].Winner
+			cache.Record(zones)
+
+			zones = cache.BuildZones(logger, clients, 0.0)
+			state := cellState(zones, winner)
+			Expect(state.LRPs).To(HaveLen(1))
+			Expect(state.LRPs[0].ProcessGuid).To(Equal("pg-1"))
+			Expect(state.AvailableResources.MemoryMB).To(BeEquivalentTo(90))
+			Expect(repA.StateCallCount()).To(Equal(1))
+			Expect(repB.StateCallCount()).To(Equal(1))
+		})
+
+		It("caches the state without the resources overcommit adds", func() {
+			overcommit := auctionrunner.WithOvercommit(auctionrunner.OvercommitRatios{MemoryMB: 2}, nil)
+			clients = map[string]rep.Client{"A": repA}
+
+			for i, processGuid := range []string{"pg-1", "pg-2"} {
+				zones := cache.BuildZones(logger, clients, 0.0)
+				scheduler := auctionrunner.NewScheduler(workPool, zones, clock, logger, 0.0, 0.0, 0, overcommit)
+				Expect(cellState(zones, "A").TotalResources.MemoryMB).To(BeEquivalentTo(200))
+				Expect(cellState(zones, "A").AvailableResources.MemoryMB).To(BeEquivalentTo(200 - 10*i))
+
+				results := scheduler.Schedule(auctiontypes.AuctionRequest{
+					LRPs: []auctiontypes.LRPAuction{
+						BuildLRPAuction(processGuid, "domain", 0, linuxRootFSURL, 10, 10, 10, clock.Now(), nil, nil),
+					},
+				})
+				Expect(results.SuccessfulLRPs).To(HaveLen(1))
+				cache.Record(zones)
+			}
+
+			state := cellState(cache.BuildZones(logger, clients, 0.0), "A")
+			Expect(state.TotalResources.MemoryMB).To(BeEquivalentTo(100))
+			Expect(state.AvailableResources.MemoryMB).To(BeEquivalentTo(80))
+			Expect(repA.StateCallCount()).To(Equal(1))
+		})
+
+		It("does not let an auction change the cached state before it is recorded", func() {
+			zones := cache.BuildZones(logger, clients, 0.0)
+			scheduleLRP(zones, "pg-1")
+
+			zones = cache.BuildZones(logger, clients, 0.0)
+			Expect(cellState(zones, "A").LRPs).To(BeEmpty())
+			Expect(cellState(zones, "B").LRPs).To(BeEmpty())
+		})
+
+		It("fetches cells that rejected work before they are used again", func() {
+			repA.PerformReturns(rep.Work{}, errors.New("boom"))
+			repB.PerformReturns(rep.Work{}, errors.New("boom"))
+
+			zones := cache.BuildZones(logger, clients, 0.0)
+			results := scheduleLRP(zones, "pg-1")
+			Expect(results.SuccessfulLRPs).To(HaveLen(1))
+			cache.Record(zones)
+
+			cache.BuildZones(logger, clients, 0.0)
+			Expect(repA.StateCallCount() + repB.StateCallCount()).To(Equal(3))
+		})
+
+		It("does not overwrite states that were refreshed during the auction", func() {
+			zones := cache.BuildZones(logger, clients, 0.0)
+			scheduleLRP(zones, "pg-1")
+
+			clock.Increment(20 * time.Second)
+			refreshed := cache.BuildZones(logger, clients, 0.0)
+			cache.Record(zones)
+			Expect(cellState(refreshed, "A").LRPs).To(BeEmpty())
+
+			cache.BuildZones(logger, clients, 0.0)
+			Expect(repA.StateCallCount() + repB.StateCallCount()).To(Equal(6))
+		})
+
+		It("does not overwrite recorded states with ones fetched before they were recorded", func() {
+			clients = map[string]rep.Client{"A": repA}
+			zones := cache.BuildZones(logger, clients, 0.0)
+
+			fetching := make(chan struct{})
+			release := make(chan struct{})
+			repA.StateStub = func(lager.Logger) (rep.CellState, error) {
+				close(fetching)
+				<-release
+				return BuildCellState("A", 0, "the-zone", 100, 100, 10, false, 0, linuxOnlyRootFSProviders, nil, nil, nil, nil, 0), nil
+			}
+
+			clock.Increment(20 * time.Second)
+			refreshed := make(chan map[string]auctionrunner.Zone, 1)
+			go func() {
+				defer GinkgoRecover()
+				refreshed <- cache.BuildZones(logger, clients, 0.0)
+			}()
+			Eventually(fetching).Should(BeClosed())
+
+			Expect(scheduleLRP(zones, "pg-1").SuccessfulLRPs).To(HaveLen(1))
+			cache.Record(zones)
+			close(release)
+
+			var refreshedZones map[string]auctionrunner.Zone
+			Eventually(refreshed).Should(Receive(&refreshedZones))
+			Expect(cellState(refreshedZones, "A").LRPs).To(HaveLen(1))
+		})
+	})
+
+	Describe("refreshing in the background", func() {
+		var process ifrit.Process
+
+		BeforeEach(func() {
+			cache.BuildZones(logger, clients, 0.0)
+			process = ifrit.Invoke(cache)
+		})
+
+		AfterEach(func() {
+			process.Signal(os.Interrupt)
+			Eventually(process.Wait()).Should(Receive())
+		})
+
+		It("refreshes every known cell once per refresh interval", func() {
+			Eventually(clock.WatcherCount).Should(Equal(1))
+			clock.Increment(10 * time.Second)
+
+			Eventually(repA.StateCallCount).Should(Equal(2))
+			Eventually(repB.StateCallCount).Should(Equal(2))
+		})
+	})
+})
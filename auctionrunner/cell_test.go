@@ -4,6 +4,8 @@ import (
 	"errors"
 
 	"code.cloudfoundry.org/auction/auctionrunner"
+	"code.cloudfoundry.org/auction/auctiontypes"
+	"code.cloudfoundry.org/auction/auctiontypes/fakes"
 	"code.cloudfoundry.org/bbs/models"
 	"code.cloudfoundry.org/rep"
 	"code.cloudfoundry.org/rep/repfakes"
@@ -324,6 +326,20 @@ var _ = Describe("Cell", func() {
 			Expect(oneMatchesScore).To(BeNumerically("<", twoMatchesScore))
 		})
 
+		It("factors in instances reserved on the cell", func() {
+			instance := BuildLRP("pg-new", "domain", 0, linuxRootFSURL, 10, 10, 10, []string{})
+			otherInstance := BuildLRP("pg-new", "domain", 1, linuxRootFSURL, 10, 10, 10, []string{})
+
+			scoreBefore, err := emptyCell.ScoreForLRP(otherInstance, 0.0, 0.0)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(emptyCell.ReserveLRP(instance)).To(Succeed())
+
+			scoreAfter, err := emptyCell.ScoreForLRP(otherInstance, 0.0, 0.0)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(scoreAfter - scoreBefore).To(BeNumerically(">=", auctionrunner.LocalityOffset))
+		})
+
 		Context("when the LRP does not fit", func() {
 			Context("because of memory constraints", func() {
 				It("should error", func() {
@@ -638,6 +654,58 @@ var _ = Describe("Cell", func() {
 					Expect(cell.Commit()).To(BeZero())
 				})
 			})
+
+			It("only sends the work once", func() {
+				cell.Commit()
+				cell.Commit()
+				Expect(client.PerformCallCount()).To(Equal(1))
+			})
+		})
+
+		Context("when the client versions its state", func() {
+			var (
+				versionedClient *fakes.FakeVersionedCellClient
+				versionedCell   *auctionrunner.Cell
+				lrp             rep.LRP
+			)
+
+			BeforeEach(func() {
+				versionedClient = &fakes.FakeVersionedCellClient{}
+				versionedCell = auctionrunner.NewCell(logger, "versioned-cell", versionedClient, BuildCellState("versioned-cell", 0, "the-zone", 100, 200, 50, false, 0, linuxOnlyRootFSProviders, nil, []string{}, []string{}, []string{}, 0))
+
+				lrp = *BuildLRP("pg-new", "domain", 0, linuxRootFSURL, 20, 10, 10, []string{})
+				Expect(versionedCell.ReserveLRP(&lrp)).To(Succeed())
+			})
+
+			It("performs the work against the version of the state it was placed with", func() {
+				versionedClient.PerformVersionedReturns(rep.Work{}, 1, nil)
+				versionedCell.Commit()
+
+				Expect(versionedClient.PerformCallCount()).To(Equal(0))
+				Expect(versionedClient.PerformVersionedCallCount()).To(Equal(1))
+				_, work, version := versionedClient.PerformVersionedArgsForCall(0)
+				Expect(work.LRPs).To(Equal([]rep.LRP{lrp}))
+				Expect(version).To(BeZero())
+			})
+
+			It("performs later work against the version the cell moved to", func() {
+				versionedClient.PerformVersionedReturns(rep.Work{}, 7, nil)
+				versionedCell.Commit()
+
+				otherLRP := BuildLRP("pg-other", "domain", 0, linuxRootFSURL, 20, 10, 10, []string{})
+				Expect(versionedCell.ReserveLRP(otherLRP)).To(Succeed())
+				versionedCell.Commit()
+
+				_, _, version := versionedClient.PerformVersionedArgsForCall(1)
+				Expect(version).To(BeEquivalentTo(7))
+			})
+
+			Context("when the cell rejects the work as stale", func() {
+				It("does not report it as failed work", func() {
+					versionedClient.PerformVersionedReturns(rep.Work{}, 3, auctiontypes.ErrorStaleCellState)
+					Expect(versionedCell.Commit()).To(BeZero())
+				})
+			})
 		})
 	})
 })
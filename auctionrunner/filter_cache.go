@@ -0,0 +1,76 @@
+package auctionrunner
+
+import (
+	"sort"
+	"strings"
+
+	"code.cloudfoundry.org/rep"
+)
+
+// filteredZone is the part of a zone that matches a placement constraint.
+type filteredZone struct {
+	cells []*Cell
+	// exhausted holds the matching cells that have no room for another
+	// container.  They can never win an auction but are scored when nothing
+	// else can take the work, to report the problems of every cell.
+	exhausted []*Cell
+	err       error
+}
+
+/*
+filterCache memoizes Zone.filterCells for each distinct placement constraint
+within an auction, as a batch usually only holds a handful of them.  Cells that
+a reservation leaves without room for another container are split off the next
+time the constraint is filtered, so the cache is dropped whenever that happens,
+and whenever cells are replaced.
+*/
+type filterCache map[string]map[string]filteredZone
+
+func (c filterCache) filter(name string, zone Zone, pc rep.PlacementConstraint) filteredZone {
+	key := constraintKey(pc)
+	zones, ok := c[key]
+	if !ok {
+		zones = map[string]filteredZone{}
+		c[key] = zones
+	}
+
+	filtered, ok := zones[name]
+	if ok {
+		return filtered
+	}
+
+	cells, err := zone.filterCells(pc)
+	filtered = filteredZone{cells: make([]*Cell, 0, len(cells)), err: err}
+	for _, cell := range cells {
+		if cell.exhausted() {
+			filtered.exhausted = append(filtered.exhausted, cell)
+		} else {
+			filtered.cells = append(filtered.cells, cell)
+		}
+	}
+
+	zones[name] = filtered
+	return filtered
+}
+
+func (c filterCache) invalidate() {
+	for key := range c {
+		delete(c, key)
+	}
+}
+
+// constraintKey normalizes a placement constraint, so that constraints listing
+// the same tags or drivers in a different order share their filtered cells.
+func constraintKey(pc rep.PlacementConstraint) string {
+	return pc.RootFs + "\x00" + normalizedList(pc.PlacementTags) + "\x00" + normalizedList(pc.VolumeDrivers)
+}
+
+func normalizedList(values []string) string {
+	set := toTagSet(values)
+	sorted := make([]string, 0, len(set))
+	for value := range set {
+		sorted = append(sorted, value)
+	}
+	sort.Strings(sorted)
+	return strings.Join(sorted, "\x01")
+}
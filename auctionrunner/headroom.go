@@ -0,0 +1,56 @@
+package auctionrunner
+
+import "code.cloudfoundry.org/rep"
+
+// Headroom is the part of the resources of each cell kept free for urgent
+// work, as a percentage of what the cell reports, an absolute amount, or
+// both, in which case the larger of the two is kept.
+type Headroom struct {
+	MemoryPercent     float64
+	DiskPercent       float64
+	ContainersPercent float64
+
+	MemoryMB   int32
+	DiskMB     int32
+	Containers int
+}
+
+// WithHeadroom keeps the headroom of each cell free for urgent work, by
+// default the LRPs with index 0, which are started ahead of the others for
+// the same reason.  Other work fails on cells that only have room for it in
+// their headroom.
+func WithHeadroom(headroom Headroom) SchedulerOption {
+	return func(s *Scheduler) {
+		s.headroom = &headroom
+	}
+}
+
+// WithUrgentWork has the LRPs urgentLRP picks and the tasks urgentTask picks
+// use the headroom instead of the LRPs with index 0.  A nil predicate picks
+// no work of its kind.
+func WithUrgentWork(urgentLRP func(lrp *rep.LRP) bool, urgentTask func(task *rep.Task) bool) SchedulerOption {
+	return func(s *Scheduler) {
+		s.urgentLRP = urgentLRP
+		s.urgentTask = urgentTask
+	}
+}
+
+// amount is the headroom of a cell that reports total resources.
+func (h *Headroom) amount(total rep.Resources) rep.Resources {
+	amount := rep.Resources{MemoryMB: h.MemoryMB, DiskMB: h.DiskMB, Containers: h.Containers}
+	if memoryMB := int32(float64(total.MemoryMB) * h.MemoryPercent / 100); memoryMB > amount.MemoryMB {
+		amount.MemoryMB = memoryMB
+	}
+	if diskMB := int32(float64(total.DiskMB) * h.DiskPercent / 100); diskMB > amount.DiskMB {
+		amount.DiskMB = diskMB
+	}
+	if containers := int(float64(total.Containers) * h.ContainersPercent / 100); containers > amount.Containers {
+		amount.Containers = containers
+	}
+	return amount
+}
+
+// isFirstInstance is the urgentLRP predicate used by default.
+func isFirstInstance(lrp *rep.LRP) bool {
+	return lrp.Index == 0
+}
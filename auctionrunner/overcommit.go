@@ -0,0 +1,58 @@
+package auctionrunner
+
+import (
+	"sort"
+
+	"code.cloudfoundry.org/rep"
+)
+
+// OvercommitRatios are how many times the memory, disk and containers a cell
+// reports may be placed on it.  Ratios of 1 or less do not overcommit.
+type OvercommitRatios struct {
+	MemoryMB   float64
+	DiskMB     float64
+	Containers float64
+}
+
+type overcommitPolicy struct {
+	ratios OvercommitRatios
+	pools  map[string]OvercommitRatios
+}
+
+// WithOvercommit places work on cells as if they had ratios times the
+// resources they report, so that matching, scoring and reserving all see the
+// overcommitted resources.  Cells with a placement tag in pools use the ratios
+// of that pool instead, those of the first such tag in order when they have
+// several.  Auctions that only fit thanks to overcommit are marked as
+// Overcommitted in the results.
+func WithOvercommit(ratios OvercommitRatios, pools map[string]OvercommitRatios) SchedulerOption {
+	return func(s *Scheduler) {
+		s.overcommit = &overcommitPolicy{ratios: ratios, pools: pools}
+	}
+}
+
+func (p *overcommitPolicy) ratiosFor(state *rep.CellState) OvercommitRatios {
+	tags := append([]string(nil), state.PlacementTags...)
+	sort.Strings(tags)
+	for _, tag := range tags {
+		if ratios, ok := p.pools[tag]; ok {
+			return ratios
+		}
+	}
+	return p.ratios
+}
+
+// overcommit returns the resources overcommitting total by ratios adds.
+func (r OvercommitRatios) overcommit(total rep.Resources) rep.Resources {
+	extra := rep.Resources{}
+	if r.MemoryMB > 1 {
+		extra.MemoryMB = int32(float64(total.MemoryMB) * (r.MemoryMB - 1))
+	}
+	if r.DiskMB > 1 {
+		extra.DiskMB = int32(float64(total.DiskMB) * (r.DiskMB - 1))
+	}
+	if r.Containers > 1 {
+		extra.Containers = int(float64(total.Containers) * (r.Containers - 1))
+	}
+	return extra
+}
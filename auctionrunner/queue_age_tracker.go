@@ -0,0 +1,147 @@
+package auctionrunner
+
+import (
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/auction/auctiontypes"
+	"code.cloudfoundry.org/clock"
+)
+
+// queueAgeTracker remembers the AuctionRecord of auctions that failed to be
+// placed.  Their resubmissions keep the original QueueTime and Attempts, so
+// that an auction which keeps failing eventually exceeds its maximum queue age
+// and expires instead of being retried forever, however rarely it is retried.
+// A record is forgotten once its auction is placed or expires, or once it is
+// twice as old as the maximum queue age: an auction that is not resubmitted by
+// then has most likely been given up on, and is aged afresh if it ever is.
+type queueAgeTracker struct {
+	clock           clock.Clock
+	maxLRPQueueAge  time.Duration // <=0 means no limit
+	maxTaskQueueAge time.Duration // <=0 means no limit
+
+	failedLRPs  map[string]auctiontypes.AuctionRecord
+	failedTasks map[string]auctiontypes.AuctionRecord
+	lock        *sync.Mutex
+}
+
+func newQueueAgeTracker(clock clock.Clock, maxLRPQueueAge, maxTaskQueueAge time.Duration) *queueAgeTracker {
+	return &queueAgeTracker{
+		clock:           clock,
+		maxLRPQueueAge:  maxLRPQueueAge,
+		maxTaskQueueAge: maxTaskQueueAge,
+		failedLRPs:      map[string]auctiontypes.AuctionRecord{},
+		failedTasks:     map[string]auctiontypes.AuctionRecord{},
+		lock:            &sync.Mutex{},
+	}
+}
+
+// Expire restores the records of previously failed auctions and splits off the
+// auctions that have been queued longer than allowed, along with the rest of
+// the task group of any expired task.  Expired auctions are returned as
+// failures carrying ErrorAuctionExpired and their final Attempts.
+func (t *queueAgeTracker) Expire(
+	lrpAuctions []auctiontypes.LRPAuction,
+	taskAuctions []auctiontypes.TaskAuction,
+) ([]auctiontypes.LRPAuction, []auctiontypes.TaskAuction, auctiontypes.AuctionResults) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	now := t.clock.Now()
+	expired := auctiontypes.AuctionResults{}
+
+	if t.maxLRPQueueAge > 0 {
+		liveLRPAuctions := make([]auctiontypes.LRPAuction, 0, len(lrpAuctions))
+		for _, auction := range lrpAuctions {
+			id := auction.Identifier()
+			if failed, ok := t.failedLRPs[id]; ok {
+				auction.QueueTime = failed.QueueTime
+				auction.Attempts = failed.Attempts
+			}
+
+			if now.Sub(auction.QueueTime) > t.maxLRPQueueAge {
+				delete(t.failedLRPs, id)
+				auction.PlacementError = auctiontypes.ErrorAuctionExpired.Error()
+				expired.FailedLRPs = append(expired.FailedLRPs, auction)
+				continue
+			}
+			liveLRPAuctions = append(liveLRPAuctions, auction)
+		}
+		lrpAuctions = liveLRPAuctions
+	}
+
+	if t.maxTaskQueueAge > 0 {
+		expiredGroups := map[string]bool{}
+		for i := range taskAuctions {
+			auction := &taskAuctions[i]
+			if failed, ok := t.failedTasks[auction.Identifier()]; ok {
+				auction.QueueTime = failed.QueueTime
+				auction.Attempts = failed.Attempts
+			}
+			if auction.TaskGroup != "" && now.Sub(auction.QueueTime) > t.maxTaskQueueAge {
+				expiredGroups[auction.TaskGroup] = true
+			}
+		}
+
+		liveTaskAuctions := make([]auctiontypes.TaskAuction, 0, len(taskAuctions))
+		for _, auction := range taskAuctions {
+			id := auction.Identifier()
+			if now.Sub(auction.QueueTime) > t.maxTaskQueueAge || expiredGroups[auction.TaskGroup] {
+				delete(t.failedTasks, id)
+				auction.PlacementError = auctiontypes.ErrorAuctionExpired.Error()
+				expired.FailedTasks = append(expired.FailedTasks, auction)
+				continue
+			}
+			liveTaskAuctions = append(liveTaskAuctions, auction)
+		}
+		taskAuctions = liveTaskAuctions
+	}
+
+	return lrpAuctions, taskAuctions, expired
+}
+
+// Record remembers the auctions that failed so their resubmissions can be aged
+// from their first submission, and forgets the ones that were placed along
+// with the ones that have not been resubmitted for too long.
+func (t *queueAgeTracker) Record(results auctiontypes.AuctionResults) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.maxLRPQueueAge > 0 {
+		for i := range results.SuccessfulLRPs {
+			delete(t.failedLRPs, results.SuccessfulLRPs[i].Identifier())
+		}
+		for i := range results.FailedLRPs {
+			auction := &results.FailedLRPs[i]
+			if auction.PlacementError == auctiontypes.ErrorAuctionExpired.Error() {
+				continue
+			}
+			t.failedLRPs[auction.Identifier()] = auction.AuctionRecord
+		}
+		t.prune(t.failedLRPs, t.maxLRPQueueAge)
+	}
+
+	if t.maxTaskQueueAge > 0 {
+		for i := range results.SuccessfulTasks {
+			delete(t.failedTasks, results.SuccessfulTasks[i].Identifier())
+		}
+		for i := range results.FailedTasks {
+			auction := &results.FailedTasks[i]
+			if auction.PlacementError == auctiontypes.ErrorAuctionExpired.Error() {
+				continue
+			}
+			t.failedTasks[auction.Identifier()] = auction.AuctionRecord
+		}
+		t.prune(t.failedTasks, t.maxTaskQueueAge)
+	}
+}
+
+// prune must be called with the lock held
+func (t *queueAgeTracker) prune(records map[string]auctiontypes.AuctionRecord, maxQueueAge time.Duration) {
+	now := t.clock.Now()
+	for id, record := range records {
+		if now.Sub(record.QueueTime) > 2*maxQueueAge {
+			delete(records, id)
+		}
+	}
+}
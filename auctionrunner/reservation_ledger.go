@@ -0,0 +1,67 @@
+package auctionrunner
+
+import "code.cloudfoundry.org/rep"
+
+// reservationLedger records the work committed to each cell during an auction.
+// When the next auction fetches cell states while that work is still being
+// committed, the states may not show it yet; applying the ledger to the new
+// zones keeps the same resources from being handed out twice.
+type reservationLedger map[string]rep.Work
+
+// newReservationLedger records the work that the cells in zones may have
+// accepted.  Work a cell explicitly rejected is left out, but all of the work
+// is kept for a cell whose commit failed, as it may have partially succeeded.
+func newReservationLedger(zones map[string]Zone) reservationLedger {
+	ledger := reservationLedger{}
+
+	for _, zone := range zones {
+		for _, cell := range zone {
+			work := acceptedWork(cell.committedWork, cell.failedWork)
+			if len(work.LRPs) == 0 && len(work.Tasks) == 0 {
+				continue
+			}
+			ledger[cell.Guid] = work
+		}
+	}
+
+	return ledger
+}
+
+// apply adds the recorded work to the cells in zones whose state does not
+// already show it.
+func (l reservationLedger) apply(zones map[string]Zone) {
+	for _, zone := range zones {
+		for _, cell := range zone {
+			work, ok := l[cell.Guid]
+			if !ok {
+				continue
+			}
+			cell.applyInFlightWork(&work)
+		}
+	}
+}
+
+func acceptedWork(committed, failed rep.Work) rep.Work {
+	failedLRPs := map[string]bool{}
+	for i := range failed.LRPs {
+		failedLRPs[failed.LRPs[i].Identifier()] = true
+	}
+	failedTasks := map[string]bool{}
+	for i := range failed.Tasks {
+		failedTasks[failed.Tasks[i].Identifier()] = true
+	}
+
+	accepted := rep.Work{CellID: committed.CellID}
+	for i := range committed.LRPs {
+		if !failedLRPs[committed.LRPs[i].Identifier()] {
+			accepted.LRPs = append(accepted.LRPs, committed.LRPs[i])
+		}
+	}
+	for i := range committed.Tasks {
+		if !failedTasks[committed.Tasks[i].Identifier()] {
+			accepted.Tasks = append(accepted.Tasks, committed.Tasks[i])
+		}
+	}
+
+	return accepted
+}
@@ -0,0 +1,88 @@
+package auctionrunner
+
+import (
+	"errors"
+	"sort"
+
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/rep"
+)
+
+// ResourceWeights weighs how much the share of each resource a cell has in use
+// counts towards its score.  rep.Resources.ComputeScore weighs them equally.
+type ResourceWeights struct {
+	MemoryMB   float64
+	DiskMB     float64
+	Containers float64
+
+	// Extended weighs the extended resources work asks for by name.  Extended
+	// resources left out do not count.
+	Extended map[string]float64
+}
+
+var ErrorNegativeResourceWeight = errors.New("resource weights must not be negative")
+var ErrorZeroResourceWeights = errors.New("memory, disk and container weights must not add up to zero")
+
+// Validate returns an error when any of the weights is negative, or when the
+// memory, disk and container weights add up to zero.
+func (w ResourceWeights) Validate() error {
+	if w.MemoryMB < 0 || w.DiskMB < 0 || w.Containers < 0 {
+		return ErrorNegativeResourceWeight
+	}
+	for _, weight := range w.Extended {
+		if weight < 0 {
+			return ErrorNegativeResourceWeight
+		}
+	}
+	if w.MemoryMB+w.DiskMB+w.Containers <= 0 {
+		return ErrorZeroResourceWeights
+	}
+	return nil
+}
+
+// WithResourceWeights scores cells by the weighted average of the shares of
+// memory, disk, containers and extended resources they would have in use,
+// instead of the plain average.  Weights that fail Validate are logged as an
+// error and the resources are weighed equally instead.
+func WithResourceWeights(weights ResourceWeights) SchedulerOption {
+	return func(s *Scheduler) {
+		if err := weights.Validate(); err != nil {
+			s.logger.Error("invalid-resource-weights", err, lager.Data{"weights": weights})
+			return
+		}
+		s.resourceWeights = &weights
+	}
+}
+
+// equalResourceWeights weighs memory, disk, containers and each of the
+// extended resources with shares the same.
+func equalResourceWeights(extendedShares map[string]float64) *ResourceWeights {
+	weights := &ResourceWeights{MemoryMB: 1, DiskMB: 1, Containers: 1, Extended: map[string]float64{}}
+	for name := range extendedShares {
+		weights.Extended[name] = 1
+	}
+	return weights
+}
+
+// score is the weighted average of the shares of the resources in use,
+// including the given shares of extended resources.
+func (w *ResourceWeights) score(remaining, total *rep.Resources, extendedShares map[string]float64) float64 {
+	fractionUsedMemory := 1.0 - float64(remaining.MemoryMB)/float64(total.MemoryMB)
+	fractionUsedDisk := 1.0 - float64(remaining.DiskMB)/float64(total.DiskMB)
+	fractionUsedContainers := 1.0 - float64(remaining.Containers)/float64(total.Containers)
+
+	weighted := w.MemoryMB*fractionUsedMemory + w.DiskMB*fractionUsedDisk + w.Containers*fractionUsedContainers
+	sum := w.MemoryMB + w.DiskMB + w.Containers
+
+	names := make([]string, 0, len(extendedShares))
+	for name := range extendedShares {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		weighted += w.Extended[name] * extendedShares[name]
+		sum += w.Extended[name]
+	}
+
+	return weighted / sum
+}
@@ -1,8 +1,11 @@
 package auctionrunner
 
 import (
+	"math/rand"
+	"runtime"
 	"sort"
 	"sync"
+	"time"
 
 	"code.cloudfoundry.org/auction/auctiontypes"
 	"code.cloudfoundry.org/rep"
@@ -30,8 +33,14 @@ func (z *Zone) filterCells(pc rep.PlacementConstraint) ([]*Cell, error) {
 				}
 
 				if cell.MatchPlacementTags(pc.PlacementTags) {
-					err = nil
-					cells = append(cells, cell)
+					if _, ok := err.(auctiontypes.PlacementTagMismatchError); ok {
+						err = auctiontypes.ErrorExtendedResourceMismatch
+					}
+
+					if cell.MatchExtendedResources(&pc) {
+						err = nil
+						cells = append(cells, cell)
+					}
 				}
 			}
 		}
@@ -54,6 +63,83 @@ type Scheduler struct {
 	binPackFirstFitWeight         float64
 	startingContainerWeight       float64
 	startingContainerCountMaximum int // <=0 means no limit
+	scoringChunkSize              int // <=0 means serial scoring
+	sampler                       *cellSampler
+	zoneInstances                 processInstanceIndex
+	zoneTasks                     zoneTaskIndex
+	filters                       filterCache
+	optimizerBudget               time.Duration // <=0 means greedy scheduling only
+	optimizerLookahead            int
+	resourceWeights               *ResourceWeights // nil weighs resources equally
+	pidCapacity                   func(rep.CellState) int32
+	extendedCapacity              func(rep.CellState) auctiontypes.ExtendedResources
+	extendedRequests              auctiontypes.ExtendedResourceRequests
+	overcommit                    *overcommitPolicy // nil means no overcommit
+	headroom                      *Headroom         // nil keeps no headroom
+	urgentLRP                     func(lrp *rep.LRP) bool
+	urgentTask                    func(task *rep.Task) bool
+	overhead                      func(rep.CellState) auctiontypes.ContainerOverhead
+	taskStrategy                  TaskPlacementStrategy
+	taskGroupingKey               func(task *rep.Task) string
+	stopped                       func() bool // nil places every auction
+}
+
+type SchedulerOption func(*Scheduler)
+
+// WithParallelScoring scores the cells of an auction concurrently on the
+// scheduler's work pool, in chunks of at least minChunkSize cells and no more
+// chunks than GOMAXPROCS.  Chunks are merged in cell order, so the winners are
+// the same ones serial scoring picks.
+func WithParallelScoring(minChunkSize int) SchedulerOption {
+	return func(s *Scheduler) {
+		s.scoringChunkSize = minChunkSize
+	}
+}
+
+// WithSampledScoring scores only sampleSize cells, picked at random from the
+// cells matching the placement constraint, of each zone an auction considers.
+// The rest of a zone is only scored when none of the sampled cells has room.
+// Zones are still balanced as usual.  The rng is drawn from on every auction,
+// so seeding it makes the placements reproducible.
+func WithSampledScoring(sampleSize int, rng *rand.Rand) SchedulerOption {
+	sampler := &cellSampler{size: sampleSize, rng: rng, lock: &sync.Mutex{}}
+	return func(s *Scheduler) {
+		if sampleSize > 0 {
+			s.sampler = sampler
+		}
+	}
+}
+
+// WithPidCapacity limits the pids the containers on each cell may use in total
+// to what capacity returns for the cell, and fails work on cells without
+// enough pids left with the "pids" problem.  Cells for which capacity returns
+// 0 are not limited.
+func WithPidCapacity(capacity func(state rep.CellState) int32) SchedulerOption {
+	return func(s *Scheduler) {
+		s.pidCapacity = capacity
+	}
+}
+
+// WithExtendedResources gives each cell the extended resources capacity
+// returns for it, and has work take up the ones requests returns for its
+// placement constraint.  Work only goes to cells that have every extended
+// resource it asks for, and fails on cells without enough of one left with a
+// problem named after the resource.
+func WithExtendedResources(capacity func(state rep.CellState) auctiontypes.ExtendedResources, requests auctiontypes.ExtendedResourceRequests) SchedulerOption {
+	return func(s *Scheduler) {
+		s.extendedCapacity = capacity
+		s.extendedRequests = requests
+	}
+}
+
+// WithContainerOverhead has each container take up what overhead returns for
+// its cell on top of its own resources, the same way when matching, scoring
+// and reserving work.  LRP containers always take up the proxy memory the cell
+// reports.
+func WithContainerOverhead(overhead func(state rep.CellState) auctiontypes.ContainerOverhead) SchedulerOption {
+	return func(s *Scheduler) {
+		s.overhead = overhead
+	}
 }
 
 func NewScheduler(
@@ -64,8 +150,9 @@ func NewScheduler(
 	binPackFirstFitWeight float64,
 	startingContainerWeight float64,
 	startingContainerCountMaximum int,
+	options ...SchedulerOption,
 ) *Scheduler {
-	return &Scheduler{
+	scheduler := &Scheduler{
 		workPool:                      workPool,
 		zones:                         zones,
 		clock:                         clock,
@@ -73,19 +160,61 @@ func NewScheduler(
 		binPackFirstFitWeight:         binPackFirstFitWeight,
 		startingContainerWeight:       startingContainerWeight,
 		startingContainerCountMaximum: startingContainerCountMaximum,
+		zoneInstances:                 newProcessInstanceIndex(zones),
+		filters:                       filterCache{},
+		urgentLRP:                     isFirstInstance,
 	}
+
+	for _, option := range options {
+		option(scheduler)
+	}
+
+	for _, zone := range zones {
+		for _, cell := range zone {
+			scheduler.trackResources(cell)
+		}
+	}
+	scheduler.zoneTasks = newZoneTaskIndex(zones)
+
+	return scheduler
 }
 
 /*
 Schedule takes in a set of job requests (LRP start auctions and task starts) and
 assigns the work to available cells according to the diego scoring algorithm. The
-scheduler is single-threaded.  It determines scheduling of jobs one at a time so
-that each calculation reflects available resources correctly.  It commits the
-work in batches at the end, for better network performance.  Schedule returns
+scheduler determines scheduling of jobs one at a time so that each calculation
+reflects available resources correctly, although the cells may be scored in
+parallel for each job.  With a batch optimizer the whole batch may be planned
+up front instead, when that places more jobs.  The tasks of each task group are
+placed together on one cell or fail together, ahead of the other tasks; batches
+with task groups are never planned.  It commits the work in batches at the end,
+for better network performance.  Schedule returns
 AuctionResults, indicating the success or failure of each requested job.
 */
 func (s *Scheduler) Schedule(auctionRequest auctiontypes.AuctionRequest) auctiontypes.AuctionResults {
-	results := auctiontypes.AuctionResults{}
+	return s.commit(s.place(auctionRequest))
+}
+
+// placement holds the outcome of placing auctions on the cells before the work
+// has been committed to them.
+type placement struct {
+	results               auctiontypes.AuctionResults
+	successfulLRPs        map[string]*auctiontypes.LRPAuction
+	lrpStartAuctionLookup map[string]*auctiontypes.LRPAuction
+	successfulTasks       map[string]*auctiontypes.TaskAuction
+	taskAuctionLookup     map[string]*auctiontypes.TaskAuction
+}
+
+// place reserves the work of every auction on the winning cells without
+// committing it.
+func (s *Scheduler) place(auctionRequest auctiontypes.AuctionRequest) *placement {
+	p := &placement{
+		successfulLRPs:        map[string]*auctiontypes.LRPAuction{},
+		lrpStartAuctionLookup: map[string]*auctiontypes.LRPAuction{},
+		successfulTasks:       map[string]*auctiontypes.TaskAuction{},
+		taskAuctionLookup:     map[string]*auctiontypes.TaskAuction{},
+	}
+	results := &p.results
 
 	if len(s.zones) == 0 {
 		results.FailedLRPs = auctionRequest.LRPs
@@ -96,59 +225,54 @@ func (s *Scheduler) Schedule(auctionRequest auctiontypes.AuctionRequest) auction
 		for i, _ := range results.FailedTasks {
 			results.FailedTasks[i].PlacementError = auctiontypes.ErrorCellCommunication.Error()
 		}
-		return s.markResults(results)
+		return p
 	}
 
-	var successfulLRPs = map[string]*auctiontypes.LRPAuction{}
-	var lrpStartAuctionLookup = map[string]*auctiontypes.LRPAuction{}
-	var successfulTasks = map[string]*auctiontypes.TaskAuction{}
-	var taskAuctionLookup = map[string]*auctiontypes.TaskAuction{}
-	var currentInflightContainerStarts int
-
-	for _, zone := range s.zones {
-		for _, cell := range zone {
-			currentInflightContainerStarts += cell.StartingContainerCount()
-		}
-	}
+	successfulLRPs := p.successfulLRPs
+	lrpStartAuctionLookup := p.lrpStartAuctionLookup
+	successfulTasks := p.successfulTasks
+	taskAuctionLookup := p.taskAuctionLookup
+	currentInflightContainerStarts := s.inflightContainerStarts()
 
 	sort.Sort(SortableLRPAuctions(auctionRequest.LRPs))
 	sort.Sort(SortableTaskAuctions(auctionRequest.Tasks))
 
 	lrpsBeforeTasks, lrpsAfterTasks := splitLRPS(auctionRequest.LRPs)
+	tasks, taskGroups := splitTasks(auctionRequest.Tasks)
 
-	auctionLRP := func(lrpsToAuction []auctiontypes.LRPAuction) {
-		for i := range lrpsToAuction {
-			lrpAuction := &lrpsToAuction[i]
-			lrpStartAuctionLookup[lrpAuction.Identifier()] = lrpAuction
-
-			if s.exceededInflightContainerCreation(currentInflightContainerStarts) {
-				s.logger.Info(
-					"exceeded-max-inflight-container-creation",
-					lager.Data{
-						"max-inflight": s.startingContainerCountMaximum,
-						"lrp-guid":     lrpAuction.Identifier(),
-					},
-				)
-				lrpAuction.PlacementError = auctiontypes.ErrorExceededInflightCreation.Error()
-				results.FailedLRPs = append(results.FailedLRPs, *lrpAuction)
-				continue
-			}
+	auctionLRP := func(lrpAuction *auctiontypes.LRPAuction, planned *plannedAuction) {
+		if s.placementStopped() {
+			return
+		}
+		lrpStartAuctionLookup[lrpAuction.Identifier()] = lrpAuction
 
-			successfulStart, err := s.scheduleLRPAuction(lrpAuction)
-			if err != nil {
-				lrpAuction.PlacementError = err.Error()
-				results.FailedLRPs = append(results.FailedLRPs, *lrpAuction)
-			} else {
-				successfulLRPs[successfulStart.Identifier()] = successfulStart
-				currentInflightContainerStarts++
-			}
+		if s.exceededInflightContainerCreation(currentInflightContainerStarts) {
+			s.logger.Info(
+				"exceeded-max-inflight-container-creation",
+				lager.Data{
+					"max-inflight": s.startingContainerCountMaximum,
+					"lrp-guid":     lrpAuction.Identifier(),
+				},
+			)
+			lrpAuction.PlacementError = auctiontypes.ErrorExceededInflightCreation.Error()
+			results.FailedLRPs = append(results.FailedLRPs, *lrpAuction)
+			return
 		}
-	}
 
-	auctionLRP(lrpsBeforeTasks)
+		successfulStart, err := s.placePlannedLRP(lrpAuction, planned)
+		if err != nil {
+			lrpAuction.PlacementError = err.Error()
+			results.FailedLRPs = append(results.FailedLRPs, *lrpAuction)
+		} else {
+			successfulLRPs[successfulStart.Identifier()] = successfulStart
+			currentInflightContainerStarts++
+		}
+	}
 
-	for i := range auctionRequest.Tasks {
-		taskAuction := &auctionRequest.Tasks[i]
+	auctionTask := func(taskAuction *auctiontypes.TaskAuction, planned *plannedAuction) {
+		if s.placementStopped() {
+			return
+		}
 		taskAuctionLookup[taskAuction.Identifier()] = taskAuction
 
 		if s.exceededInflightContainerCreation(currentInflightContainerStarts) {
@@ -161,10 +285,10 @@ func (s *Scheduler) Schedule(auctionRequest auctiontypes.AuctionRequest) auction
 			)
 			taskAuction.PlacementError = auctiontypes.ErrorExceededInflightCreation.Error()
 			results.FailedTasks = append(results.FailedTasks, *taskAuction)
-			continue
+			return
 		}
 
-		successfulTask, err := s.scheduleTaskAuction(taskAuction, s.startingContainerWeight)
+		successfulTask, err := s.placePlannedTask(taskAuction, planned)
 		if err != nil {
 			taskAuction.PlacementError = err.Error()
 			results.FailedTasks = append(results.FailedTasks, *taskAuction)
@@ -174,9 +298,116 @@ func (s *Scheduler) Schedule(auctionRequest auctiontypes.AuctionRequest) auction
 		}
 	}
 
-	auctionLRP(lrpsAfterTasks)
+	auctionTaskGroup := func(taskAuctions []*auctiontypes.TaskAuction) {
+		if s.placementStopped() {
+			return
+		}
+		for _, taskAuction := range taskAuctions {
+			taskAuctionLookup[taskAuction.Identifier()] = taskAuction
+		}
+
+		var err error
+		if s.exceededInflightContainerCreation(currentInflightContainerStarts + len(taskAuctions) - 1) {
+			s.logger.Info(
+				"exceeded-max-inflight-container-creation",
+				lager.Data{
+					"max-inflight": s.startingContainerCountMaximum,
+					"task-group":   taskAuctions[0].TaskGroup,
+				},
+			)
+			err = auctiontypes.ErrorExceededInflightCreation
+		} else {
+			var successfulGroup []*auctiontypes.TaskAuction
+			successfulGroup, err = s.scheduleTaskGroup(taskAuctions)
+			for _, successfulTask := range successfulGroup {
+				successfulTasks[successfulTask.Identifier()] = successfulTask
+				currentInflightContainerStarts++
+			}
+		}
+
+		if err != nil {
+			for _, taskAuction := range taskAuctions {
+				taskAuction.PlacementError = err.Error()
+				results.FailedTasks = append(results.FailedTasks, *taskAuction)
+			}
+		}
+	}
+
+	if len(taskGroups) == 0 {
+		plan := s.planBatch(lrpsBeforeTasks, tasks, lrpsAfterTasks, currentInflightContainerStarts)
+		if plan != nil {
+			for i := range plan.auctions {
+				planned := &plan.auctions[i]
+				if planned.lrp != nil {
+					auctionLRP(planned.lrp, planned)
+				} else {
+					auctionTask(planned.task, planned)
+				}
+			}
+			return p
+		}
+	}
+
+	for i := range lrpsBeforeTasks {
+		auctionLRP(&lrpsBeforeTasks[i], nil)
+	}
+	for _, taskGroup := range taskGroups {
+		auctionTaskGroup(taskGroup)
+	}
+	for i := range tasks {
+		auctionTask(&tasks[i], nil)
+	}
+	for i := range lrpsAfterTasks {
+		auctionLRP(&lrpsAfterTasks[i], nil)
+	}
+
+	return p
+}
+
+// placementStopped reports whether place should leave the remaining auctions
+// alone, which only a trial scheduler is ever told to do.
+func (s *Scheduler) placementStopped() bool {
+	return s.stopped != nil && s.stopped()
+}
+
+// clone returns a scheduler configured like s that schedules on zones.  The
+// cells of zones must already track their resources the way s has its cells
+// track them, as they are not tracked again.
+func (s *Scheduler) clone(zones map[string]Zone, logger lager.Logger) *Scheduler {
+	clone := *s
+	clone.zones = zones
+	clone.logger = logger
+	clone.zoneInstances = newProcessInstanceIndex(zones)
+	clone.zoneTasks = newZoneTaskIndex(zones)
+	clone.filters = filterCache{}
+	return &clone
+}
+
+// commit sends the placed work to the cells and reports the auctions whose
+// work the cells rejected as failed.
+func (s *Scheduler) commit(p *placement) auctiontypes.AuctionResults {
+	results := &p.results
+	successfulLRPs := p.successfulLRPs
+	lrpStartAuctionLookup := p.lrpStartAuctionLookup
+	successfulTasks := p.successfulTasks
+	taskAuctionLookup := p.taskAuctionLookup
 
 	failedWorks := s.commitCells()
+	for attempt := 1; ; attempt++ {
+		staleWork := s.refreshStaleCells()
+		if len(staleWork.LRPs) == 0 && len(staleWork.Tasks) == 0 {
+			break
+		}
+
+		if attempt > maxStaleCellStateRetries {
+			s.failStaleWork(p, staleWork)
+			break
+		}
+
+		s.placeStaleWork(p, staleWork)
+		failedWorks = append(failedWorks, s.commitCells()...)
+	}
+
 	for _, failedWork := range failedWorks {
 		for _, failedStart := range failedWork.LRPs {
 			identifier := failedStart.Identifier()
@@ -203,7 +434,145 @@ func (s *Scheduler) Schedule(auctionRequest auctiontypes.AuctionRequest) auction
 		s.logger.Info("task-added-to-cell", lager.Data{"task-guid": successfulTask.Identifier(), "cell-guid": successfulTask.Winner})
 		results.SuccessfulTasks = append(results.SuccessfulTasks, *successfulTask)
 	}
-	return s.markResults(results)
+	return s.markResults(*results)
+}
+
+// maxStaleCellStateRetries bounds how many times work that a cell rejected
+// because its state moved on is placed again within one auction.
+const maxStaleCellStateRetries = 2
+
+// refreshStaleCells replaces every cell that rejected work because its state
+// moved on with a cell built from its current state, and returns the rejected
+// work.
+func (s *Scheduler) refreshStaleCells() rep.Work {
+	staleWork := rep.Work{}
+
+	for name, zone := range s.zones {
+		for i, cell := range zone {
+			if len(cell.staleWork.LRPs) == 0 && len(cell.staleWork.Tasks) == 0 {
+				continue
+			}
+
+			staleWork.LRPs = append(staleWork.LRPs, cell.staleWork.LRPs...)
+			staleWork.Tasks = append(staleWork.Tasks, cell.staleWork.Tasks...)
+			cell.staleWork = rep.Work{}
+
+			state, version, err := cellState(s.logger, cell.client)
+			if err != nil {
+				s.logger.Error("failed-to-refresh-stale-cell-state", err, lager.Data{"cell-guid": cell.Guid})
+				continue
+			}
+
+			refreshedCell := NewCell(s.logger, cell.Guid, cell.client, state)
+			refreshedCell.Index = cell.Index
+			refreshedCell.version = version
+			refreshedCell.committedWork = cell.committedWork
+			refreshedCell.failedWork = cell.failedWork
+			refreshedCell.rejectedWork = true
+			s.trackResources(refreshedCell)
+			zone[i] = refreshedCell
+			s.zoneInstances.addCell(name, cell, -1)
+			s.zoneInstances.addCell(name, refreshedCell, 1)
+			s.zoneTasks.addCell(name, cell, -1)
+			s.zoneTasks.addCell(name, refreshedCell, 1)
+			s.filters.invalidate()
+		}
+	}
+
+	return staleWork
+}
+
+// placeStaleWork scores the auctions of the stale work again and reserves them
+// on the new winners, within the limit on in-flight container starts.
+func (s *Scheduler) placeStaleWork(p *placement, staleWork rep.Work) {
+	currentInflightContainerStarts := s.inflightContainerStarts()
+
+	for i := range staleWork.LRPs {
+		identifier := staleWork.LRPs[i].Identifier()
+		delete(p.successfulLRPs, identifier)
+
+		lrpAuction := p.lrpStartAuctionLookup[identifier]
+		if s.exceededInflightContainerCreation(currentInflightContainerStarts) {
+			lrpAuction.PlacementError = auctiontypes.ErrorExceededInflightCreation.Error()
+			p.results.FailedLRPs = append(p.results.FailedLRPs, *lrpAuction)
+			continue
+		}
+
+		successfulStart, err := s.scheduleLRPAuction(lrpAuction)
+		if err != nil {
+			lrpAuction.PlacementError = err.Error()
+			p.results.FailedLRPs = append(p.results.FailedLRPs, *lrpAuction)
+			continue
+		}
+		p.successfulLRPs[identifier] = successfulStart
+		currentInflightContainerStarts++
+	}
+
+	staleTasks := make([]*auctiontypes.TaskAuction, len(staleWork.Tasks))
+	for i := range staleWork.Tasks {
+		identifier := staleWork.Tasks[i].Identifier()
+		delete(p.successfulTasks, identifier)
+		staleTasks[i] = p.taskAuctionLookup[identifier]
+	}
+
+	ungroupedTasks, taskGroups := splitTaskGroups(staleTasks)
+	for _, taskGroup := range taskGroups {
+		var successfulGroup []*auctiontypes.TaskAuction
+		err := auctiontypes.ErrorExceededInflightCreation
+		if !s.exceededInflightContainerCreation(currentInflightContainerStarts + len(taskGroup) - 1) {
+			successfulGroup, err = s.scheduleTaskGroup(taskGroup)
+		}
+		if err != nil {
+			for _, taskAuction := range taskGroup {
+				taskAuction.PlacementError = err.Error()
+				p.results.FailedTasks = append(p.results.FailedTasks, *taskAuction)
+			}
+			continue
+		}
+		for _, successfulTask := range successfulGroup {
+			p.successfulTasks[successfulTask.Identifier()] = successfulTask
+			currentInflightContainerStarts++
+		}
+	}
+
+	for _, taskAuction := range ungroupedTasks {
+		if s.exceededInflightContainerCreation(currentInflightContainerStarts) {
+			taskAuction.PlacementError = auctiontypes.ErrorExceededInflightCreation.Error()
+			p.results.FailedTasks = append(p.results.FailedTasks, *taskAuction)
+			continue
+		}
+
+		successfulTask, err := s.scheduleTaskAuction(taskAuction, s.startingContainerWeight)
+		if err != nil {
+			taskAuction.PlacementError = err.Error()
+			p.results.FailedTasks = append(p.results.FailedTasks, *taskAuction)
+			continue
+		}
+		p.successfulTasks[successfulTask.Identifier()] = successfulTask
+		currentInflightContainerStarts++
+	}
+}
+
+func (s *Scheduler) failStaleWork(p *placement, staleWork rep.Work) {
+	for i := range staleWork.LRPs {
+		identifier := staleWork.LRPs[i].Identifier()
+		delete(p.successfulLRPs, identifier)
+
+		s.logger.Info("lrp-failed-to-be-placed", lager.Data{"lrp-guid": identifier})
+		lrpAuction := p.lrpStartAuctionLookup[identifier]
+		lrpAuction.PlacementError = auctiontypes.ErrorStaleCellState.Error()
+		p.results.FailedLRPs = append(p.results.FailedLRPs, *lrpAuction)
+	}
+
+	for i := range staleWork.Tasks {
+		identifier := staleWork.Tasks[i].Identifier()
+		delete(p.successfulTasks, identifier)
+
+		s.logger.Info("task-failed-to-be-placed", lager.Data{"task-guid": identifier})
+		taskAuction := p.taskAuctionLookup[identifier]
+		taskAuction.PlacementError = auctiontypes.ErrorStaleCellState.Error()
+		p.results.FailedTasks = append(p.results.FailedTasks, *taskAuction)
+	}
 }
 
 func (s *Scheduler) markResults(results auctiontypes.AuctionResults) auctiontypes.AuctionResults {
@@ -287,34 +656,61 @@ func NewCellResourceState(state rep.CellState) CellResourceState {
 }
 
 func (s *Scheduler) scheduleLRPAuction(lrpAuction *auctiontypes.LRPAuction) (*auctiontypes.LRPAuction, error) {
-	var winnerCell *Cell
-	winnerScore := 1e20
+	zones := accumulateZonesByInstances(s.zones, s.zoneNames(), s.zoneInstances, lrpAuction.ProcessGuid)
 
-	zones := accumulateZonesByInstances(s.zones, lrpAuction.ProcessGuid)
-
-	filteredZones, err := filterZones(zones, lrpAuction)
+	filteredZones, err := filterZones(s.filters, zones, lrpAuction)
 	if err != nil {
 		return nil, err
 	}
 
 	sortedZones := sortZonesByInstances(filteredZones)
-	problems := map[string]struct{}{"disk": struct{}{}, "memory": struct{}{}, "containers": struct{}{}}
+	scores := newCellScores(s.extraProblems(&lrpAuction.PlacementConstraint)...)
+	scoreForLRP := func(cell *Cell) (float64, error) {
+		return cell.scoreForLRP(&lrpAuction.LRP, s.startingContainerWeight, s.binPackFirstFitWeight, s.resourceWeights)
+	}
 
-	cellStates := map[string]CellResourceState{}
+	winnerZone := s.scoreSortedZones(sortedZones, &scores, scoreForLRP)
 
-	for zoneIndex, lrpByZone := range sortedZones {
-		for _, cell := range lrpByZone.zone {
-			score, err := cell.ScoreForLRP(&lrpAuction.LRP, s.startingContainerWeight, s.binPackFirstFitWeight)
-			if err != nil {
-				cellStates[cell.Guid] = NewCellResourceState(cell.State())
-				removeNonApplicableProblems(problems, err)
-				continue
-			}
+	winnerCell := scores.winner
+	if winnerCell == nil {
+		for _, lrpByZone := range sortedZones {
+			s.scoreCells(lrpByZone.exhausted, &scores, scoreForLRP)
+		}
 
-			if score < winnerScore {
-				winnerScore = score
-				winnerCell = cell
-			}
+		err := &rep.InsufficientResourcesError{Problems: scores.problems}
+		s.logger.Error("lrp-auction-failed", err, lager.Data{"lrp-guid": lrpAuction.Identifier(), "lrp-instance-guid": lrpAuction.LRP.InstanceGUID, "lrp-placement-constraints": lrpAuction.LRP.PlacementConstraint, "lrp-resource": lrpAuction.LRP.Resource})
+		s.logger.Debug("cells-failing-score-for-lrp", lager.Data{"states": scores.failedCellStates()})
+		return nil, err
+	}
+
+	err = winnerCell.ReserveLRP(&lrpAuction.LRP)
+	if err != nil {
+		s.logger.Error("lrp-failed-to-reserve-cell", err, lager.Data{"cell-guid": winnerCell.Guid, "lrp-guid": lrpAuction.Identifier(), "lrp-instance-guid": lrpAuction.LRP.InstanceGUID, "lrp-placement-constraints": lrpAuction.LRP.PlacementConstraint, "lrp-resource": lrpAuction.LRP.Resource})
+		s.logger.Debug("cells-failing-score-for-lrp", lager.Data{"states": scores.failedCellStates()})
+		return nil, err
+	}
+
+	s.zoneInstances[winnerZone][lrpAuction.ProcessGuid]++
+	if winnerCell.exhausted() {
+		s.filters.invalidate()
+	}
+
+	winningAuction := lrpAuction.Copy()
+	winningAuction.Winner = winnerCell.Guid
+	winningAuction.Overcommitted = winnerCell.overcommitted()
+	return &winningAuction, nil
+}
+
+// scoreSortedZones scores the cells of the zones in order, up to the first
+// zone with a winner that is not tied with the next one, and returns the zone
+// of the winner.
+func (s *Scheduler) scoreSortedZones(sortedZones []lrpByZone, scores *cellScores, score func(*Cell) (float64, error)) string {
+	var winnerZone string
+	for zoneIndex, lrpByZone := range sortedZones {
+		previousWinner := scores.winner
+		s.scoreZone(lrpByZone.zone, scores, score)
+		if scores.winner != previousWinner {
+			winnerZone = lrpByZone.name
 		}
 
 		// if (not last zone) && (this zone has the same # of instances as the next sorted zone)
@@ -324,90 +720,300 @@ func (s *Scheduler) scheduleLRPAuction(lrpAuction *auctiontypes.LRPAuction) (*au
 			continue
 		}
 
-		if winnerCell != nil {
+		if scores.winner != nil {
 			break
 		}
 	}
+	return winnerZone
+}
+
+func (s *Scheduler) scheduleTaskAuction(taskAuction *auctiontypes.TaskAuction, startingContainerWeight float64) (*auctiontypes.TaskAuction, error) {
+	groupingKey := s.groupingKey(taskAuction)
+	filteredZones, err := s.filterTaskZones([]*auctiontypes.TaskAuction{taskAuction}, groupingKey)
+	if err != nil {
+		return nil, err
+	}
+
+	scores := newCellScores(s.extraProblems(&taskAuction.PlacementConstraint)...)
+	scoreForTask := func(cell *Cell) (float64, error) {
+		if s.taskStrategy == PackTasks {
+			return cell.packScoreForTask(&taskAuction.Task, startingContainerWeight, s.binPackFirstFitWeight, s.resourceWeights)
+		}
+		return cell.scoreForTask(&taskAuction.Task, startingContainerWeight, s.resourceWeights)
+	}
+
+	winnerZone := s.scoreSortedZones(sortZonesByInstances(filteredZones), &scores, scoreForTask)
 
+	winnerCell := scores.winner
 	if winnerCell == nil {
-		err := &rep.InsufficientResourcesError{Problems: problems}
-		s.logger.Error("lrp-auction-failed", err, lager.Data{"lrp-guid": lrpAuction.Identifier(), "lrp-instance-guid": lrpAuction.LRP.InstanceGUID, "lrp-placement-constraints": lrpAuction.LRP.PlacementConstraint, "lrp-resource": lrpAuction.LRP.Resource})
-		s.logger.Debug("cells-failing-score-for-lrp", lager.Data{"states": cellStates})
+		for _, zone := range filteredZones {
+			s.scoreCells(zone.exhausted, &scores, scoreForTask)
+		}
+
+		err := &rep.InsufficientResourcesError{Problems: scores.problems}
+		s.logger.Error("task-auction-failed", err, lager.Data{"task-guid": taskAuction.Identifier()})
 		return nil, err
 	}
 
-	err = winnerCell.ReserveLRP(&lrpAuction.LRP)
+	err = winnerCell.ReserveTask(&taskAuction.Task)
 	if err != nil {
-		s.logger.Error("lrp-failed-to-reserve-cell", err, lager.Data{"cell-guid": winnerCell.Guid, "lrp-guid": lrpAuction.Identifier(), "lrp-instance-guid": lrpAuction.LRP.InstanceGUID, "lrp-placement-constraints": lrpAuction.LRP.PlacementConstraint, "lrp-resource": lrpAuction.LRP.Resource})
-		s.logger.Debug("cells-failing-score-for-lrp", lager.Data{"states": cellStates})
+		s.logger.Error("task-failed-to-reserve-cell", err, lager.Data{"cell-guid": winnerCell.Guid, "task-guid": taskAuction.Identifier()})
 		return nil, err
 	}
 
-	winningAuction := lrpAuction.Copy()
+	winnerCell.addToTaskGroup(groupingKey, 1)
+	s.zoneTasks.addTask(winnerZone, groupingKey)
+
+	if winnerCell.exhausted() {
+		s.filters.invalidate()
+	}
+
+	winningAuction := taskAuction.Copy()
 	winningAuction.Winner = winnerCell.Guid
+	winningAuction.Overcommitted = winnerCell.overcommitted()
 	return &winningAuction, nil
 }
 
-func (s *Scheduler) scheduleTaskAuction(taskAuction *auctiontypes.TaskAuction, startingContainerWeight float64) (*auctiontypes.TaskAuction, error) {
-	var winnerCell *Cell
-	winnerScore := 1e20
+// zoneNames returns the zones in the order auctions visit them.  Parallel and
+// sampled scoring visit them by name, so that they place work the same way
+// every time; otherwise they are visited in map order.
+func (s *Scheduler) zoneNames() []string {
+	if s.scoringChunkSize > 0 || s.sampler != nil {
+		return sortedZoneNames(s.zones)
+	}
+
+	names := make([]string, 0, len(s.zones))
+	for name := range s.zones {
+		names = append(names, name)
+	}
+	return names
+}
 
-	filteredZones := []Zone{}
+// filterTaskZones filters the zones for the tasks, keeping the cells that
+// match every one of them.  instances counts the tasks sharing the grouping key
+// in each zone, or all the tasks when tasks are balanced across zones, and is 0
+// otherwise so that every zone is scored.
+func (s *Scheduler) filterTaskZones(taskAuctions []*auctiontypes.TaskAuction, groupingKey string) ([]lrpByZone, error) {
+	filteredZones := []lrpByZone{}
 	var zoneError error
 
-	for _, zone := range s.zones {
-		cells, err := zone.filterCells(taskAuction.PlacementConstraint)
+	for _, name := range s.zoneNames() {
+		cells, exhausted, err := s.filterTaskZone(name, taskAuctions)
 		if err != nil {
 			_, isZoneErrorPlacementTagMismatchError := zoneError.(auctiontypes.PlacementTagMismatchError)
 			_, isErrPlacementTagMismatchError := err.(auctiontypes.PlacementTagMismatchError)
 
 			if isZoneErrorPlacementTagMismatchError ||
 				(zoneError == auctiontypes.ErrorVolumeDriverMismatch && isErrPlacementTagMismatchError) ||
-				zoneError == auctiontypes.ErrorCellMismatch || zoneError == nil {
+				zoneError == auctiontypes.ErrorCellMismatch || zoneError == nil ||
+				err == auctiontypes.ErrorExtendedResourceMismatch {
 				zoneError = err
 			}
 			continue
 		}
 
-		filteredZones = append(filteredZones, Zone(cells))
+		filteredZone := lrpByZone{name: name, zone: cells, exhausted: exhausted}
+		if groupingKey != "" || s.taskStrategy == BalanceTasksAcrossZones {
+			filteredZone.instances = s.zoneTasks[name][groupingKey]
+		}
+		filteredZones = append(filteredZones, filteredZone)
 	}
 
 	if len(filteredZones) == 0 {
 		return nil, zoneError
 	}
+	return filteredZones, nil
+}
 
-	problems := map[string]struct{}{"disk": struct{}{}, "memory": struct{}{}, "containers": struct{}{}}
+// filterTaskZone returns the cells of the zone that match every one of the
+// tasks, and those of them that are exhausted.
+func (s *Scheduler) filterTaskZone(name string, taskAuctions []*auctiontypes.TaskAuction) (Zone, Zone, error) {
+	var cells, exhausted []*Cell
+	for i, taskAuction := range taskAuctions {
+		filtered := s.filters.filter(name, s.zones[name], taskAuction.PlacementConstraint)
+		if filtered.err != nil {
+			return nil, nil, filtered.err
+		}
+		if i == 0 {
+			cells, exhausted = filtered.cells, filtered.exhausted
+			continue
+		}
+		cells = intersectCells(cells, filtered.cells)
+		exhausted = intersectCells(exhausted, filtered.exhausted)
+	}
 
-	for _, zone := range filteredZones {
-		for _, cell := range zone {
-			score, err := cell.ScoreForTask(&taskAuction.Task, startingContainerWeight)
-			if err != nil {
-				removeNonApplicableProblems(problems, err)
-				continue
-			}
+	if len(cells) == 0 && len(exhausted) == 0 {
+		return nil, nil, auctiontypes.ErrorTaskGroupMismatch
+	}
+	return Zone(cells), Zone(exhausted), nil
+}
 
-			if score < winnerScore {
-				winnerScore = score
-				winnerCell = cell
-			}
-		}
+// cellScores is the outcome of scoring cells for a single auction: the cell
+// with the lowest score, the earliest one on a tie, and the cells that could
+// not take the work.
+type cellScores struct {
+	winner      *Cell
+	winnerScore float64
+	failed      []*Cell
+	problems    map[string]struct{}
+}
+
+// newCellScores starts out with every problem, along with the extra ones, so
+// that the problems every cell has remain once the cells are scored.
+func newCellScores(extraProblems ...string) cellScores {
+	scores := cellScores{
+		winnerScore: 1e20,
+		problems:    map[string]struct{}{"disk": struct{}{}, "memory": struct{}{}, "containers": struct{}{}},
+	}
+	for _, problem := range extraProblems {
+		scores.problems[problem] = struct{}{}
 	}
+	return scores
+}
 
-	if winnerCell == nil {
-		err := &rep.InsufficientResourcesError{Problems: problems}
-		s.logger.Error("task-auction-failed", err, lager.Data{"task-guid": taskAuction.Identifier()})
-		return nil, err
+// part returns scores for some of the cells, to be merged into these ones,
+// starting out with the same problems.
+func (c *cellScores) part() cellScores {
+	scores := cellScores{winnerScore: 1e20, problems: make(map[string]struct{}, len(c.problems))}
+	for problem := range c.problems {
+		scores.problems[problem] = struct{}{}
 	}
+	return scores
+}
 
-	err := winnerCell.ReserveTask(&taskAuction.Task)
+func (c *cellScores) add(cell *Cell, score float64, err error) {
 	if err != nil {
-		s.logger.Error("task-failed-to-reserve-cell", err, lager.Data{"cell-guid": winnerCell.Guid, "task-guid": taskAuction.Identifier()})
-		return nil, err
+		c.failed = append(c.failed, cell)
+		removeNonApplicableProblems(c.problems, err)
+		return
 	}
 
-	winningAuction := taskAuction.Copy()
-	winningAuction.Winner = winnerCell.Guid
-	return &winningAuction, nil
+	if score < c.winnerScore {
+		c.winnerScore = score
+		c.winner = cell
+	}
+}
+
+func (c *cellScores) failedCellStates() map[string]CellResourceState {
+	cellStates := map[string]CellResourceState{}
+	for _, cell := range c.failed {
+		cellStates[cell.Guid] = NewCellResourceState(cell.State())
+	}
+	return cellStates
+}
+
+// merge adds the scores of cells that come after the ones already scored.
+func (c *cellScores) merge(other *cellScores) {
+	c.failed = append(c.failed, other.failed...)
+	for problem := range c.problems {
+		if _, ok := other.problems[problem]; !ok {
+			delete(c.problems, problem)
+		}
+	}
+
+	if other.winner != nil && other.winnerScore < c.winnerScore {
+		c.winnerScore = other.winnerScore
+		c.winner = other.winner
+	}
+}
+
+// scoreZone adds the scores of the cells of a zone, or of a sample of them with
+// sampled scoring.
+func (s *Scheduler) scoreZone(cells []*Cell, scores *cellScores, score func(*Cell) (float64, error)) {
+	if s.sampler == nil || len(cells) <= s.sampler.size {
+		s.scoreCells(cells, scores, score)
+		return
+	}
+
+	sampled, rest := s.sampler.sample(cells)
+	zoneScores := scores.part()
+	s.scoreCells(sampled, &zoneScores, score)
+	if zoneScores.winner == nil {
+		s.scoreCells(rest, &zoneScores, score)
+	}
+	scores.merge(&zoneScores)
+}
+
+type cellSampler struct {
+	size int
+	rng  *rand.Rand
+	lock *sync.Mutex
+}
+
+// sample picks size of the cells at random, keeping them in order, and returns
+// them along with the cells that were left out.
+func (c *cellSampler) sample(cells []*Cell) ([]*Cell, []*Cell) {
+	picked := make(map[int]struct{}, c.size)
+
+	c.lock.Lock()
+	for j := len(cells) - c.size; j < len(cells); j++ {
+		i := c.rng.Intn(j + 1)
+		if _, ok := picked[i]; ok {
+			i = j
+		}
+		picked[i] = struct{}{}
+	}
+	c.lock.Unlock()
+
+	sampled := make([]*Cell, 0, c.size)
+	rest := make([]*Cell, 0, len(cells)-c.size)
+	for i, cell := range cells {
+		if _, ok := picked[i]; ok {
+			sampled = append(sampled, cell)
+		} else {
+			rest = append(rest, cell)
+		}
+	}
+	return sampled, rest
+}
+
+// scoreCells adds the scores of the cells, in order.  With parallel scoring
+// the cells are split into contiguous chunks that are scored concurrently and
+// merged in order, which gives the same result as scoring them one by one.
+func (s *Scheduler) scoreCells(cells []*Cell, scores *cellScores, score func(*Cell) (float64, error)) {
+	chunkSize := s.scoringChunkSize
+	if chunkSize > 0 {
+		if perProc := (len(cells) + runtime.GOMAXPROCS(0) - 1) / runtime.GOMAXPROCS(0); perProc > chunkSize {
+			chunkSize = perProc
+		}
+	}
+
+	if chunkSize <= 0 || len(cells) <= chunkSize {
+		for _, cell := range cells {
+			cellScore, err := score(cell)
+			scores.add(cell, cellScore, err)
+		}
+		return
+	}
+
+	chunks := make([]cellScores, (len(cells)+chunkSize-1)/chunkSize)
+	for i := range chunks {
+		chunks[i] = scores.part()
+	}
+	wg := &sync.WaitGroup{}
+	wg.Add(len(chunks))
+	for i := range chunks {
+		start := i * chunkSize
+		end := start + chunkSize
+		if end > len(cells) {
+			end = len(cells)
+		}
+
+		chunk := &chunks[i]
+		chunkCells := cells[start:end]
+		s.workPool.Submit(func() {
+			defer wg.Done()
+			for _, cell := range chunkCells {
+				cellScore, err := score(cell)
+				chunk.add(cell, cellScore, err)
+			}
+		})
+	}
+	wg.Wait()
+
+	for i := range chunks {
+		scores.merge(&chunks[i])
+	}
 }
 
 // removeNonApplicableProblems modifies the 'problems' map to remove any problems that didn't show up on err.
@@ -425,6 +1031,64 @@ func removeNonApplicableProblems(problems map[string]struct{}, err error) {
 	}
 }
 
+// trackResources has the cell track the pids and extended resources the
+// scheduler limits, overcommits it, keeps its headroom, gives it the overhead
+// of its containers and counts its tasks by grouping key.
+func (s *Scheduler) trackResources(cell *Cell) {
+	if s.overcommit != nil {
+		cell.applyOvercommit(s.overcommit.ratiosFor(&cell.state))
+	}
+	if s.headroom != nil {
+		cell.headroom = s.headroom.amount(cell.reportedResources())
+		cell.urgentLRP = s.urgentLRP
+		cell.urgentTask = s.urgentTask
+	}
+	if s.overhead != nil {
+		cell.overhead = s.overhead(cell.state)
+	}
+	if s.taskGroupingKey != nil {
+		cell.trackTaskGroups(s.taskGroupingKey)
+	}
+	if s.pidCapacity != nil {
+		cell.trackPids(s.pidCapacity(cell.state))
+	}
+	if s.extendedRequests != nil && s.extendedCapacity != nil {
+		cell.trackExtendedResources(s.extendedCapacity(cell.state), s.extendedRequests)
+	}
+}
+
+// extraProblems are the problems beyond disk, memory and containers that work
+// with the placement constraint may run into: pids when the cells track them,
+// and the names of the extended resources it asks for.
+func (s *Scheduler) extraProblems(pc *rep.PlacementConstraint) []string {
+	var problems []string
+	if s.pidCapacity != nil {
+		problems = append(problems, "pids")
+	}
+	if s.extendedRequests == nil || s.extendedCapacity == nil {
+		return problems
+	}
+
+	for name, amount := range s.extendedRequests(*pc) {
+		if amount > 0 {
+			problems = append(problems, name)
+		}
+	}
+	return problems
+}
+
+// inflightContainerStarts counts the containers the cells are starting,
+// including the ones reserved on them so far.
+func (s *Scheduler) inflightContainerStarts() int {
+	var starts int
+	for _, zone := range s.zones {
+		for _, cell := range zone {
+			starts += cell.StartingContainerCount()
+		}
+	}
+	return starts
+}
+
 func (s *Scheduler) exceededInflightContainerCreation(currentInflight int) bool {
 	return s.startingContainerCountMaximum > 0 && currentInflight >= s.startingContainerCountMaximum
 }
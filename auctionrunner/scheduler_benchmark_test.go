@@ -0,0 +1,194 @@
+package auctionrunner_test
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"code.cloudfoundry.org/auction/auctionrunner"
+	"code.cloudfoundry.org/auction/auctiontypes"
+	"code.cloudfoundry.org/bbs/models"
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/rep"
+	"code.cloudfoundry.org/workpool"
+	. "github.com/onsi/gomega"
+)
+
+var benchmarkCells = flag.Int("benchmarkCells", 0, "only run the scheduler benchmarks with this many cells")
+
+/*
+scheduleScenario describes a synthetic fleet and the batch of work auctioned
+on it.  The fleet and the work are the same on every run, so results of the
+benchmarks built from scenarios can be compared across revisions with
+benchstat.
+*/
+type scheduleScenario struct {
+	cells       int
+	zones       int
+	lrpsPerCell int // instances already running on every cell
+	lrps        int
+	tasks       int
+}
+
+func (sc scheduleScenario) String() string {
+	name := fmt.Sprintf("cells=%d/zones=%d/lrps=%d/tasks=%d", sc.cells, sc.zones, sc.lrps, sc.tasks)
+	if sc.lrpsPerCell > 0 {
+		name += fmt.Sprintf("/running=%d", sc.lrpsPerCell)
+	}
+	return name
+}
+
+// buildZones builds cells of a handful of sizes spread over the zones.  Every
+// cell runs lrpsPerCell instances of a hundred processes.
+func (sc scheduleScenario) buildZones(logger lager.Logger) map[string]auctionrunner.Zone {
+	zones := map[string]auctionrunner.Zone{}
+	for i := 0; i < sc.cells; i++ {
+		zone := fmt.Sprintf("zone-%d", i%sc.zones)
+		cellID := fmt.Sprintf("cell-%d", i)
+
+		lrps := make([]rep.LRP, 0, sc.lrpsPerCell)
+		for j := 0; j < sc.lrpsPerCell; j++ {
+			lrps = append(lrps, *BuildLRP(fmt.Sprintf("pg-%d", (i+j)%100), "domain", i*sc.lrpsPerCell+j, "", 1, 1, 1, []string{}))
+		}
+
+		memoryMB := int32(1024 * (4 + i%4))
+		state := BuildCellState(cellID, i, zone, memoryMB, memoryMB, 250, false, 0, linuxOnlyRootFSProviders, lrps, []string{}, []string{}, []string{}, 0)
+		zones[zone] = append(zones[zone], auctionrunner.NewCell(logger, cellID, benchmarkClient{}, state))
+	}
+	return zones
+}
+
+// buildRequest builds LRP instances of ten processes and tasks of varying
+// sizes.
+func (sc scheduleScenario) buildRequest(queueTime time.Time) auctiontypes.AuctionRequest {
+	request := auctiontypes.AuctionRequest{}
+	for i := 0; i < sc.lrps; i++ {
+		size := int32(64 * (1 + i%8))
+		request.LRPs = append(request.LRPs, BuildLRPAuction(fmt.Sprintf("pg-%d", i%10), "domain", 10000+i/10, linuxRootFSURL, size, size, 10, queueTime, nil, []string{}))
+	}
+	for i := 0; i < sc.tasks; i++ {
+		size := int32(32 * (1 + i%4))
+		request.Tasks = append(request.Tasks, BuildTaskAuction(BuildTask(fmt.Sprintf("tg-%d", i), "domain", linuxRootFSURL, size, size, 10, []string{}, []string{}), queueTime))
+	}
+	return request
+}
+
+func (sc scheduleScenario) skipped() bool {
+	return *benchmarkCells > 0 && sc.cells != *benchmarkCells
+}
+
+// runScheduleBenchmark schedules the work of the scenario on a fresh fleet in
+// every iteration.  Besides time and allocations per Schedule it reports the
+// time per auction and the share of auctions that were placed.
+func runScheduleBenchmark(b *testing.B, sc scheduleScenario, options ...auctionrunner.SchedulerOption) {
+	RegisterTestingT(b)
+
+	logger := lager.NewLogger("benchmark")
+	clock := fakeclock.NewFakeClock(time.Now())
+	workPool, err := workpool.NewWorkPool(50)
+	Expect(err).NotTo(HaveOccurred())
+	defer workPool.Stop()
+
+	auctions := sc.lrps + sc.tasks
+	placed := 0
+	var scheduling time.Duration
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		zones := sc.buildZones(logger)
+		request := sc.buildRequest(clock.Now())
+		scheduler := auctionrunner.NewScheduler(workPool, zones, clock, logger, 0.25, 0.25, 0, options...)
+		b.StartTimer()
+
+		start := time.Now()
+		results := scheduler.Schedule(request)
+		scheduling += time.Since(start)
+
+		placed += len(results.SuccessfulLRPs) + len(results.SuccessfulTasks)
+	}
+
+	if auctions > 0 {
+		b.ReportMetric(float64(scheduling.Nanoseconds())/float64(b.N*auctions), "ns/auction")
+		b.ReportMetric(float64(placed)/float64(b.N*auctions), "placed/auction")
+	}
+}
+
+func BenchmarkSchedule(b *testing.B) {
+	for _, cells := range []int{100, 1000, 5000} {
+		for _, mix := range []struct{ lrps, tasks int }{{500, 0}, {0, 500}, {250, 250}} {
+			sc := scheduleScenario{cells: cells, zones: 3, lrps: mix.lrps, tasks: mix.tasks}
+			if sc.skipped() {
+				continue
+			}
+			b.Run(sc.String(), func(b *testing.B) {
+				runScheduleBenchmark(b, sc)
+			})
+		}
+	}
+}
+
+func BenchmarkScheduleParallelScoring(b *testing.B) {
+	for _, cells := range []int{1000, 5000} {
+		sc := scheduleScenario{cells: cells, zones: 3, lrps: 200, tasks: 200}
+		if sc.skipped() {
+			continue
+		}
+		b.Run(sc.String()+"/serial", func(b *testing.B) {
+			runScheduleBenchmark(b, sc)
+		})
+		b.Run(sc.String()+"/parallel-chunk=256", func(b *testing.B) {
+			runScheduleBenchmark(b, sc, auctionrunner.WithParallelScoring(256))
+		})
+	}
+}
+
+// BenchmarkScheduleBatchOptimizer schedules batches that fill the fleet, where
+// planning the whole batch has the most to gain.
+func BenchmarkScheduleBatchOptimizer(b *testing.B) {
+	for _, cells := range []int{100, 1000} {
+		sc := scheduleScenario{cells: cells, zones: 3, lrps: cells * 18, tasks: cells * 6}
+		if sc.skipped() {
+			continue
+		}
+		b.Run(sc.String()+"/greedy", func(b *testing.B) {
+			runScheduleBenchmark(b, sc)
+		})
+		b.Run(sc.String()+"/optimizer", func(b *testing.B) {
+			runScheduleBenchmark(b, sc, auctionrunner.WithBatchOptimizer(time.Minute, 2))
+		})
+	}
+}
+
+// BenchmarkScheduleLocality places instances of processes that already run
+// on busy cells, which makes counting the instances of each process dominate.
+func BenchmarkScheduleLocality(b *testing.B) {
+	for _, cells := range []int{1000, 5000, 10000} {
+		sc := scheduleScenario{cells: cells, zones: 3, lrpsPerCell: 50, lrps: 20}
+		if sc.skipped() {
+			continue
+		}
+		b.Run(sc.String(), func(b *testing.B) {
+			runScheduleBenchmark(b, sc)
+		})
+	}
+}
+
+// benchmarkClient is a cell client that accepts all work without recording
+// it, so that it does not add to the allocations being measured.
+type benchmarkClient struct{}
+
+func (benchmarkClient) State(lager.Logger) (rep.CellState, error) { return rep.CellState{}, nil }
+func (benchmarkClient) Perform(lager.Logger, rep.Work) (rep.Work, error) {
+	return rep.Work{}, nil
+}
+func (benchmarkClient) StopLRPInstance(lager.Logger, models.ActualLRPKey, models.ActualLRPInstanceKey) error {
+	return nil
+}
+func (benchmarkClient) CancelTask(lager.Logger, string) error { return nil }
+func (benchmarkClient) SetStateClient(*http.Client)           {}
+func (benchmarkClient) StateClientTimeout() time.Duration     { return 0 }
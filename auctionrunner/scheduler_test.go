@@ -1,6 +1,8 @@
 package auctionrunner_test
 
 import (
+	"fmt"
+	"math/rand"
 	"time"
 
 	"code.cloudfoundry.org/clock/fakeclock"
@@ -9,6 +11,7 @@ import (
 
 	"code.cloudfoundry.org/auction/auctionrunner"
 	"code.cloudfoundry.org/auction/auctiontypes"
+	"code.cloudfoundry.org/auction/auctiontypes/fakes"
 	"code.cloudfoundry.org/rep"
 	"code.cloudfoundry.org/rep/repfakes"
 
@@ -1148,6 +1151,1090 @@ var _ = Describe("Scheduler", func() {
 			})
 		})
 	})
+
+	Describe("handling cells whose state moved on since it was fetched", func() {
+		var (
+			versionedClient      *fakes.FakeVersionedCellClient
+			startAuction         auctiontypes.LRPAuction
+			maxInflightContainer int
+		)
+
+		BeforeEach(func() {
+			maxInflightContainer = 0
+			versionedClient = &fakes.FakeVersionedCellClient{}
+			versionedClient.PerformVersionedReturnsOnCall(0, rep.Work{}, 5, auctiontypes.ErrorStaleCellState)
+			versionedClient.VersionedStateReturns(BuildCellState("A-cell", 0, "the-zone", 100, 100, 100, false, 0, linuxOnlyRootFSProviders, nil, []string{}, []string{}, []string{}, 0), 5, nil)
+
+			clients["B-cell"] = &repfakes.FakeSimClient{}
+			zones["the-zone"] = auctionrunner.Zone{
+				auctionrunner.NewCell(logger, "A-cell", versionedClient, BuildCellState("A-cell", 0, "the-zone", 100, 100, 100, false, 0, linuxOnlyRootFSProviders, nil, []string{}, []string{}, []string{}, 0)),
+				auctionrunner.NewCell(logger, "B-cell", clients["B-cell"], BuildCellState("B-cell", 1, "the-zone", 100, 100, 100, false, 0, linuxOnlyRootFSProviders, []rep.LRP{
+					*BuildLRP("pg-1", "domain", 0, "", 50, 50, 10, []string{}),
+				}, []string{}, []string{}, []string{}, 0)),
+			}
+
+			startAuction = BuildLRPAuction("pg-2", "domain", 0, linuxRootFSURL, 40, 40, 10, clock.Now(), nil, []string{})
+		})
+
+		JustBeforeEach(func() {
+			s := auctionrunner.NewScheduler(workPool, zones, clock, logger, 0.0, 0.0, maxInflightContainer)
+			results = s.Schedule(auctiontypes.AuctionRequest{LRPs: []auctiontypes.LRPAuction{startAuction}})
+		})
+
+		It("places the rejected work again using the current state of the cell", func() {
+			Expect(versionedClient.VersionedStateCallCount()).To(Equal(1))
+			Expect(versionedClient.PerformVersionedCallCount()).To(Equal(2))
+			_, _, version := versionedClient.PerformVersionedArgsForCall(1)
+			Expect(version).To(BeEquivalentTo(5))
+
+			Expect(results.FailedLRPs).To(BeEmpty())
+			Expect(results.SuccessfulLRPs).To(HaveLen(1))
+			Expect(results.SuccessfulLRPs[0].Winner).To(Equal("A-cell"))
+			Expect(results.SuccessfulLRPs[0].Attempts).To(Equal(1))
+		})
+
+		Context("when the cell no longer has room", func() {
+			BeforeEach(func() {
+				versionedClient.VersionedStateReturns(BuildCellState("A-cell", 0, "the-zone", 100, 100, 100, false, 0, linuxOnlyRootFSProviders, []rep.LRP{
+					*BuildLRP("pg-3", "domain", 0, "", 90, 90, 10, []string{}),
+				}, []string{}, []string{}, []string{}, 0), 5, nil)
+			})
+
+			It("re-scores the work across the other cells", func() {
+				Expect(versionedClient.PerformVersionedCallCount()).To(Equal(1))
+				Expect(clients["B-cell"].PerformCallCount()).To(Equal(1))
+
+				Expect(results.FailedLRPs).To(BeEmpty())
+				Expect(results.SuccessfulLRPs).To(HaveLen(1))
+				Expect(results.SuccessfulLRPs[0].Winner).To(Equal("B-cell"))
+			})
+		})
+
+		Context("when the cell has since started as many containers as may be in flight", func() {
+			BeforeEach(func() {
+				maxInflightContainer = 2
+				versionedClient.VersionedStateReturns(BuildCellState("A-cell", 0, "the-zone", 100, 100, 100, false, 2, linuxOnlyRootFSProviders, nil, []string{}, []string{}, []string{}, 0), 5, nil)
+			})
+
+			It("does not place the rejected work again", func() {
+				Expect(versionedClient.PerformVersionedCallCount()).To(Equal(1))
+				Expect(clients["B-cell"].PerformCallCount()).To(Equal(0))
+
+				Expect(results.SuccessfulLRPs).To(BeEmpty())
+				Expect(results.FailedLRPs).To(HaveLen(1))
+				Expect(results.FailedLRPs[0].PlacementError).To(Equal(auctiontypes.ErrorExceededInflightCreation.Error()))
+			})
+		})
+
+		Context("when the cell keeps moving on", func() {
+			BeforeEach(func() {
+				versionedClient.PerformVersionedReturns(rep.Work{}, 5, auctiontypes.ErrorStaleCellState)
+			})
+
+			It("eventually fails the work", func() {
+				Expect(versionedClient.PerformVersionedCallCount()).To(Equal(3))
+
+				Expect(results.SuccessfulLRPs).To(BeEmpty())
+				Expect(results.FailedLRPs).To(HaveLen(1))
+				Expect(results.FailedLRPs[0].PlacementError).To(Equal(auctiontypes.ErrorStaleCellState.Error()))
+				Expect(results.FailedLRPs[0].Attempts).To(Equal(1))
+			})
+		})
+	})
+
+	Describe("scoring cells in parallel", func() {
+		winners := func(results auctiontypes.AuctionResults) map[string]string {
+			placements := map[string]string{}
+			for _, lrp := range results.SuccessfulLRPs {
+				placements[lrp.Identifier()] = lrp.Winner
+			}
+			for _, task := range results.SuccessfulTasks {
+				placements[task.Identifier()] = task.Winner
+			}
+			for _, lrp := range results.FailedLRPs {
+				placements[lrp.Identifier()] = lrp.PlacementError
+			}
+			for _, task := range results.FailedTasks {
+				placements[task.Identifier()] = task.PlacementError
+			}
+			return placements
+		}
+
+		It("places the work exactly where serial scoring does", func() {
+			// chunks this large score every zone serially, in the same zone order
+			serial := auctionrunner.NewScheduler(workPool, BuildFleet(logger, 40, 3), clock, logger, 0.25, 0.25, 0, auctionrunner.WithParallelScoring(1000))
+			serialResults := serial.Schedule(BuildFleetAuctions(300, 300, clock.Now()))
+
+			parallel := auctionrunner.NewScheduler(workPool, BuildFleet(logger, 40, 3), clock, logger, 0.25, 0.25, 0, auctionrunner.WithParallelScoring(1))
+			parallelResults := parallel.Schedule(BuildFleetAuctions(300, 300, clock.Now()))
+
+			Expect(serialResults.FailedLRPs).NotTo(BeEmpty())
+			Expect(serialResults.FailedTasks).NotTo(BeEmpty())
+			Expect(winners(parallelResults)).To(Equal(winners(serialResults)))
+		})
+
+		It("reports the problems every cell had", func() {
+			zones["the-zone"] = auctionrunner.Zone{
+				auctionrunner.NewCell(logger, "A-cell", &repfakes.FakeSimClient{}, BuildCellState("A-cell", 0, "the-zone", 10, 100, 100, false, 0, linuxOnlyRootFSProviders, nil, []string{}, []string{}, []string{}, 0)),
+				auctionrunner.NewCell(logger, "B-cell", &repfakes.FakeSimClient{}, BuildCellState("B-cell", 1, "the-zone", 10, 10, 100, false, 0, linuxOnlyRootFSProviders, nil, []string{}, []string{}, []string{}, 0)),
+			}
+			s := auctionrunner.NewScheduler(workPool, zones, clock, logger, 0.0, 0.0, 0, auctionrunner.WithParallelScoring(1))
+			results = s.Schedule(auctiontypes.AuctionRequest{
+				LRPs: []auctiontypes.LRPAuction{BuildLRPAuction("pg-1", "domain", 0, linuxRootFSURL, 50, 50, 10, clock.Now(), nil, []string{})},
+			})
+
+			Expect(results.FailedLRPs).To(HaveLen(1))
+			Expect(results.FailedLRPs[0].PlacementError).To(Equal("insufficient resources: memory"))
+		})
+	})
+
+	Describe("filtering cells once per placement constraint", func() {
+		BeforeEach(func() {
+			zones["the-zone"] = auctionrunner.Zone{
+				auctionrunner.NewCell(logger, "A-cell", &repfakes.FakeSimClient{}, BuildCellState("A-cell", 0, "the-zone", 100, 100, 1, false, 0, linuxOnlyRootFSProviders, nil, []string{}, []string{"a", "b"}, []string{}, 0)),
+				auctionrunner.NewCell(logger, "B-cell", &repfakes.FakeSimClient{}, BuildCellState("B-cell", 1, "the-zone", 100, 100, 1, false, 0, linuxOnlyRootFSProviders, nil, []string{}, []string{"b", "a"}, []string{}, 0)),
+			}
+		})
+
+		It("stops offering cells once they run out of containers", func() {
+			s := auctionrunner.NewScheduler(workPool, zones, clock, logger, 0.0, 0.0, 0)
+			results = s.Schedule(auctiontypes.AuctionRequest{
+				Tasks: []auctiontypes.TaskAuction{
+					BuildTaskAuction(BuildTask("tg-1", "domain", linuxRootFSURL, 10, 10, 10, []string{}, []string{"a", "b"}), clock.Now()),
+					BuildTaskAuction(BuildTask("tg-2", "domain", linuxRootFSURL, 10, 10, 10, []string{}, []string{"b", "a"}), clock.Now()),
+					BuildTaskAuction(BuildTask("tg-3", "domain", linuxRootFSURL, 10, 10, 10, []string{}, []string{"a", "b", "a"}), clock.Now()),
+				},
+			})
+
+			Expect(results.SuccessfulTasks).To(HaveLen(2))
+			Expect([]string{results.SuccessfulTasks[0].Winner, results.SuccessfulTasks[1].Winner}).To(ConsistOf("A-cell", "B-cell"))
+
+			Expect(results.FailedTasks).To(HaveLen(1))
+			Expect(results.FailedTasks[0].TaskGuid).To(Equal("tg-3"))
+			Expect(results.FailedTasks[0].PlacementError).To(Equal("insufficient resources: containers"))
+		})
+	})
+
+	Describe("scoring a sample of the cells", func() {
+		sampledScheduler := func(zones map[string]auctionrunner.Zone, seed int64) *auctionrunner.Scheduler {
+			return auctionrunner.NewScheduler(workPool, zones, clock, logger, 0.25, 0.25, 0, auctionrunner.WithSampledScoring(2, rand.New(rand.NewSource(seed))))
+		}
+
+		winners := func(results auctiontypes.AuctionResults) map[string]string {
+			placements := map[string]string{}
+			for _, lrp := range results.SuccessfulLRPs {
+				placements[lrp.Identifier()] = lrp.Winner
+			}
+			for _, task := range results.SuccessfulTasks {
+				placements[task.Identifier()] = task.Winner
+			}
+			return placements
+		}
+
+		It("places the work the same way for the same seed", func() {
+			first := sampledScheduler(BuildFleet(logger, 40, 3), 42).Schedule(BuildFleetAuctions(100, 100, clock.Now()))
+			second := sampledScheduler(BuildFleet(logger, 40, 3), 42).Schedule(BuildFleetAuctions(100, 100, clock.Now()))
+
+			Expect(first.SuccessfulLRPs).NotTo(BeEmpty())
+			Expect(winners(second)).To(Equal(winners(first)))
+		})
+
+		It("scores the rest of the zone when no sampled cell has room", func() {
+			zone := auctionrunner.Zone{}
+			for i := 0; i < 10; i++ {
+				guid := fmt.Sprintf("cell-%d", i)
+				memoryMB := int32(10)
+				if i == 7 {
+					memoryMB = 100
+				}
+				zone = append(zone, auctionrunner.NewCell(logger, guid, &repfakes.FakeSimClient{}, BuildCellState(guid, i, "the-zone", memoryMB, 100, 100, false, 0, linuxOnlyRootFSProviders, nil, []string{}, []string{}, []string{}, 0)))
+			}
+
+			results = sampledScheduler(map[string]auctionrunner.Zone{"the-zone": zone}, 1).Schedule(auctiontypes.AuctionRequest{
+				LRPs:  []auctiontypes.LRPAuction{BuildLRPAuction("pg-1", "domain", 0, linuxRootFSURL, 50, 10, 10, clock.Now(), nil, []string{})},
+				Tasks: []auctiontypes.TaskAuction{BuildTaskAuction(BuildTask("tg-1", "domain", linuxRootFSURL, 20, 10, 10, []string{}, []string{}), clock.Now())},
+			})
+
+			Expect(results.SuccessfulLRPs).To(HaveLen(1))
+			Expect(results.SuccessfulLRPs[0].Winner).To(Equal("cell-7"))
+			Expect(results.SuccessfulTasks).To(HaveLen(1))
+			Expect(results.SuccessfulTasks[0].Winner).To(Equal("cell-7"))
+		})
+
+		It("still balances the instances of a process across zones", func() {
+			zones := map[string]auctionrunner.Zone{}
+			for i := 0; i < 10; i++ {
+				zoneName := fmt.Sprintf("zone-%d", i%2)
+				guid := fmt.Sprintf("cell-%d", i)
+				lrps := []rep.LRP{}
+				if i%2 == 0 {
+					lrps = append(lrps, *BuildLRP("pg-1", "domain", i, "", 10, 10, 10, []string{}))
+				}
+				zones[zoneName] = append(zones[zoneName], auctionrunner.NewCell(logger, guid, &repfakes.FakeSimClient{}, BuildCellState(guid, i, zoneName, 100, 100, 100, false, 0, linuxOnlyRootFSProviders, lrps, []string{}, []string{}, []string{}, 0)))
+			}
+
+			for seed := int64(0); seed < 5; seed++ {
+				results = sampledScheduler(zones, seed).Schedule(auctiontypes.AuctionRequest{
+					LRPs: []auctiontypes.LRPAuction{BuildLRPAuction("pg-1", "domain", 100+int(seed), linuxRootFSURL, 1, 1, 1, clock.Now(), nil, []string{})},
+				})
+				Expect(results.SuccessfulLRPs).To(HaveLen(1))
+				Expect(zones["zone-1"]).To(ContainElement(WithTransform(func(cell *auctionrunner.Cell) string { return cell.Guid }, Equal(results.SuccessfulLRPs[0].Winner))))
+			}
+		})
+	})
+
+	Describe("weighing the resources of the cells", func() {
+		var weighedZones map[string]auctionrunner.Zone
+		var lrpAuction auctiontypes.LRPAuction
+
+		BeforeEach(func() {
+			diskHeavyLRPs := []rep.LRP{*BuildLRP("pg-disk", "domain", 0, "", 30, 60, 10, []string{})}
+			memoryHeavyLRPs := []rep.LRP{*BuildLRP("pg-memory", "domain", 0, "", 60, 3, 10, []string{})}
+			weighedZones = map[string]auctionrunner.Zone{
+				"the-zone": auctionrunner.Zone{
+					auctionrunner.NewCell(logger, "disk-heavy-cell", &repfakes.FakeSimClient{}, BuildCellState("disk-heavy-cell", 0, "the-zone", 100, 100, 100, false, 0, linuxOnlyRootFSProviders, diskHeavyLRPs, []string{}, []string{}, []string{}, 0)),
+					auctionrunner.NewCell(logger, "memory-heavy-cell", &repfakes.FakeSimClient{}, BuildCellState("memory-heavy-cell", 1, "the-zone", 100, 100, 100, false, 0, linuxOnlyRootFSProviders, memoryHeavyLRPs, []string{}, []string{}, []string{}, 0)),
+				},
+			}
+			lrpAuction = BuildLRPAuction("pg-1", "domain", 0, linuxRootFSURL, 10, 1, 10, clock.Now(), nil, []string{})
+		})
+
+		It("weighs every resource the same by default", func() {
+			results = auctionrunner.NewScheduler(workPool, weighedZones, clock, logger, 0.0, 0.0, 0).Schedule(auctiontypes.AuctionRequest{LRPs: []auctiontypes.LRPAuction{lrpAuction}})
+
+			Expect(results.SuccessfulLRPs).To(HaveLen(1))
+			Expect(results.SuccessfulLRPs[0].Winner).To(Equal("memory-heavy-cell"))
+		})
+
+		It("scores the cells by the weighted resources", func() {
+			weights := auctionrunner.ResourceWeights{MemoryMB: 1}
+			results = auctionrunner.NewScheduler(workPool, weighedZones, clock, logger, 0.0, 0.0, 0, auctionrunner.WithResourceWeights(weights)).Schedule(auctiontypes.AuctionRequest{LRPs: []auctiontypes.LRPAuction{lrpAuction}})
+
+			Expect(results.SuccessfulLRPs).To(HaveLen(1))
+			Expect(results.SuccessfulLRPs[0].Winner).To(Equal("disk-heavy-cell"))
+		})
+
+		It("scores tasks by the weighted resources", func() {
+			weights := auctionrunner.ResourceWeights{MemoryMB: 1}
+			taskAuction := BuildTaskAuction(BuildTask("tg-1", "domain", linuxRootFSURL, 10, 1, 10, []string{}, []string{}), clock.Now())
+			results = auctionrunner.NewScheduler(workPool, weighedZones, clock, logger, 0.0, 0.0, 0, auctionrunner.WithResourceWeights(weights)).Schedule(auctiontypes.AuctionRequest{Tasks: []auctiontypes.TaskAuction{taskAuction}})
+
+			Expect(results.SuccessfulTasks).To(HaveLen(1))
+			Expect(results.SuccessfulTasks[0].Winner).To(Equal("disk-heavy-cell"))
+		})
+
+		It("ignores weights that add up to zero", func() {
+			results = auctionrunner.NewScheduler(workPool, weighedZones, clock, logger, 0.0, 0.0, 0, auctionrunner.WithResourceWeights(auctionrunner.ResourceWeights{})).Schedule(auctiontypes.AuctionRequest{LRPs: []auctiontypes.LRPAuction{lrpAuction}})
+
+			Expect(results.SuccessfulLRPs).To(HaveLen(1))
+			Expect(results.SuccessfulLRPs[0].Winner).To(Equal("memory-heavy-cell"))
+			Expect(logger.LogMessages()).To(ContainElement("fakelogger.invalid-resource-weights"))
+		})
+
+		It("ignores negative weights", func() {
+			weights := auctionrunner.ResourceWeights{MemoryMB: 1, Extended: map[string]float64{"gpu": -1}}
+			Expect(weights.Validate()).To(Equal(auctionrunner.ErrorNegativeResourceWeight))
+
+			results = auctionrunner.NewScheduler(workPool, weighedZones, clock, logger, 0.0, 0.0, 0, auctionrunner.WithResourceWeights(weights)).Schedule(auctiontypes.AuctionRequest{LRPs: []auctiontypes.LRPAuction{lrpAuction}})
+
+			Expect(results.SuccessfulLRPs).To(HaveLen(1))
+			Expect(results.SuccessfulLRPs[0].Winner).To(Equal("memory-heavy-cell"))
+			Expect(logger.LogMessages()).To(ContainElement("fakelogger.invalid-resource-weights"))
+		})
+	})
+
+	Describe("limiting the pids of the cells", func() {
+		var pidZones func(lrps ...rep.LRP) map[string]auctionrunner.Zone
+		var pidCapacity func(state rep.CellState) int32
+
+		BeforeEach(func() {
+			// cell-0 runs the given LRPs, and cell-1 an LRP that takes most of
+			// its memory but no pids
+			pidZones = func(lrps ...rep.LRP) map[string]auctionrunner.Zone {
+				busy := []rep.LRP{*BuildLRP("pg-busy", "domain", 0, "", 60, 10, 0, []string{})}
+				return map[string]auctionrunner.Zone{
+					"the-zone": auctionrunner.Zone{
+						auctionrunner.NewCell(logger, "cell-0", &repfakes.FakeSimClient{}, BuildCellState("cell-0", 0, "the-zone", 100, 100, 100, false, 0, linuxOnlyRootFSProviders, lrps, []string{}, []string{}, []string{}, 0)),
+						auctionrunner.NewCell(logger, "cell-1", &repfakes.FakeSimClient{}, BuildCellState("cell-1", 1, "the-zone", 100, 100, 100, false, 0, linuxOnlyRootFSProviders, busy, []string{}, []string{}, []string{}, 0)),
+					},
+				}
+			}
+			pidCapacity = func(state rep.CellState) int32 { return 100 }
+		})
+
+		It("places work on cells with enough pids left", func() {
+			running := *BuildLRP("pg-running", "domain", 0, "", 10, 10, 90, []string{})
+			pidScheduler := auctionrunner.NewScheduler(workPool, pidZones(running), clock, logger, 0.0, 0.0, 0, auctionrunner.WithPidCapacity(pidCapacity))
+			results = pidScheduler.Schedule(auctiontypes.AuctionRequest{
+				LRPs: []auctiontypes.LRPAuction{BuildLRPAuction("pg-1", "domain", 0, linuxRootFSURL, 10, 10, 20, clock.Now(), nil, []string{})},
+			})
+
+			Expect(results.SuccessfulLRPs).To(HaveLen(1))
+			Expect(results.SuccessfulLRPs[0].Winner).To(Equal("cell-1"))
+		})
+
+		It("counts the pids of the work placed in the same auction", func() {
+			pidScheduler := auctionrunner.NewScheduler(workPool, pidZones(), clock, logger, 0.0, 0.0, 0, auctionrunner.WithPidCapacity(pidCapacity))
+			results = pidScheduler.Schedule(auctiontypes.AuctionRequest{
+				Tasks: []auctiontypes.TaskAuction{
+					BuildTaskAuction(BuildTask("tg-1", "domain", linuxRootFSURL, 10, 10, 60, []string{}, []string{}), clock.Now()),
+					BuildTaskAuction(BuildTask("tg-2", "domain", linuxRootFSURL, 10, 10, 60, []string{}, []string{}), clock.Now()),
+					BuildTaskAuction(BuildTask("tg-3", "domain", linuxRootFSURL, 10, 10, 60, []string{}, []string{}), clock.Now()),
+				},
+			})
+
+			Expect(results.SuccessfulTasks).To(HaveLen(2))
+			Expect(results.FailedTasks).To(HaveLen(1))
+			Expect(results.FailedTasks[0].PlacementError).To(Equal("insufficient resources: pids"))
+		})
+
+		It("reports the pids along with the other problems every cell has", func() {
+			pidScheduler := auctionrunner.NewScheduler(workPool, pidZones(), clock, logger, 0.0, 0.0, 0, auctionrunner.WithPidCapacity(pidCapacity))
+			results = pidScheduler.Schedule(auctiontypes.AuctionRequest{
+				LRPs: []auctiontypes.LRPAuction{BuildLRPAuction("pg-1", "domain", 0, linuxRootFSURL, 200, 10, 200, clock.Now(), nil, []string{})},
+			})
+
+			Expect(results.FailedLRPs).To(HaveLen(1))
+			Expect(results.FailedLRPs[0].PlacementError).To(Equal("insufficient resources: memory, pids"))
+		})
+
+		It("does not limit the pids of cells without a capacity", func() {
+			pidScheduler := auctionrunner.NewScheduler(workPool, pidZones(), clock, logger, 0.0, 0.0, 0, auctionrunner.WithPidCapacity(func(state rep.CellState) int32 {
+				if state.CellID == "cell-1" {
+					return 0
+				}
+				return 100
+			}))
+			results = pidScheduler.Schedule(auctiontypes.AuctionRequest{
+				LRPs: []auctiontypes.LRPAuction{BuildLRPAuction("pg-1", "domain", 0, linuxRootFSURL, 10, 10, 200, clock.Now(), nil, []string{})},
+			})
+
+			Expect(results.SuccessfulLRPs).To(HaveLen(1))
+			Expect(results.SuccessfulLRPs[0].Winner).To(Equal("cell-1"))
+		})
+
+		It("keeps the batch optimizer within the pids of the cells", func() {
+			pidScheduler := auctionrunner.NewScheduler(workPool, pidZones(), clock, logger, 0.0, 0.0, 0, auctionrunner.WithPidCapacity(pidCapacity), auctionrunner.WithBatchOptimizer(time.Second, 2))
+			results = pidScheduler.Schedule(auctiontypes.AuctionRequest{
+				Tasks: []auctiontypes.TaskAuction{
+					BuildTaskAuction(BuildTask("tg-1", "domain", linuxRootFSURL, 10, 10, 60, []string{}, []string{}), clock.Now()),
+					BuildTaskAuction(BuildTask("tg-2", "domain", linuxRootFSURL, 10, 10, 60, []string{}, []string{}), clock.Now()),
+					BuildTaskAuction(BuildTask("tg-3", "domain", linuxRootFSURL, 10, 10, 60, []string{}, []string{}), clock.Now()),
+				},
+			})
+
+			Expect(results.SuccessfulTasks).To(HaveLen(2))
+			Expect(results.FailedTasks).To(HaveLen(1))
+		})
+	})
+
+	Describe("counting the extended resources of the cells", func() {
+		var extendedZones func(lrps ...rep.LRP) map[string]auctionrunner.Zone
+		var extendedCapacity func(capacities map[string]auctiontypes.ExtendedResources) func(state rep.CellState) auctiontypes.ExtendedResources
+		var requests auctiontypes.ExtendedResourceRequests
+
+		BeforeEach(func() {
+			// cell-0 runs the given LRPs, and cell-1 an LRP that takes most of
+			// its memory
+			extendedZones = func(lrps ...rep.LRP) map[string]auctionrunner.Zone {
+				busy := []rep.LRP{*BuildLRP("pg-busy", "domain", 0, "", 60, 10, 0, []string{})}
+				return map[string]auctionrunner.Zone{
+					"the-zone": auctionrunner.Zone{
+						auctionrunner.NewCell(logger, "cell-0", &repfakes.FakeSimClient{}, BuildCellState("cell-0", 0, "the-zone", 100, 100, 100, false, 0, linuxOnlyRootFSProviders, lrps, []string{}, []string{"fpga"}, []string{}, 0)),
+						auctionrunner.NewCell(logger, "cell-1", &repfakes.FakeSimClient{}, BuildCellState("cell-1", 1, "the-zone", 100, 100, 100, false, 0, linuxOnlyRootFSProviders, busy, []string{}, []string{"fpga"}, []string{}, 0)),
+					},
+				}
+			}
+			extendedCapacity = func(capacities map[string]auctiontypes.ExtendedResources) func(state rep.CellState) auctiontypes.ExtendedResources {
+				return func(state rep.CellState) auctiontypes.ExtendedResources {
+					return capacities[state.CellID]
+				}
+			}
+			requests = auctiontypes.ExtendedResourcesByPlacementTag(map[string]auctiontypes.ExtendedResources{
+				"fpga": {"fpga-slots": 1},
+			})
+		})
+
+		It("places work only on cells that have the extended resources it asks for", func() {
+			capacity := extendedCapacity(map[string]auctiontypes.ExtendedResources{"cell-1": {"fpga-slots": 1}})
+			extendedScheduler := auctionrunner.NewScheduler(workPool, extendedZones(), clock, logger, 0.0, 0.0, 0, auctionrunner.WithExtendedResources(capacity, requests))
+			results = extendedScheduler.Schedule(auctiontypes.AuctionRequest{
+				LRPs: []auctiontypes.LRPAuction{BuildLRPAuction("pg-1", "domain", 0, linuxRootFSURL, 10, 10, 10, clock.Now(), nil, []string{"fpga"})},
+			})
+
+			Expect(results.SuccessfulLRPs).To(HaveLen(1))
+			Expect(results.SuccessfulLRPs[0].Winner).To(Equal("cell-1"))
+		})
+
+		It("fails work when no cell has the extended resources it asks for", func() {
+			capacity := extendedCapacity(map[string]auctiontypes.ExtendedResources{"cell-1": {"licenses": 1}})
+			extendedScheduler := auctionrunner.NewScheduler(workPool, extendedZones(), clock, logger, 0.0, 0.0, 0, auctionrunner.WithExtendedResources(capacity, requests))
+			results = extendedScheduler.Schedule(auctiontypes.AuctionRequest{
+				Tasks: []auctiontypes.TaskAuction{BuildTaskAuction(BuildTask("tg-1", "domain", linuxRootFSURL, 10, 10, 10, []string{}, []string{"fpga"}), clock.Now())},
+			})
+
+			Expect(results.FailedTasks).To(HaveLen(1))
+			Expect(results.FailedTasks[0].PlacementError).To(Equal(auctiontypes.ErrorExtendedResourceMismatch.Error()))
+		})
+
+		It("counts the extended resources of running work and of the work placed in the same auction", func() {
+			running := *BuildLRP("pg-running", "domain", 0, "", 10, 10, 10, []string{"fpga"})
+			capacity := extendedCapacity(map[string]auctiontypes.ExtendedResources{"cell-0": {"fpga-slots": 2}, "cell-1": {"fpga-slots": 1}})
+			extendedScheduler := auctionrunner.NewScheduler(workPool, extendedZones(running), clock, logger, 0.0, 0.0, 0, auctionrunner.WithExtendedResources(capacity, requests))
+			results = extendedScheduler.Schedule(auctiontypes.AuctionRequest{
+				Tasks: []auctiontypes.TaskAuction{
+					BuildTaskAuction(BuildTask("tg-1", "domain", linuxRootFSURL, 10, 10, 10, []string{}, []string{"fpga"}), clock.Now()),
+					BuildTaskAuction(BuildTask("tg-2", "domain", linuxRootFSURL, 10, 10, 10, []string{}, []string{"fpga"}), clock.Now()),
+					BuildTaskAuction(BuildTask("tg-3", "domain", linuxRootFSURL, 10, 10, 10, []string{}, []string{"fpga"}), clock.Now()),
+				},
+			})
+
+			Expect(results.SuccessfulTasks).To(HaveLen(2))
+			Expect(results.FailedTasks).To(HaveLen(1))
+			Expect(results.FailedTasks[0].PlacementError).To(Equal("insufficient resources: fpga-slots"))
+		})
+
+		It("favors the cells with the least of the extended resources in use", func() {
+			capacity := extendedCapacity(map[string]auctiontypes.ExtendedResources{"cell-0": {"fpga-slots": 1}, "cell-1": {"fpga-slots": 10}})
+			extendedScheduler := auctionrunner.NewScheduler(workPool, extendedZones(), clock, logger, 0.0, 0.0, 0, auctionrunner.WithExtendedResources(capacity, requests))
+			results = extendedScheduler.Schedule(auctiontypes.AuctionRequest{
+				Tasks: []auctiontypes.TaskAuction{BuildTaskAuction(BuildTask("tg-1", "domain", linuxRootFSURL, 10, 10, 10, []string{}, []string{"fpga"}), clock.Now())},
+			})
+
+			Expect(results.SuccessfulTasks).To(HaveLen(1))
+			Expect(results.SuccessfulTasks[0].Winner).To(Equal("cell-1"))
+		})
+
+		It("keeps the batch optimizer within the extended resources of the cells", func() {
+			capacity := extendedCapacity(map[string]auctiontypes.ExtendedResources{"cell-0": {"fpga-slots": 1}, "cell-1": {"fpga-slots": 1}})
+			extendedScheduler := auctionrunner.NewScheduler(workPool, extendedZones(), clock, logger, 0.0, 0.0, 0, auctionrunner.WithExtendedResources(capacity, requests), auctionrunner.WithBatchOptimizer(time.Second, 2))
+			results = extendedScheduler.Schedule(auctiontypes.AuctionRequest{
+				Tasks: []auctiontypes.TaskAuction{
+					BuildTaskAuction(BuildTask("tg-1", "domain", linuxRootFSURL, 10, 10, 10, []string{}, []string{"fpga"}), clock.Now()),
+					BuildTaskAuction(BuildTask("tg-2", "domain", linuxRootFSURL, 10, 10, 10, []string{}, []string{"fpga"}), clock.Now()),
+					BuildTaskAuction(BuildTask("tg-3", "domain", linuxRootFSURL, 10, 10, 10, []string{}, []string{"fpga"}), clock.Now()),
+				},
+			})
+
+			Expect(results.SuccessfulTasks).To(HaveLen(2))
+			Expect(results.FailedTasks).To(HaveLen(1))
+			Expect(results.FailedTasks[0].PlacementError).To(Equal("insufficient resources: fpga-slots"))
+		})
+	})
+
+	Describe("overcommitting the resources of the cells", func() {
+		var overcommitZones func() map[string]auctionrunner.Zone
+
+		BeforeEach(func() {
+			// cell-0 is in the "dev" pool
+			overcommitZones = func() map[string]auctionrunner.Zone {
+				return map[string]auctionrunner.Zone{
+					"the-zone": auctionrunner.Zone{
+						auctionrunner.NewCell(logger, "cell-0", &repfakes.FakeSimClient{}, BuildCellState("cell-0", 0, "the-zone", 100, 100, 100, false, 0, linuxOnlyRootFSProviders, nil, []string{}, []string{"dev"}, []string{}, 0)),
+						auctionrunner.NewCell(logger, "cell-1", &repfakes.FakeSimClient{}, BuildCellState("cell-1", 1, "the-zone", 100, 100, 100, false, 0, linuxOnlyRootFSProviders, nil, []string{}, []string{}, []string{}, 0)),
+					},
+				}
+			}
+		})
+
+		It("places work that only fits the overcommitted resources, and marks it", func() {
+			overcommitScheduler := auctionrunner.NewScheduler(workPool, overcommitZones(), clock, logger, 0.0, 0.0, 0, auctionrunner.WithOvercommit(auctionrunner.OvercommitRatios{MemoryMB: 1.5}, nil))
+			results = overcommitScheduler.Schedule(auctiontypes.AuctionRequest{
+				LRPs: []auctiontypes.LRPAuction{
+					BuildLRPAuction("pg-1", "domain", 0, linuxRootFSURL, 120, 10, 10, clock.Now(), nil, []string{}),
+					BuildLRPAuction("pg-2", "domain", 0, linuxRootFSURL, 20, 10, 10, clock.Now(), nil, []string{"dev"}),
+				},
+			})
+
+			Expect(results.SuccessfulLRPs).To(HaveLen(2))
+			winners := map[string]auctiontypes.LRPAuction{}
+			for _, auction := range results.SuccessfulLRPs {
+				winners[auction.ProcessGuid] = auction
+			}
+			Expect(winners["pg-1"].Winner).To(Equal("cell-1"))
+			Expect(winners["pg-1"].Overcommitted).To(BeTrue())
+			Expect(winners["pg-2"].Winner).To(Equal("cell-0"))
+			Expect(winners["pg-2"].Overcommitted).To(BeFalse())
+		})
+
+		It("counts the work placed in the same auction against the overcommitted resources", func() {
+			overcommitScheduler := auctionrunner.NewScheduler(workPool, overcommitZones(), clock, logger, 0.0, 0.0, 0, auctionrunner.WithOvercommit(auctionrunner.OvercommitRatios{MemoryMB: 1.5}, nil))
+			results = overcommitScheduler.Schedule(auctiontypes.AuctionRequest{
+				Tasks: []auctiontypes.TaskAuction{
+					BuildTaskAuction(BuildTask("tg-1", "domain", linuxRootFSURL, 60, 10, 10, []string{}, []string{}), clock.Now()),
+					BuildTaskAuction(BuildTask("tg-2", "domain", linuxRootFSURL, 60, 10, 10, []string{}, []string{}), clock.Now()),
+					BuildTaskAuction(BuildTask("tg-3", "domain", linuxRootFSURL, 60, 10, 10, []string{}, []string{}), clock.Now()),
+				},
+			})
+
+			Expect(results.SuccessfulTasks).To(HaveLen(2))
+			Expect([]bool{results.SuccessfulTasks[0].Overcommitted, results.SuccessfulTasks[1].Overcommitted}).To(ConsistOf(false, true))
+			Expect(results.FailedTasks).To(HaveLen(1))
+			Expect(results.FailedTasks[0].PlacementError).To(Equal("insufficient resources: memory"))
+		})
+
+		It("overcommits the cells of a placement tag pool by the ratios of the pool", func() {
+			pools := map[string]auctionrunner.OvercommitRatios{"dev": {MemoryMB: 2}}
+			overcommitScheduler := auctionrunner.NewScheduler(workPool, overcommitZones(), clock, logger, 0.0, 0.0, 0, auctionrunner.WithOvercommit(auctionrunner.OvercommitRatios{}, pools))
+			results = overcommitScheduler.Schedule(auctiontypes.AuctionRequest{
+				LRPs: []auctiontypes.LRPAuction{
+					BuildLRPAuction("pg-dev", "domain", 0, linuxRootFSURL, 150, 10, 10, clock.Now(), nil, []string{"dev"}),
+					BuildLRPAuction("pg-1", "domain", 0, linuxRootFSURL, 150, 10, 10, clock.Now(), nil, []string{}),
+				},
+			})
+
+			Expect(results.SuccessfulLRPs).To(HaveLen(1))
+			Expect(results.SuccessfulLRPs[0].ProcessGuid).To(Equal("pg-dev"))
+			Expect(results.SuccessfulLRPs[0].Overcommitted).To(BeTrue())
+			Expect(results.FailedLRPs).To(HaveLen(1))
+			Expect(results.FailedLRPs[0].ProcessGuid).To(Equal("pg-1"))
+		})
+	})
+
+	Describe("keeping headroom on the cells", func() {
+		var headroomZones func(containers int) map[string]auctionrunner.Zone
+
+		BeforeEach(func() {
+			headroomZones = func(containers int) map[string]auctionrunner.Zone {
+				return map[string]auctionrunner.Zone{
+					"the-zone": auctionrunner.Zone{
+						auctionrunner.NewCell(logger, "cell-0", &repfakes.FakeSimClient{}, BuildCellState("cell-0", 0, "the-zone", 100, 100, containers, false, 0, linuxOnlyRootFSProviders, nil, []string{}, []string{}, []string{}, 0)),
+					},
+				}
+			}
+		})
+
+		It("only lets LRPs with index 0 into the headroom", func() {
+			headroomScheduler := auctionrunner.NewScheduler(workPool, headroomZones(100), clock, logger, 0.0, 0.0, 0, auctionrunner.WithHeadroom(auctionrunner.Headroom{MemoryPercent: 20}))
+			results = headroomScheduler.Schedule(auctiontypes.AuctionRequest{
+				LRPs: []auctiontypes.LRPAuction{
+					BuildLRPAuction("pg-1", "domain", 0, linuxRootFSURL, 90, 10, 10, clock.Now(), nil, []string{}),
+					BuildLRPAuction("pg-2", "domain", 1, linuxRootFSURL, 5, 10, 10, clock.Now(), nil, []string{}),
+				},
+			})
+
+			Expect(results.SuccessfulLRPs).To(HaveLen(1))
+			Expect(results.SuccessfulLRPs[0].ProcessGuid).To(Equal("pg-1"))
+			Expect(results.FailedLRPs).To(HaveLen(1))
+			Expect(results.FailedLRPs[0].ProcessGuid).To(Equal("pg-2"))
+			Expect(results.FailedLRPs[0].PlacementError).To(Equal("insufficient resources: memory"))
+		})
+
+		It("only lets the work the urgent work predicates pick into the headroom", func() {
+			urgentTask := func(task *rep.Task) bool { return task.Domain == "urgent" }
+			headroomScheduler := auctionrunner.NewScheduler(workPool, headroomZones(100), clock, logger, 0.0, 0.0, 0, auctionrunner.WithHeadroom(auctionrunner.Headroom{MemoryPercent: 20}), auctionrunner.WithUrgentWork(nil, urgentTask))
+			results = headroomScheduler.Schedule(auctiontypes.AuctionRequest{
+				LRPs: []auctiontypes.LRPAuction{
+					BuildLRPAuction("pg-1", "domain", 0, linuxRootFSURL, 10, 10, 10, clock.Now(), nil, []string{}),
+				},
+				Tasks: []auctiontypes.TaskAuction{
+					BuildTaskAuction(BuildTask("tg-1", "urgent", linuxRootFSURL, 90, 10, 10, []string{}, []string{}), clock.Now()),
+				},
+			})
+
+			Expect(results.SuccessfulTasks).To(HaveLen(1))
+			Expect(results.FailedLRPs).To(HaveLen(1))
+			Expect(results.FailedLRPs[0].PlacementError).To(Equal("insufficient resources: memory"))
+		})
+
+		It("keeps the absolute amount free when it is larger than the percentage", func() {
+			headroomScheduler := auctionrunner.NewScheduler(workPool, headroomZones(100), clock, logger, 0.0, 0.0, 0, auctionrunner.WithHeadroom(auctionrunner.Headroom{MemoryPercent: 10, MemoryMB: 30}))
+			results = headroomScheduler.Schedule(auctiontypes.AuctionRequest{
+				Tasks: []auctiontypes.TaskAuction{
+					BuildTaskAuction(BuildTask("tg-1", "domain", linuxRootFSURL, 50, 10, 10, []string{}, []string{}), clock.Now()),
+					BuildTaskAuction(BuildTask("tg-2", "domain", linuxRootFSURL, 30, 10, 10, []string{}, []string{}), clock.Now()),
+					BuildTaskAuction(BuildTask("tg-3", "domain", linuxRootFSURL, 20, 10, 10, []string{}, []string{}), clock.Now()),
+				},
+			})
+
+			Expect(results.SuccessfulTasks).To(HaveLen(2))
+			Expect(results.FailedTasks).To(HaveLen(1))
+			Expect(results.FailedTasks[0].TaskGuid).To(Equal("tg-2"))
+		})
+
+		It("keeps containers free", func() {
+			headroomScheduler := auctionrunner.NewScheduler(workPool, headroomZones(2), clock, logger, 0.0, 0.0, 0, auctionrunner.WithHeadroom(auctionrunner.Headroom{Containers: 1}))
+			results = headroomScheduler.Schedule(auctiontypes.AuctionRequest{
+				Tasks: []auctiontypes.TaskAuction{
+					BuildTaskAuction(BuildTask("tg-1", "domain", linuxRootFSURL, 10, 10, 10, []string{}, []string{}), clock.Now()),
+					BuildTaskAuction(BuildTask("tg-2", "domain", linuxRootFSURL, 10, 10, 10, []string{}, []string{}), clock.Now()),
+				},
+			})
+
+			Expect(results.SuccessfulTasks).To(HaveLen(1))
+			Expect(results.FailedTasks).To(HaveLen(1))
+			Expect(results.FailedTasks[0].PlacementError).To(Equal("insufficient resources: containers"))
+		})
+
+		It("keeps the batch optimizer out of the headroom", func() {
+			headroomScheduler := auctionrunner.NewScheduler(workPool, headroomZones(100), clock, logger, 0.0, 0.0, 0, auctionrunner.WithHeadroom(auctionrunner.Headroom{MemoryPercent: 20}), auctionrunner.WithBatchOptimizer(time.Second, 2))
+			results = headroomScheduler.Schedule(auctiontypes.AuctionRequest{
+				Tasks: []auctiontypes.TaskAuction{
+					BuildTaskAuction(BuildTask("tg-1", "domain", linuxRootFSURL, 40, 10, 10, []string{}, []string{}), clock.Now()),
+					BuildTaskAuction(BuildTask("tg-2", "domain", linuxRootFSURL, 40, 10, 10, []string{}, []string{}), clock.Now()),
+					BuildTaskAuction(BuildTask("tg-3", "domain", linuxRootFSURL, 10, 10, 10, []string{}, []string{}), clock.Now()),
+				},
+			})
+
+			Expect(results.SuccessfulTasks).To(HaveLen(2))
+			Expect(results.FailedTasks).To(HaveLen(1))
+		})
+	})
+
+	Describe("accounting for the overhead of containers", func() {
+		var overheadZones func(proxyMemoryAllocationMB int) map[string]auctionrunner.Zone
+
+		BeforeEach(func() {
+			overheadZones = func(proxyMemoryAllocationMB int) map[string]auctionrunner.Zone {
+				return map[string]auctionrunner.Zone{
+					"the-zone": auctionrunner.Zone{
+						auctionrunner.NewCell(logger, "cell-0", &repfakes.FakeSimClient{}, BuildCellState("cell-0", 0, "the-zone", 100, 100, 100, false, 0, linuxOnlyRootFSProviders, nil, []string{}, []string{}, []string{}, proxyMemoryAllocationMB)),
+					},
+				}
+			}
+		})
+
+		It("reserves the proxy memory of the LRPs placed in the same auction", func() {
+			overheadScheduler := auctionrunner.NewScheduler(workPool, overheadZones(10), clock, logger, 0.0, 0.0, 0)
+			results = overheadScheduler.Schedule(auctiontypes.AuctionRequest{
+				LRPs: []auctiontypes.LRPAuction{
+					BuildLRPAuction("pg-1", "domain", 0, linuxRootFSURL, 45, 10, 10, clock.Now(), nil, []string{}),
+					BuildLRPAuction("pg-2", "domain", 0, linuxRootFSURL, 45, 10, 10, clock.Now(), nil, []string{}),
+				},
+			})
+
+			Expect(results.SuccessfulLRPs).To(HaveLen(1))
+			Expect(results.FailedLRPs).To(HaveLen(1))
+			Expect(results.FailedLRPs[0].PlacementError).To(Equal("insufficient resources: memory"))
+		})
+
+		It("reserves the overhead of every container", func() {
+			overhead := func(rep.CellState) auctiontypes.ContainerOverhead {
+				return auctiontypes.ContainerOverhead{MemoryMB: 10}
+			}
+			overheadScheduler := auctionrunner.NewScheduler(workPool, overheadZones(0), clock, logger, 0.0, 0.0, 0, auctionrunner.WithContainerOverhead(overhead))
+			results = overheadScheduler.Schedule(auctiontypes.AuctionRequest{
+				Tasks: []auctiontypes.TaskAuction{
+					BuildTaskAuction(BuildTask("tg-1", "domain", linuxRootFSURL, 45, 10, 10, []string{}, []string{}), clock.Now()),
+					BuildTaskAuction(BuildTask("tg-2", "domain", linuxRootFSURL, 45, 10, 10, []string{}, []string{}), clock.Now()),
+				},
+			})
+
+			Expect(results.SuccessfulTasks).To(HaveLen(1))
+			Expect(results.FailedTasks).To(HaveLen(1))
+		})
+
+		It("only gives LRP containers sidecars", func() {
+			overhead := func(rep.CellState) auctiontypes.ContainerOverhead {
+				return auctiontypes.ContainerOverhead{SidecarMemoryMB: 20}
+			}
+			overheadScheduler := auctionrunner.NewScheduler(workPool, overheadZones(0), clock, logger, 0.0, 0.0, 0, auctionrunner.WithContainerOverhead(overhead))
+			results = overheadScheduler.Schedule(auctiontypes.AuctionRequest{
+				LRPs:  []auctiontypes.LRPAuction{BuildLRPAuction("pg-1", "domain", 0, linuxRootFSURL, 90, 10, 10, clock.Now(), nil, []string{})},
+				Tasks: []auctiontypes.TaskAuction{BuildTaskAuction(BuildTask("tg-1", "domain", linuxRootFSURL, 90, 10, 10, []string{}, []string{}), clock.Now())},
+			})
+
+			Expect(results.SuccessfulTasks).To(HaveLen(1))
+			Expect(results.FailedLRPs).To(HaveLen(1))
+		})
+	})
+
+	Describe("choosing the task placement strategy", func() {
+		var cellWithTasks func(guid string, index int, zone string, tasks int, lrps ...rep.LRP) *auctionrunner.Cell
+
+		BeforeEach(func() {
+			cellWithTasks = func(guid string, index int, zone string, tasks int, lrps ...rep.LRP) *auctionrunner.Cell {
+				state := BuildCellState(guid, index, zone, 100, 100, 100, false, 0, linuxOnlyRootFSProviders, lrps, []string{}, []string{}, []string{}, 0)
+				for i := 0; i < tasks; i++ {
+					task := BuildTask(fmt.Sprintf("%s-task-%d", guid, i), "domain", linuxRootFSURL, 1, 1, 1, []string{}, []string{})
+					state.Tasks = append(state.Tasks, *task)
+					state.AvailableResources.Subtract(&task.Resource)
+				}
+				return auctionrunner.NewCell(logger, guid, &repfakes.FakeSimClient{}, state)
+			}
+		})
+
+		Context("when packing tasks", func() {
+			It("favors the fullest cells", func() {
+				busy := *BuildLRP("pg-busy", "domain", 0, "", 60, 10, 0, []string{})
+				zones := map[string]auctionrunner.Zone{
+					"the-zone": auctionrunner.Zone{cellWithTasks("cell-0", 0, "the-zone", 0), cellWithTasks("cell-1", 1, "the-zone", 2, busy)},
+				}
+				packScheduler := auctionrunner.NewScheduler(workPool, zones, clock, logger, 0.0, 0.0, 0, auctionrunner.WithTaskPlacementStrategy(auctionrunner.PackTasks))
+				results = packScheduler.Schedule(auctiontypes.AuctionRequest{
+					Tasks: []auctiontypes.TaskAuction{BuildTaskAuction(BuildTask("tg-1", "domain", linuxRootFSURL, 10, 10, 10, []string{}, []string{}), clock.Now())},
+				})
+
+				Expect(results.SuccessfulTasks).To(HaveLen(1))
+				Expect(results.SuccessfulTasks[0].Winner).To(Equal("cell-1"))
+			})
+
+			It("packs the tasks of a batch onto the same cell", func() {
+				zones := map[string]auctionrunner.Zone{
+					"the-zone": auctionrunner.Zone{cellWithTasks("cell-0", 0, "the-zone", 0), cellWithTasks("cell-1", 1, "the-zone", 0)},
+				}
+				packScheduler := auctionrunner.NewScheduler(workPool, zones, clock, logger, 0.0, 0.0, 0, auctionrunner.WithTaskPlacementStrategy(auctionrunner.PackTasks))
+				results = packScheduler.Schedule(auctiontypes.AuctionRequest{
+					Tasks: []auctiontypes.TaskAuction{
+						BuildTaskAuction(BuildTask("tg-1", "domain", linuxRootFSURL, 10, 10, 10, []string{}, []string{}), clock.Now()),
+						BuildTaskAuction(BuildTask("tg-2", "domain", linuxRootFSURL, 10, 10, 10, []string{}, []string{}), clock.Now()),
+						BuildTaskAuction(BuildTask("tg-3", "domain", linuxRootFSURL, 10, 10, 10, []string{}, []string{}), clock.Now()),
+					},
+				})
+
+				Expect(results.SuccessfulTasks).To(HaveLen(3))
+				for _, task := range results.SuccessfulTasks {
+					Expect(task.Winner).To(Equal("cell-0"))
+				}
+			})
+
+			It("favors the cells with the lowest index with a bin pack first fit weight", func() {
+				busy := *BuildLRP("pg-busy", "domain", 0, "", 10, 10, 0, []string{})
+				zones := map[string]auctionrunner.Zone{
+					"the-zone": auctionrunner.Zone{cellWithTasks("cell-0", 0, "the-zone", 0), cellWithTasks("cell-1", 1, "the-zone", 0, busy)},
+				}
+				packScheduler := auctionrunner.NewScheduler(workPool, zones, clock, logger, 1.0, 0.0, 0, auctionrunner.WithTaskPlacementStrategy(auctionrunner.PackTasks))
+				results = packScheduler.Schedule(auctiontypes.AuctionRequest{
+					Tasks: []auctiontypes.TaskAuction{BuildTaskAuction(BuildTask("tg-1", "domain", linuxRootFSURL, 10, 10, 10, []string{}, []string{}), clock.Now())},
+				})
+
+				Expect(results.SuccessfulTasks).To(HaveLen(1))
+				Expect(results.SuccessfulTasks[0].Winner).To(Equal("cell-0"))
+			})
+		})
+
+		Context("when balancing tasks across zones", func() {
+			var zones map[string]auctionrunner.Zone
+
+			BeforeEach(func() {
+				zones = map[string]auctionrunner.Zone{
+					"zone-a": auctionrunner.Zone{cellWithTasks("cell-a0", 0, "zone-a", 2), cellWithTasks("cell-a1", 1, "zone-a", 0)},
+					"zone-b": auctionrunner.Zone{cellWithTasks("cell-b0", 2, "zone-b", 1)},
+				}
+			})
+
+			It("favors the zones running the fewest tasks", func() {
+				balancingScheduler := auctionrunner.NewScheduler(workPool, zones, clock, logger, 0.0, 0.0, 0, auctionrunner.WithTaskPlacementStrategy(auctionrunner.BalanceTasksAcrossZones))
+				results = balancingScheduler.Schedule(auctiontypes.AuctionRequest{
+					Tasks: []auctiontypes.TaskAuction{BuildTaskAuction(BuildTask("tg-1", "domain", linuxRootFSURL, 10, 10, 10, []string{}, []string{}), clock.Now())},
+				})
+
+				Expect(results.SuccessfulTasks).To(HaveLen(1))
+				Expect(results.SuccessfulTasks[0].Winner).To(Equal("cell-b0"))
+			})
+
+			It("spreads tasks across every zone by default", func() {
+				results = auctionrunner.NewScheduler(workPool, zones, clock, logger, 0.0, 0.0, 0).Schedule(auctiontypes.AuctionRequest{
+					Tasks: []auctiontypes.TaskAuction{BuildTaskAuction(BuildTask("tg-1", "domain", linuxRootFSURL, 10, 10, 10, []string{}, []string{}), clock.Now())},
+				})
+
+				Expect(results.SuccessfulTasks).To(HaveLen(1))
+				Expect(results.SuccessfulTasks[0].Winner).To(Equal("cell-a1"))
+			})
+		})
+	})
+
+	Describe("balancing grouped tasks across zones", func() {
+		var cellRunning func(guid string, index int, zone string, taskGuids ...string) *auctionrunner.Cell
+		var groupedTasks func(count int) []auctiontypes.TaskAuction
+		var tasksPerZone func() map[string]int
+
+		BeforeEach(func() {
+			cellRunning = func(guid string, index int, zone string, taskGuids ...string) *auctionrunner.Cell {
+				state := BuildCellState(guid, index, zone, 100, 100, 100, false, 0, linuxOnlyRootFSProviders, nil, []string{}, []string{}, []string{}, 0)
+				for _, taskGuid := range taskGuids {
+					task := BuildTask(taskGuid, "domain", linuxRootFSURL, 1, 1, 1, []string{}, []string{})
+					state.Tasks = append(state.Tasks, *task)
+					state.AvailableResources.Subtract(&task.Resource)
+				}
+				return auctionrunner.NewCell(logger, guid, &repfakes.FakeSimClient{}, state)
+			}
+			groupedTasks = func(count int) []auctiontypes.TaskAuction {
+				auctions := []auctiontypes.TaskAuction{}
+				for i := 0; i < count; i++ {
+					auction := BuildTaskAuction(BuildTask(fmt.Sprintf("tg-%d", i), "domain", linuxRootFSURL, 10, 10, 10, []string{}, []string{}), clock.Now())
+					auction.GroupingKey = "job-1"
+					auctions = append(auctions, auction)
+				}
+				return auctions
+			}
+			tasksPerZone = func() map[string]int {
+				perZone := map[string]int{}
+				for _, task := range results.SuccessfulTasks {
+					perZone[task.Winner[:len("cell-a")]]++
+				}
+				return perZone
+			}
+		})
+
+		It("balances the tasks sharing a grouping key across zones", func() {
+			zones := map[string]auctionrunner.Zone{
+				"zone-a": auctionrunner.Zone{cellRunning("cell-a0", 0, "zone-a"), cellRunning("cell-a1", 1, "zone-a")},
+				"zone-b": auctionrunner.Zone{cellRunning("cell-b0", 2, "zone-b")},
+			}
+			results = auctionrunner.NewScheduler(workPool, zones, clock, logger, 0.0, 0.0, 0).Schedule(auctiontypes.AuctionRequest{
+				Tasks: groupedTasks(4),
+			})
+
+			Expect(results.SuccessfulTasks).To(HaveLen(4))
+			Expect(tasksPerZone()).To(Equal(map[string]int{"cell-a": 2, "cell-b": 2}))
+		})
+
+		It("counts the running tasks the grouping key function groups", func() {
+			zones := map[string]auctionrunner.Zone{
+				"zone-a": auctionrunner.Zone{cellRunning("cell-a0", 0, "zone-a", "job-1-running"), cellRunning("cell-a1", 1, "zone-a")},
+				"zone-b": auctionrunner.Zone{cellRunning("cell-b0", 2, "zone-b", "job-2-running", "job-2-also-running")},
+			}
+			jobID := func(task *rep.Task) string {
+				return task.TaskGuid[:len("job-1")]
+			}
+			groupingScheduler := auctionrunner.NewScheduler(workPool, zones, clock, logger, 0.0, 0.0, 0, auctionrunner.WithTaskGroupingKey(jobID))
+			results = groupingScheduler.Schedule(auctiontypes.AuctionRequest{
+				Tasks: []auctiontypes.TaskAuction{BuildTaskAuction(BuildTask("job-1-new", "domain", linuxRootFSURL, 10, 10, 10, []string{}, []string{}), clock.Now())},
+			})
+
+			Expect(results.SuccessfulTasks).To(HaveLen(1))
+			Expect(results.SuccessfulTasks[0].Winner).To(Equal("cell-b0"))
+		})
+	})
+
+	Describe("co-scheduling task groups", func() {
+		var cell func(guid string, index int, optionalPlacementTags ...string) *auctionrunner.Cell
+		var groupedTask func(guid, group string, memoryMB int32, placementTags ...string) auctiontypes.TaskAuction
+		var winners func() map[string]string
+
+		BeforeEach(func() {
+			cell = func(guid string, index int, optionalPlacementTags ...string) *auctionrunner.Cell {
+				return auctionrunner.NewCell(logger, guid, &repfakes.FakeSimClient{}, BuildCellState(guid, index, "the-zone", 100, 100, 100, false, 0, linuxOnlyRootFSProviders, nil, []string{}, []string{}, optionalPlacementTags, 0))
+			}
+			groupedTask = func(guid, group string, memoryMB int32, placementTags ...string) auctiontypes.TaskAuction {
+				auction := BuildTaskAuction(BuildTask(guid, "domain", linuxRootFSURL, memoryMB, 10, 10, []string{}, placementTags), clock.Now())
+				auction.TaskGroup = group
+				return auction
+			}
+			winners = func() map[string]string {
+				winners := map[string]string{}
+				for _, task := range results.SuccessfulTasks {
+					winners[task.TaskGuid] = task.Winner
+				}
+				return winners
+			}
+		})
+
+		It("places the tasks of a group on the same cell", func() {
+			zones := map[string]auctionrunner.Zone{"the-zone": auctionrunner.Zone{cell("cell-0", 0), cell("cell-1", 1)}}
+			results = auctionrunner.NewScheduler(workPool, zones, clock, logger, 0.0, 0.0, 0).Schedule(auctiontypes.AuctionRequest{
+				Tasks: []auctiontypes.TaskAuction{
+					groupedTask("tg-1", "workflow", 40),
+					groupedTask("tg-2", "workflow", 40),
+				},
+			})
+
+			Expect(results.FailedTasks).To(BeEmpty())
+			Expect(results.SuccessfulTasks).To(HaveLen(2))
+			Expect(winners()["tg-1"]).To(Equal(winners()["tg-2"]))
+		})
+
+		It("fails every task of a group that no cell can fit together", func() {
+			zones := map[string]auctionrunner.Zone{"the-zone": auctionrunner.Zone{cell("cell-0", 0), cell("cell-1", 1)}}
+			results = auctionrunner.NewScheduler(workPool, zones, clock, logger, 0.0, 0.0, 0).Schedule(auctiontypes.AuctionRequest{
+				Tasks: []auctiontypes.TaskAuction{
+					groupedTask("tg-1", "workflow", 40),
+					groupedTask("tg-2", "workflow", 40),
+					groupedTask("tg-3", "workflow", 40),
+					BuildTaskAuction(BuildTask("ungrouped", "domain", linuxRootFSURL, 40, 10, 10, []string{}, []string{}), clock.Now()),
+				},
+			})
+
+			Expect(results.SuccessfulTasks).To(HaveLen(1))
+			Expect(results.SuccessfulTasks[0].TaskGuid).To(Equal("ungrouped"))
+
+			Expect(results.FailedTasks).To(HaveLen(3))
+			for _, task := range results.FailedTasks {
+				Expect(task.TaskGroup).To(Equal("workflow"))
+				Expect(task.PlacementError).To(Equal("insufficient resources: memory"))
+			}
+			Expect(logger.LogMessages()).To(ContainElement("fakelogger.task-group-auction-failed"))
+		})
+
+		It("places the group on a cell matching the placement tags of every task", func() {
+			zones := map[string]auctionrunner.Zone{"the-zone": auctionrunner.Zone{
+				cell("cell-gpu", 0, "gpu"),
+				cell("cell-ssd", 1, "ssd"),
+				cell("cell-both", 2, "gpu", "ssd"),
+			}}
+			results = auctionrunner.NewScheduler(workPool, zones, clock, logger, 0.0, 0.0, 0).Schedule(auctiontypes.AuctionRequest{
+				Tasks: []auctiontypes.TaskAuction{
+					groupedTask("tg-gpu", "workflow", 10, "gpu"),
+					groupedTask("tg-ssd", "workflow", 10, "ssd"),
+				},
+			})
+
+			Expect(results.SuccessfulTasks).To(HaveLen(2))
+			Expect(winners()).To(Equal(map[string]string{"tg-gpu": "cell-both", "tg-ssd": "cell-both"}))
+		})
+
+		It("fails the group when no cell matches every task", func() {
+			zones := map[string]auctionrunner.Zone{"the-zone": auctionrunner.Zone{cell("cell-gpu", 0, "gpu"), cell("cell-ssd", 1, "ssd")}}
+			results = auctionrunner.NewScheduler(workPool, zones, clock, logger, 0.0, 0.0, 0).Schedule(auctiontypes.AuctionRequest{
+				Tasks: []auctiontypes.TaskAuction{
+					groupedTask("tg-gpu", "workflow", 10, "gpu"),
+					groupedTask("tg-ssd", "workflow", 10, "ssd"),
+				},
+			})
+
+			Expect(results.SuccessfulTasks).To(BeEmpty())
+			Expect(results.FailedTasks).To(HaveLen(2))
+			for _, task := range results.FailedTasks {
+				Expect(task.PlacementError).To(Equal(auctiontypes.ErrorTaskGroupMismatch.Error()))
+			}
+		})
+
+		It("fails the whole group when it would exceed the in-flight start limit", func() {
+			zones := map[string]auctionrunner.Zone{"the-zone": auctionrunner.Zone{cell("cell-0", 0)}}
+			results = auctionrunner.NewScheduler(workPool, zones, clock, logger, 0.0, 0.0, 2).Schedule(auctiontypes.AuctionRequest{
+				Tasks: []auctiontypes.TaskAuction{
+					groupedTask("tg-1", "workflow", 10),
+					groupedTask("tg-2", "workflow", 10),
+					groupedTask("tg-3", "workflow", 10),
+				},
+			})
+
+			Expect(results.SuccessfulTasks).To(BeEmpty())
+			Expect(results.FailedTasks).To(HaveLen(3))
+			for _, task := range results.FailedTasks {
+				Expect(task.PlacementError).To(Equal(auctiontypes.ErrorExceededInflightCreation.Error()))
+			}
+		})
+	})
+
+	Describe("optimizing the placement of the whole batch", func() {
+		var strandingZones func() map[string]auctionrunner.Zone
+		var strandingRequest auctiontypes.AuctionRequest
+
+		BeforeEach(func() {
+			strandingZones = func() map[string]auctionrunner.Zone {
+				zone := auctionrunner.Zone{}
+				for i := 0; i < 2; i++ {
+					guid := fmt.Sprintf("cell-%d", i)
+					zone = append(zone, auctionrunner.NewCell(logger, guid, &repfakes.FakeSimClient{}, BuildCellState(guid, i, "the-zone", 100, 100, 100, false, 0, linuxOnlyRootFSProviders, nil, []string{}, []string{}, []string{}, 0)))
+				}
+				return map[string]auctionrunner.Zone{"the-zone": zone}
+			}
+
+			// placed one at a time, the pebbles are spread over both cells and
+			// leave no cell with room for the second boulder
+			strandingRequest = auctiontypes.AuctionRequest{
+				LRPs: []auctiontypes.LRPAuction{
+					BuildLRPAuction("pebble-1", "domain", 1, linuxRootFSURL, 30, 10, 10, clock.Now(), nil, []string{}),
+					BuildLRPAuction("pebble-2", "domain", 1, linuxRootFSURL, 30, 10, 10, clock.Now(), nil, []string{}),
+					BuildLRPAuction("pebble-3", "domain", 1, linuxRootFSURL, 30, 10, 10, clock.Now(), nil, []string{}),
+					BuildLRPAuction("boulder-1", "domain", 2, linuxRootFSURL, 50, 10, 10, clock.Now(), nil, []string{}),
+					BuildLRPAuction("boulder-2", "domain", 2, linuxRootFSURL, 50, 10, 10, clock.Now(), nil, []string{}),
+				},
+			}
+		})
+
+		It("places every auction that greedy scheduling strands", func() {
+			results = auctionrunner.NewScheduler(workPool, strandingZones(), clock, logger, 0.25, 0.25, 0).Schedule(strandingRequest)
+			Expect(results.SuccessfulLRPs).To(HaveLen(4))
+
+			results = auctionrunner.NewScheduler(workPool, strandingZones(), clock, logger, 0.25, 0.25, 0, auctionrunner.WithBatchOptimizer(time.Second, 2)).Schedule(strandingRequest)
+			Expect(results.SuccessfulLRPs).To(HaveLen(5))
+			Expect(results.FailedLRPs).To(BeEmpty())
+
+			winners := map[string]string{}
+			for _, lrp := range results.SuccessfulLRPs {
+				winners[lrp.ProcessGuid] = lrp.Winner
+			}
+			Expect(winners["boulder-1"]).To(Equal(winners["boulder-2"]))
+		})
+
+		It("falls back to greedy scheduling when planning runs out of time", func() {
+			stepping := steppingClock{FakeClock: clock, step: 2 * time.Millisecond}
+			results = auctionrunner.NewScheduler(workPool, strandingZones(), stepping, logger, 0.25, 0.25, 0, auctionrunner.WithBatchOptimizer(time.Millisecond, 2)).Schedule(strandingRequest)
+
+			Expect(results.SuccessfulLRPs).To(HaveLen(4))
+			Expect(results.FailedLRPs).To(HaveLen(1))
+			Expect(logger.LogMessages()).To(ContainElement("fakelogger.batch-optimizer.exceeded-budget"))
+		})
+
+		It("compares the plan with greedy scheduling configured like the scheduler", func() {
+			// packed one at a time, the tasks leave a gap on each cell that the
+			// last task does not fit in, while spread they all fit
+			sizes := []int32{40, 40, 35, 35, 25, 25}
+			request := auctiontypes.AuctionRequest{}
+			for i, size := range sizes {
+				request.Tasks = append(request.Tasks, BuildTaskAuction(BuildTask(fmt.Sprintf("tg-%d", i), "domain", linuxRootFSURL, size, 10, 10, []string{}, []string{}), clock.Now()))
+			}
+
+			packScheduler := auctionrunner.NewScheduler(workPool, strandingZones(), clock, logger, 0.0, 0.0, 0, auctionrunner.WithTaskPlacementStrategy(auctionrunner.PackTasks))
+			results = packScheduler.Schedule(request)
+			Expect(results.SuccessfulTasks).To(HaveLen(5))
+
+			packScheduler = auctionrunner.NewScheduler(workPool, strandingZones(), clock, logger, 0.0, 0.0, 0, auctionrunner.WithTaskPlacementStrategy(auctionrunner.PackTasks), auctionrunner.WithBatchOptimizer(time.Second, 2))
+			results = packScheduler.Schedule(request)
+			Expect(results.SuccessfulTasks).To(HaveLen(6))
+			Expect(results.FailedTasks).To(BeEmpty())
+		})
+
+		It("plans tasks with the task placement strategy", func() {
+			zone := auctionrunner.Zone{}
+			for i := 0; i < 2; i++ {
+				guid := fmt.Sprintf("cell-%d", i)
+				zone = append(zone, auctionrunner.NewCell(logger, guid, &repfakes.FakeSimClient{}, BuildCellState(guid, i, "the-zone", 110, 110, 100, false, 0, linuxOnlyRootFSProviders, nil, []string{}, []string{}, []string{}, 0)))
+			}
+			request := strandingRequest
+			request.Tasks = []auctiontypes.TaskAuction{
+				BuildTaskAuction(BuildTask("tg-1", "domain", linuxRootFSURL, 5, 10, 10, []string{}, []string{}), clock.Now()),
+				BuildTaskAuction(BuildTask("tg-2", "domain", linuxRootFSURL, 5, 10, 10, []string{}, []string{}), clock.Now()),
+			}
+
+			results = auctionrunner.NewScheduler(workPool, map[string]auctionrunner.Zone{"the-zone": zone}, clock, logger, 0.25, 0.25, 0, auctionrunner.WithBatchOptimizer(time.Second, 2)).Schedule(request)
+			Expect(results.SuccessfulLRPs).To(HaveLen(5))
+			Expect(results.SuccessfulTasks).To(HaveLen(2))
+			Expect(logger.LogMessages()).To(ContainElement("fakelogger.batch-optimizer.using-plan"))
+			Expect(results.SuccessfulTasks[0].Winner).NotTo(Equal(results.SuccessfulTasks[1].Winner))
+		})
+
+		It("counts the greedy scheduling it compares the plan with against the budget", func() {
+			// the plan is made within the budget, but the greedy scheduling of the
+			// batch it is compared with runs past it
+			stepping := steppingClock{FakeClock: clock, step: time.Millisecond}
+			results = auctionrunner.NewScheduler(workPool, strandingZones(), stepping, logger, 0.25, 0.25, 0, auctionrunner.WithBatchOptimizer(8*time.Millisecond, 2)).Schedule(strandingRequest)
+
+			Expect(results.SuccessfulLRPs).To(HaveLen(4))
+			Expect(results.FailedLRPs).To(HaveLen(1))
+			Expect(logger.LogMessages()).To(ContainElement("fakelogger.batch-optimizer.exceeded-budget"))
+		})
+
+		It("does not plan batches with task groups", func() {
+			groupedTask := BuildTaskAuction(BuildTask("tg-1", "domain", linuxRootFSURL, 5, 10, 10, []string{}, []string{}), clock.Now())
+			groupedTask.TaskGroup = "workflow"
+			request := strandingRequest
+			request.Tasks = []auctiontypes.TaskAuction{groupedTask}
+
+			results = auctionrunner.NewScheduler(workPool, strandingZones(), clock, logger, 0.25, 0.25, 0, auctionrunner.WithBatchOptimizer(time.Second, 2)).Schedule(request)
+			Expect(results.SuccessfulLRPs).To(HaveLen(4))
+			Expect(results.SuccessfulTasks).To(HaveLen(1))
+			Expect(logger.LogMessages()).NotTo(ContainElement("fakelogger.batch-optimizer.using-plan"))
+		})
+
+		It("keeps placing LRPs with index 0 ahead of tasks", func() {
+			zone := auctionrunner.Zone{auctionrunner.NewCell(logger, "cell", &repfakes.FakeSimClient{}, BuildCellState("cell", 0, "the-zone", 100, 100, 100, false, 0, linuxOnlyRootFSProviders, nil, []string{}, []string{}, []string{}, 0))}
+			results = auctionrunner.NewScheduler(workPool, map[string]auctionrunner.Zone{"the-zone": zone}, clock, logger, 0.25, 0.25, 0, auctionrunner.WithBatchOptimizer(time.Second, 2)).Schedule(auctiontypes.AuctionRequest{
+				LRPs: []auctiontypes.LRPAuction{
+					BuildLRPAuction("pg-1", "domain", 0, linuxRootFSURL, 50, 10, 10, clock.Now(), nil, []string{}),
+					BuildLRPAuction("pg-1", "domain", 1, linuxRootFSURL, 10, 10, 10, clock.Now(), nil, []string{}),
+				},
+				Tasks: []auctiontypes.TaskAuction{BuildTaskAuction(BuildTask("tg-1", "domain", linuxRootFSURL, 60, 10, 10, []string{}, []string{}), clock.Now())},
+			})
+
+			Expect(results.SuccessfulLRPs).To(HaveLen(2))
+			Expect(results.FailedTasks).To(HaveLen(1))
+			Expect(results.FailedTasks[0].PlacementError).To(ContainSubstring("insufficient resources"))
+		})
+	})
 })
 
 func setLRPWinner(cellName string, lrps ...*auctiontypes.LRPAuction) {
@@ -1163,3 +2250,14 @@ func setTaskWinner(cellName string, tasks ...*auctiontypes.TaskAuction) {
 		t.Attempts++
 	}
 }
+
+// steppingClock moves a fake clock forward every time it is read.
+type steppingClock struct {
+	*fakeclock.FakeClock
+	step time.Duration
+}
+
+func (c steppingClock) Now() time.Time {
+	c.Increment(c.step)
+	return c.FakeClock.Now()
+}
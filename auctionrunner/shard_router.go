@@ -0,0 +1,598 @@
+package auctionrunner
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"sync"
+
+	"code.cloudfoundry.org/auction/auctiontypes"
+	"code.cloudfoundry.org/auctioneer"
+	"code.cloudfoundry.org/bbs/models"
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/rep"
+	"github.com/tedsuo/ifrit"
+)
+
+// NewShardRunner builds the auction runner of a single shard.  Runners built
+// with New should be given WithCellPartition(partition).
+type NewShardRunner func(partition CellPartition, delegate auctiontypes.AuctionRunnerDelegate) auctiontypes.AuctionRunner
+
+type shard struct {
+	partition CellPartition
+	runner    auctiontypes.AuctionRunner
+}
+
+/*
+shardRouter spreads auctions over several auction runners, each of which owns
+a disjoint partition of the cells.  Every auction goes to a shard whose
+partition can satisfy its placement constraint.  When several can, the
+instances of a process are dealt round the shards in index order, starting from
+a shard picked by hashing the process guid, so that they are balanced over the
+shards, and so over the zones of zone partitions, and a restarted instance goes
+back to the shard of its index.  Tasks are spread by hashing their guid.
+
+Unlike a single auction runner, which balances the zones by the instances that
+actually run in them, the router does not see where instances run: an instance
+retried on another shard for lack of resources stays there, and instances
+placed before the shards were set up are not accounted for.  The tasks of a task group are routed, and retried, together, to a shard that
+can satisfy all of them.  Auctions no shard can satisfy fail with a placement
+tag mismatch.
+
+An auction that fails for lack of resources is retried on the next shard that
+can satisfy it and has not tried it yet, and only reported once every such
+shard has.  The shards report their results through a shared delegate that
+serializes them, so the router's delegate sees a single stream of results.
+*/
+type shardRouter struct {
+	logger   lager.Logger
+	delegate auctiontypes.AuctionRunnerDelegate
+	clock    clock.Clock
+	shards   []shard
+
+	taskGroup func(task *rep.Task) string // nil leaves tasks ungrouped
+
+	// triedLRPs and triedTasks hold the shards that failed an auction being
+	// retried.
+	triedLRPs  map[string][]int
+	triedTasks map[string][]int
+	lock       *sync.Mutex
+}
+
+type ShardRouterOption func(*shardRouter)
+
+// WithShardTaskGroups routes the tasks group gives the same task group to the
+// same shard.  It should be given the function the shard runners are built
+// WithTaskGroups with.
+func WithShardTaskGroups(group func(task *rep.Task) string) ShardRouterOption {
+	return func(r *shardRouter) {
+		r.taskGroup = group
+	}
+}
+
+func NewShardRouter(
+	logger lager.Logger,
+	delegate auctiontypes.AuctionRunnerDelegate,
+	clock clock.Clock,
+	partitions []CellPartition,
+	newShardRunner NewShardRunner,
+	options ...ShardRouterOption,
+) *shardRouter {
+	router := &shardRouter{
+		logger:     logger.Session("shard-router"),
+		delegate:   &mergedDelegate{delegate: delegate, lock: &sync.Mutex{}},
+		clock:      clock,
+		shards:     make([]shard, 0, len(partitions)),
+		triedLRPs:  map[string][]int{},
+		triedTasks: map[string][]int{},
+		lock:       &sync.Mutex{},
+	}
+
+	for _, option := range options {
+		option(router)
+	}
+
+	for i, partition := range partitions {
+		router.shards = append(router.shards, shard{
+			partition: partition,
+			runner:    newShardRunner(partition, shardDelegate{router: router, shard: i}),
+		})
+	}
+
+	return router
+}
+
+type mergedDelegate struct {
+	delegate auctiontypes.AuctionRunnerDelegate
+	lock     *sync.Mutex
+}
+
+func (d *mergedDelegate) FetchCellReps() (map[string]rep.Client, error) {
+	return d.delegate.FetchCellReps()
+}
+
+func (d *mergedDelegate) AuctionCompleted(results auctiontypes.AuctionResults) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.delegate.AuctionCompleted(results)
+}
+
+// shardDelegate is the delegate of a single shard, which lets the router retry
+// the auctions the shard had no room for.
+type shardDelegate struct {
+	router *shardRouter
+	shard  int
+}
+
+func (d shardDelegate) FetchCellReps() (map[string]rep.Client, error) {
+	return d.router.delegate.FetchCellReps()
+}
+
+func (d shardDelegate) AuctionCompleted(results auctiontypes.AuctionResults) {
+	d.router.shardCompleted(d.shard, results)
+}
+
+// Run starts every shard and stops them all when signalled or as soon as one
+// of them exits.
+func (r *shardRouter) Run(signals <-chan os.Signal, ready chan<- struct{}) error {
+	processes := make([]ifrit.Process, 0, len(r.shards))
+	exited := make(chan error, len(r.shards))
+	for i := range r.shards {
+		process := ifrit.Invoke(r.shards[i].runner)
+		processes = append(processes, process)
+		go func() {
+			exited <- <-process.Wait()
+		}()
+	}
+
+	close(ready)
+
+	var err error
+	var signal os.Signal = os.Interrupt
+	select {
+	case signal = <-signals:
+		r.logger.Info("signalled", lager.Data{"signal": signal.String()})
+	case err = <-exited:
+		r.logger.Error("shard-exited", err)
+	}
+
+	for _, process := range processes {
+		process.Signal(signal)
+	}
+	for _, process := range processes {
+		<-process.Wait()
+	}
+
+	return err
+}
+
+// candidates returns the indices of the shards that can satisfy every one of
+// the constraints.
+func (r *shardRouter) candidates(constraints ...rep.PlacementConstraint) []int {
+	candidates := make([]int, 0, len(r.shards))
+	for i := range r.shards {
+		satisfied := true
+		for _, constraint := range constraints {
+			if !r.shards[i].partition.CanSatisfy(constraint) {
+				satisfied = false
+				break
+			}
+		}
+		if satisfied {
+			candidates = append(candidates, i)
+		}
+	}
+	return candidates
+}
+
+// route returns the index of the shard a task is sent to, or -1 when no shard
+// can satisfy its constraint.
+func (r *shardRouter) route(constraint rep.PlacementConstraint, taskGuid string) int {
+	return pickShard(r.candidates(constraint), taskGuid)
+}
+
+// routeInstance returns the index of the shard an LRP instance is sent to, or
+// -1 when no shard can satisfy its constraint.
+func (r *shardRouter) routeInstance(constraint rep.PlacementConstraint, processGuid string, index int32) int {
+	candidates := r.candidates(constraint)
+	if len(candidates) == 0 {
+		return -1
+	}
+	first := hashIndex(processGuid, len(candidates))
+	return candidates[(first+int(index))%len(candidates)]
+}
+
+// routeTasks returns the index of the shard each task is sent to, or -1 when
+// no shard can satisfy it.  groups holds the task group of each task.  The
+// tasks of a task group are sent to a shard that can satisfy all of them, or
+// all fail.
+func (r *shardRouter) routeTasks(tasks []*rep.Task, groups []string) []int {
+	groupConstraints := map[string][]rep.PlacementConstraint{}
+	for i, task := range tasks {
+		if groups[i] != "" {
+			groupConstraints[groups[i]] = append(groupConstraints[groups[i]], task.PlacementConstraint)
+		}
+	}
+
+	groupShards := make(map[string]int, len(groupConstraints))
+	for group, constraints := range groupConstraints {
+		groupShards[group] = pickShard(r.candidates(constraints...), group)
+	}
+
+	shards := make([]int, len(tasks))
+	for i, task := range tasks {
+		if groups[i] != "" {
+			shards[i] = groupShards[groups[i]]
+			continue
+		}
+		shards[i] = r.route(task.PlacementConstraint, task.TaskGuid)
+	}
+	return shards
+}
+
+// taskGroupOf returns the task group of the task, or "" when the router was
+// not given WithShardTaskGroups.
+func (r *shardRouter) taskGroupOf(task *rep.Task) string {
+	if r.taskGroup == nil {
+		return ""
+	}
+	return r.taskGroup(task)
+}
+
+// pickShard hashes id over the candidates, returning -1 when there are none.
+func pickShard(candidates []int, id string) int {
+	if len(candidates) == 0 {
+		return -1
+	}
+	return candidates[hashIndex(id, len(candidates))]
+}
+
+func (r *shardRouter) ScheduleLRPsForAuctions(lrpStarts []auctioneer.LRPStartRequest) error {
+	routed := make([][]auctioneer.LRPStartRequest, len(r.shards))
+	unroutable := []auctiontypes.LRPAuction{}
+	for _, start := range lrpStarts {
+		routedIndices := make([][]int, len(r.shards))
+		for _, index := range start.Indices {
+			lrpKey := models.NewActualLRPKey(start.ProcessGuid, int32(index), start.Domain)
+			lrp := rep.NewLRP("", lrpKey, start.Resource, start.PlacementConstraint)
+			i := r.routeInstance(start.PlacementConstraint, start.ProcessGuid, int32(index))
+			if i < 0 {
+				unroutable = append(unroutable, auctiontypes.NewLRPAuction(lrp, r.clock.Now()))
+				continue
+			}
+			routedIndices[i] = append(routedIndices[i], index)
+		}
+
+		for i, indices := range routedIndices {
+			if len(indices) == 0 {
+				continue
+			}
+			shardStart := start
+			shardStart.Indices = indices
+			routed[i] = append(routed[i], shardStart)
+		}
+	}
+
+	r.failUnroutableAuctions(auctiontypes.AuctionRequest{LRPs: unroutable})
+
+	var err error
+	for i := range r.shards {
+		if len(routed[i]) == 0 {
+			continue
+		}
+		shardErr := r.shards[i].runner.ScheduleLRPsForAuctions(routed[i])
+		if shardErr != nil && err == nil {
+			err = shardErr
+		}
+	}
+	return err
+}
+
+func (r *shardRouter) ScheduleTasksForAuctions(tasks []auctioneer.TaskStartRequest) error {
+	routedTasks := make([]*rep.Task, len(tasks))
+	groups := make([]string, len(tasks))
+	for i := range tasks {
+		routedTasks[i] = &tasks[i].Task
+		groups[i] = r.taskGroupOf(&tasks[i].Task)
+	}
+	shards := r.routeTasks(routedTasks, groups)
+
+	routed := make([][]auctioneer.TaskStartRequest, len(r.shards))
+	unroutable := []auctiontypes.TaskAuction{}
+	for j, task := range tasks {
+		i := shards[j]
+		if i < 0 {
+			unroutable = append(unroutable, auctiontypes.NewTaskAuction(task.Task, r.clock.Now()))
+			continue
+		}
+		routed[i] = append(routed[i], task)
+	}
+
+	r.failUnroutableAuctions(auctiontypes.AuctionRequest{Tasks: unroutable})
+
+	var err error
+	for i := range r.shards {
+		if len(routed[i]) == 0 {
+			continue
+		}
+		shardErr := r.shards[i].runner.ScheduleTasksForAuctions(routed[i])
+		if shardErr != nil && err == nil {
+			err = shardErr
+		}
+	}
+	return err
+}
+
+func (r *shardRouter) failUnroutableAuctions(unroutable auctiontypes.AuctionRequest) {
+	if len(unroutable.LRPs) == 0 && len(unroutable.Tasks) == 0 {
+		return
+	}
+
+	results := auctiontypes.AuctionResults{
+		FailedLRPs:  unroutable.LRPs,
+		FailedTasks: unroutable.Tasks,
+	}
+	for i := range results.FailedLRPs {
+		auction := &results.FailedLRPs[i]
+		auction.PlacementError = auctiontypes.NewPlacementTagMismatchError(auction.PlacementTags).Error()
+	}
+	for i := range results.FailedTasks {
+		auction := &results.FailedTasks[i]
+		auction.PlacementError = auctiontypes.NewPlacementTagMismatchError(auction.PlacementTags).Error()
+	}
+
+	r.logger.Info("no-shard-can-satisfy", lager.Data{
+		"lrp-start-auctions": len(results.FailedLRPs),
+		"task-auctions":      len(results.FailedTasks),
+	})
+	r.delegate.AuctionCompleted(results)
+}
+
+func (r *shardRouter) PendingAuctions() auctiontypes.AuctionRequest {
+	pending := auctiontypes.AuctionRequest{}
+	for i := range r.shards {
+		shardPending := r.shards[i].runner.PendingAuctions()
+		pending.LRPs = append(pending.LRPs, shardPending.LRPs...)
+		pending.Tasks = append(pending.Tasks, shardPending.Tasks...)
+	}
+	return pending
+}
+
+func (r *shardRouter) CancelLRPAuctions(processGuid string, indices []int) []auctiontypes.LRPAuction {
+	cancelled := []auctiontypes.LRPAuction{}
+	for i := range r.shards {
+		cancelled = append(cancelled, r.shards[i].runner.CancelLRPAuctions(processGuid, indices)...)
+	}
+
+	r.lock.Lock()
+	for i := range cancelled {
+		delete(r.triedLRPs, cancelled[i].Identifier())
+	}
+	r.lock.Unlock()
+	return cancelled
+}
+
+func (r *shardRouter) CancelTaskAuctions(taskGuids []string) []auctiontypes.TaskAuction {
+	cancelled := []auctiontypes.TaskAuction{}
+	for i := range r.shards {
+		cancelled = append(cancelled, r.shards[i].runner.CancelTaskAuctions(taskGuids)...)
+	}
+
+	r.lock.Lock()
+	for i := range cancelled {
+		delete(r.triedTasks, cancelled[i].Identifier())
+	}
+	r.lock.Unlock()
+	return cancelled
+}
+
+// shardCompleted reports the results of a shard, except for the auctions that
+// failed for lack of resources and can be retried on another shard.
+func (r *shardRouter) shardCompleted(shard int, results auctiontypes.AuctionResults) {
+	reported := auctiontypes.AuctionResults{
+		SuccessfulLRPs:  results.SuccessfulLRPs,
+		SuccessfulTasks: results.SuccessfulTasks,
+	}
+	retried := make([]auctiontypes.AuctionResults, len(r.shards))
+
+	r.lock.Lock()
+	for i := range results.SuccessfulLRPs {
+		delete(r.triedLRPs, results.SuccessfulLRPs[i].Identifier())
+	}
+	for i := range results.SuccessfulTasks {
+		delete(r.triedTasks, results.SuccessfulTasks[i].Identifier())
+	}
+	for _, auction := range results.FailedLRPs {
+		id := auction.Identifier()
+		tried := append(r.triedLRPs[id], shard)
+		next := r.retryShard(auction.PlacementConstraint, auction.PlacementError, tried)
+		if next < 0 {
+			delete(r.triedLRPs, id)
+			reported.FailedLRPs = append(reported.FailedLRPs, auction)
+			continue
+		}
+		r.triedLRPs[id] = tried
+		retried[next].FailedLRPs = append(retried[next].FailedLRPs, auction)
+	}
+	for _, unit := range taskAdmissionUnits(results.FailedTasks) {
+		tried := append(append([]int{}, r.triedTasks[unit[0].Identifier()]...), shard)
+		next := r.retryTaskShard(unit, tried)
+		for _, auction := range unit {
+			id := auction.Identifier()
+			if next < 0 {
+				delete(r.triedTasks, id)
+				reported.FailedTasks = append(reported.FailedTasks, auction)
+				continue
+			}
+			r.triedTasks[id] = tried
+			retried[next].FailedTasks = append(retried[next].FailedTasks, auction)
+		}
+	}
+	r.lock.Unlock()
+
+	retrying := false
+	for i := range retried {
+		failed := r.retry(i, retried[i])
+		retrying = retrying || len(failed.FailedLRPs) < len(retried[i].FailedLRPs) || len(failed.FailedTasks) < len(retried[i].FailedTasks)
+		reported.FailedLRPs = append(reported.FailedLRPs, failed.FailedLRPs...)
+		reported.FailedTasks = append(reported.FailedTasks, failed.FailedTasks...)
+	}
+
+	if retrying && len(reported.SuccessfulLRPs) == 0 && len(reported.SuccessfulTasks) == 0 &&
+		len(reported.FailedLRPs) == 0 && len(reported.FailedTasks) == 0 {
+		return
+	}
+	r.delegate.AuctionCompleted(reported)
+}
+
+// retryShard returns the first shard that can satisfy the constraint and has
+// not been tried yet, or -1 when there is none or the auction did not fail for
+// lack of resources.
+func (r *shardRouter) retryShard(constraint rep.PlacementConstraint, placementError string, tried []int) int {
+	if !strings.HasPrefix(placementError, rep.InsufficientResourcesError{}.Error()) {
+		return -1
+	}
+	return untriedShard(r.candidates(constraint), tried)
+}
+
+// retryTaskShard is retryShard for the auctions of a task group, or a single
+// task, which are retried together on a shard that can satisfy all of them.
+func (r *shardRouter) retryTaskShard(auctions []auctiontypes.TaskAuction, tried []int) int {
+	constraints := make([]rep.PlacementConstraint, 0, len(auctions))
+	for i := range auctions {
+		if !strings.HasPrefix(auctions[i].PlacementError, rep.InsufficientResourcesError{}.Error()) {
+			return -1
+		}
+		constraints = append(constraints, auctions[i].PlacementConstraint)
+	}
+	return untriedShard(r.candidates(constraints...), tried)
+}
+
+// untriedShard returns the first of the candidates that is not in tried, or
+// -1 when there is none.
+func untriedShard(candidates []int, tried []int) int {
+	for _, i := range candidates {
+		untried := true
+		for _, j := range tried {
+			if i == j {
+				untried = false
+				break
+			}
+		}
+		if untried {
+			return i
+		}
+	}
+	return -1
+}
+
+// retry hands the failed auctions to the shard, keeping their AuctionRecords,
+// and returns them again when the shard would not take them at all.
+func (r *shardRouter) retry(shard int, failed auctiontypes.AuctionResults) auctiontypes.AuctionResults {
+	if len(failed.FailedLRPs) == 0 && len(failed.FailedTasks) == 0 {
+		return auctiontypes.AuctionResults{}
+	}
+
+	pending := auctiontypes.AuctionRequest{
+		LRPs:  make([]auctiontypes.LRPAuction, len(failed.FailedLRPs)),
+		Tasks: make([]auctiontypes.TaskAuction, len(failed.FailedTasks)),
+	}
+	for i := range failed.FailedLRPs {
+		pending.LRPs[i] = failed.FailedLRPs[i]
+		pending.LRPs[i].PlacementError = ""
+	}
+	for i := range failed.FailedTasks {
+		pending.Tasks[i] = failed.FailedTasks[i]
+		pending.Tasks[i].PlacementError = ""
+	}
+
+	r.logger.Info("retrying-on-another-shard", lager.Data{
+		"shard":              shard,
+		"lrp-start-auctions": len(pending.LRPs),
+		"task-auctions":      len(pending.Tasks),
+	})
+
+	payload, err := json.Marshal(pending)
+	if err == nil {
+		err = r.shards[shard].runner.ImportPendingAuctions(payload)
+	}
+	// the shard reports the auctions it turned away for want of room itself
+	if err == nil || err == auctiontypes.ErrorBatchFull {
+		return auctiontypes.AuctionResults{}
+	}
+
+	r.logger.Error("failed-to-retry-on-another-shard", err, lager.Data{"shard": shard})
+	r.lock.Lock()
+	for i := range failed.FailedLRPs {
+		delete(r.triedLRPs, failed.FailedLRPs[i].Identifier())
+	}
+	for i := range failed.FailedTasks {
+		delete(r.triedTasks, failed.FailedTasks[i].Identifier())
+	}
+	r.lock.Unlock()
+	return failed
+}
+
+// ExportPendingAuctions serializes the pending auctions of every shard as a
+// single payload, which any auction runner can import.
+func (r *shardRouter) ExportPendingAuctions() ([]byte, error) {
+	return json.Marshal(r.PendingAuctions())
+}
+
+// ImportPendingAuctions routes imported auctions the same way new ones are
+// routed, keeping their AuctionRecords and task groups.
+func (r *shardRouter) ImportPendingAuctions(payload []byte) error {
+	var pending auctiontypes.AuctionRequest
+	err := json.Unmarshal(payload, &pending)
+	if err != nil {
+		r.logger.Error("failed-to-import-pending-auctions", err)
+		return err
+	}
+
+	routed := make([]auctiontypes.AuctionRequest, len(r.shards))
+	unroutable := auctiontypes.AuctionRequest{}
+	for _, auction := range pending.LRPs {
+		i := r.routeInstance(auction.PlacementConstraint, auction.ProcessGuid, auction.Index)
+		if i < 0 {
+			unroutable.LRPs = append(unroutable.LRPs, auction)
+			continue
+		}
+		routed[i].LRPs = append(routed[i].LRPs, auction)
+	}
+	routedTasks := make([]*rep.Task, len(pending.Tasks))
+	groups := make([]string, len(pending.Tasks))
+	for i := range pending.Tasks {
+		routedTasks[i] = &pending.Tasks[i].Task
+		groups[i] = pending.Tasks[i].TaskGroup
+		if groups[i] == "" {
+			groups[i] = r.taskGroupOf(&pending.Tasks[i].Task)
+		}
+	}
+	shards := r.routeTasks(routedTasks, groups)
+
+	for j, auction := range pending.Tasks {
+		i := shards[j]
+		if i < 0 {
+			unroutable.Tasks = append(unroutable.Tasks, auction)
+			continue
+		}
+		routed[i].Tasks = append(routed[i].Tasks, auction)
+	}
+
+	r.failUnroutableAuctions(unroutable)
+
+	for i := range r.shards {
+		if len(routed[i].LRPs) == 0 && len(routed[i].Tasks) == 0 {
+			continue
+		}
+		shardPayload, shardErr := json.Marshal(routed[i])
+		if shardErr == nil {
+			shardErr = r.shards[i].runner.ImportPendingAuctions(shardPayload)
+		}
+		if shardErr != nil && err == nil {
+			err = shardErr
+		}
+	}
+	return err
+}
@@ -0,0 +1,349 @@
+package auctionrunner_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"code.cloudfoundry.org/auction/auctionrunner"
+	"code.cloudfoundry.org/auction/auctiontypes"
+	"code.cloudfoundry.org/auction/auctiontypes/fakes"
+	"code.cloudfoundry.org/auctioneer"
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/rep"
+	"github.com/tedsuo/ifrit"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ShardRouter", func() {
+	var (
+		clock          *fakeclock.FakeClock
+		delegate       *fakes.FakeAuctionRunnerDelegate
+		untaggedShard  *fakes.FakeAuctionRunner
+		gpuShard       *fakes.FakeAuctionRunner
+		partitions     []auctionrunner.CellPartition
+		shardDelegates []auctiontypes.AuctionRunnerDelegate
+		extraShards    []*fakes.FakeAuctionRunner
+		routerOptions  []auctionrunner.ShardRouterOption
+		router         auctiontypes.AuctionRunner
+	)
+
+	BeforeEach(func() {
+		clock = fakeclock.NewFakeClock(time.Now())
+		delegate = &fakes.FakeAuctionRunnerDelegate{}
+		untaggedShard = &fakes.FakeAuctionRunner{}
+		gpuShard = &fakes.FakeAuctionRunner{}
+		shardDelegates = nil
+		extraShards = nil
+		routerOptions = nil
+
+		partitions = []auctionrunner.CellPartition{
+			auctionrunner.NewPlacementTagPartition(nil, nil),
+			auctionrunner.NewPlacementTagPartition([]string{"gpu"}, nil),
+		}
+	})
+
+	JustBeforeEach(func() {
+		shards := append([]*fakes.FakeAuctionRunner{untaggedShard, gpuShard}, extraShards...)
+		router = auctionrunner.NewShardRouter(logger, delegate, clock, partitions,
+			func(partition auctionrunner.CellPartition, shardDelegate auctiontypes.AuctionRunnerDelegate) auctiontypes.AuctionRunner {
+				shardDelegates = append(shardDelegates, shardDelegate)
+				return shards[len(shardDelegates)-1]
+			},
+			routerOptions...,
+		)
+	})
+
+	Describe("scheduling LRPs", func() {
+		It("sends each start to the shard that can satisfy its constraint", func() {
+			err := router.ScheduleLRPsForAuctions([]auctioneer.LRPStartRequest{
+				BuildLRPStartRequest("pg-1", "domain", []int{0}, linuxRootFSURL, 10, 10, 10, []string{}, []string{}),
+				BuildLRPStartRequest("pg-2", "domain", []int{0, 1}, linuxRootFSURL, 10, 10, 10, []string{}, []string{"gpu"}),
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(untaggedShard.ScheduleLRPsForAuctionsCallCount()).To(Equal(1))
+			starts := untaggedShard.ScheduleLRPsForAuctionsArgsForCall(0)
+			Expect(starts).To(HaveLen(1))
+			Expect(starts[0].ProcessGuid).To(Equal("pg-1"))
+
+			Expect(gpuShard.ScheduleLRPsForAuctionsCallCount()).To(Equal(1))
+			starts = gpuShard.ScheduleLRPsForAuctionsArgsForCall(0)
+			Expect(starts).To(HaveLen(1))
+			Expect(starts[0].ProcessGuid).To(Equal("pg-2"))
+		})
+
+		It("fails the starts no shard can satisfy", func() {
+			err := router.ScheduleLRPsForAuctions([]auctioneer.LRPStartRequest{
+				BuildLRPStartRequest("pg-1", "domain", []int{0, 1}, linuxRootFSURL, 10, 10, 10, []string{}, []string{"fpga"}),
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(untaggedShard.ScheduleLRPsForAuctionsCallCount()).To(Equal(0))
+			Expect(gpuShard.ScheduleLRPsForAuctionsCallCount()).To(Equal(0))
+
+			Expect(delegate.AuctionCompletedCallCount()).To(Equal(1))
+			results := delegate.AuctionCompletedArgsForCall(0)
+			Expect(results.FailedLRPs).To(HaveLen(2))
+			Expect(results.FailedLRPs[0].Identifier()).To(Equal("pg-1.0"))
+			Expect(results.FailedLRPs[1].Identifier()).To(Equal("pg-1.1"))
+			Expect(results.FailedLRPs[0].PlacementError).To(Equal(auctiontypes.NewPlacementTagMismatchError([]string{"fpga"}).Error()))
+			Expect(results.FailedLRPs[0].QueueTime).To(Equal(clock.Now()))
+		})
+
+		It("returns the error of a shard", func() {
+			gpuShard.ScheduleLRPsForAuctionsReturns(auctiontypes.ErrorBatchFull)
+
+			err := router.ScheduleLRPsForAuctions([]auctioneer.LRPStartRequest{
+				BuildLRPStartRequest("pg-1", "domain", []int{0}, linuxRootFSURL, 10, 10, 10, []string{}, []string{}),
+				BuildLRPStartRequest("pg-2", "domain", []int{0}, linuxRootFSURL, 10, 10, 10, []string{}, []string{"gpu"}),
+			})
+			Expect(err).To(Equal(auctiontypes.ErrorBatchFull))
+			Expect(untaggedShard.ScheduleLRPsForAuctionsCallCount()).To(Equal(1))
+		})
+	})
+
+	Describe("scheduling tasks", func() {
+		It("sends each task to the shard that can satisfy its constraint", func() {
+			gpuTask := auctioneer.NewTaskStartRequest(*BuildTask("tg-2", "domain", linuxRootFSURL, 10, 10, 10, []string{}, []string{"gpu"}))
+			err := router.ScheduleTasksForAuctions([]auctioneer.TaskStartRequest{
+				BuildTaskStartRequest("tg-1", "domain", linuxRootFSURL, 10, 10, 10),
+				gpuTask,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(untaggedShard.ScheduleTasksForAuctionsCallCount()).To(Equal(1))
+			Expect(untaggedShard.ScheduleTasksForAuctionsArgsForCall(0)[0].TaskGuid).To(Equal("tg-1"))
+			Expect(gpuShard.ScheduleTasksForAuctionsCallCount()).To(Equal(1))
+			Expect(gpuShard.ScheduleTasksForAuctionsArgsForCall(0)).To(Equal([]auctioneer.TaskStartRequest{gpuTask}))
+		})
+	})
+
+	Describe("merging results", func() {
+		It("hands every shard a delegate that reports to the router's delegate", func() {
+			Expect(shardDelegates).To(HaveLen(2))
+
+			results := auctiontypes.AuctionResults{SuccessfulTasks: []auctiontypes.TaskAuction{BuildTaskAuction(BuildTask("tg-1", "domain", linuxRootFSURL, 10, 10, 10, nil, nil), clock.Now())}}
+			shardDelegates[0].AuctionCompleted(results)
+			shardDelegates[1].AuctionCompleted(auctiontypes.AuctionResults{})
+
+			Expect(delegate.AuctionCompletedCallCount()).To(Equal(2))
+			Expect(delegate.AuctionCompletedArgsForCall(0)).To(Equal(results))
+		})
+
+		It("merges the pending and cancelled auctions of every shard", func() {
+			untaggedShard.PendingAuctionsReturns(auctiontypes.AuctionRequest{
+				LRPs: BuildLRPAuctions(BuildLRPStartRequest("pg-1", "domain", []int{0}, linuxRootFSURL, 10, 10, 10, []string{}, []string{}), clock.Now()),
+			})
+			gpuShard.PendingAuctionsReturns(auctiontypes.AuctionRequest{
+				Tasks: []auctiontypes.TaskAuction{BuildTaskAuction(BuildTask("tg-1", "domain", linuxRootFSURL, 10, 10, 10, nil, []string{"gpu"}), clock.Now())},
+			})
+			pending := router.PendingAuctions()
+			Expect(pending.LRPs).To(HaveLen(1))
+			Expect(pending.Tasks).To(HaveLen(1))
+
+			gpuShard.CancelTaskAuctionsReturns(pending.Tasks)
+			Expect(router.CancelTaskAuctions([]string{"tg-1"})).To(Equal(pending.Tasks))
+			Expect(untaggedShard.CancelTaskAuctionsCallCount()).To(Equal(1))
+			Expect(untaggedShard.CancelTaskAuctionsArgsForCall(0)).To(Equal([]string{"tg-1"}))
+		})
+	})
+
+	Context("when several shards can satisfy a constraint", func() {
+		var zone1Shard, zone2Shard *fakes.FakeAuctionRunner
+
+		BeforeEach(func() {
+			zone1Shard, zone2Shard = untaggedShard, gpuShard
+			partitions = []auctionrunner.CellPartition{
+				auctionrunner.NewZonePartition("z1"),
+				auctionrunner.NewZonePartition("z2"),
+			}
+		})
+
+		importedLRPs := func(shard *fakes.FakeAuctionRunner, call int) []auctiontypes.LRPAuction {
+			var imported auctiontypes.AuctionRequest
+			Expect(json.Unmarshal(shard.ImportPendingAuctionsArgsForCall(call), &imported)).To(Succeed())
+			return imported.LRPs
+		}
+
+		It("spreads the instances of a process over the shards", func() {
+			err := router.ScheduleLRPsForAuctions([]auctioneer.LRPStartRequest{
+				BuildLRPStartRequest("pg-1", "domain", []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, linuxRootFSURL, 10, 10, 10, []string{}, []string{}),
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(zone1Shard.ScheduleLRPsForAuctionsCallCount()).To(Equal(1))
+			Expect(zone2Shard.ScheduleLRPsForAuctionsCallCount()).To(Equal(1))
+			zone1Indices := zone1Shard.ScheduleLRPsForAuctionsArgsForCall(0)[0].Indices
+			zone2Indices := zone2Shard.ScheduleLRPsForAuctionsArgsForCall(0)[0].Indices
+			Expect(zone1Indices).NotTo(BeEmpty())
+			Expect(zone2Indices).NotTo(BeEmpty())
+			Expect(append(zone1Indices, zone2Indices...)).To(ConsistOf(0, 1, 2, 3, 4, 5, 6, 7, 8, 9))
+		})
+
+		Context("when there are three zones", func() {
+			var zone3Shard *fakes.FakeAuctionRunner
+
+			BeforeEach(func() {
+				zone3Shard = &fakes.FakeAuctionRunner{}
+				extraShards = append(extraShards, zone3Shard)
+				partitions = append(partitions, auctionrunner.NewZonePartition("z3"))
+			})
+
+			It("balances the instances of every process over the shards", func() {
+				starts := []auctioneer.LRPStartRequest{}
+				for i := 0; i < 20; i++ {
+					starts = append(starts, BuildLRPStartRequest(fmt.Sprintf("pg-%d", i), "domain", []int{0, 1, 2}, linuxRootFSURL, 10, 10, 10, []string{}, []string{}))
+				}
+				Expect(router.ScheduleLRPsForAuctions(starts)).To(Succeed())
+
+				for _, shard := range []*fakes.FakeAuctionRunner{zone1Shard, zone2Shard, zone3Shard} {
+					Expect(shard.ScheduleLRPsForAuctionsCallCount()).To(Equal(1))
+					shardStarts := shard.ScheduleLRPsForAuctionsArgsForCall(0)
+					Expect(shardStarts).To(HaveLen(20))
+					for _, start := range shardStarts {
+						Expect(start.Indices).To(HaveLen(1))
+					}
+				}
+			})
+		})
+
+		Context("when routing task groups", func() {
+			var groupedTasks []auctioneer.TaskStartRequest
+
+			BeforeEach(func() {
+				routerOptions = append(routerOptions, auctionrunner.WithShardTaskGroups(func(task *rep.Task) string { return task.Domain }))
+
+				groupedTasks = nil
+				for i := 0; i < 10; i++ {
+					groupedTasks = append(groupedTasks, BuildTaskStartRequest(fmt.Sprintf("tg-%d", i), "workflow", linuxRootFSURL, 10, 10, 10))
+				}
+			})
+
+			It("sends every task of a group to the same shard", func() {
+				Expect(router.ScheduleTasksForAuctions(groupedTasks)).To(Succeed())
+
+				Expect(zone1Shard.ScheduleTasksForAuctionsCallCount() + zone2Shard.ScheduleTasksForAuctionsCallCount()).To(Equal(1))
+				shard := zone1Shard
+				if shard.ScheduleTasksForAuctionsCallCount() == 0 {
+					shard = zone2Shard
+				}
+				Expect(shard.ScheduleTasksForAuctionsArgsForCall(0)).To(Equal(groupedTasks))
+			})
+
+			It("imports every task of a group into the same shard", func() {
+				pending := auctiontypes.AuctionRequest{}
+				for i := range groupedTasks {
+					auction := auctiontypes.NewTaskAuction(groupedTasks[i].Task, clock.Now())
+					auction.TaskGroup = "workflow"
+					pending.Tasks = append(pending.Tasks, auction)
+				}
+				payload, err := json.Marshal(pending)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(router.ImportPendingAuctions(payload)).To(Succeed())
+				Expect(zone1Shard.ImportPendingAuctionsCallCount() + zone2Shard.ImportPendingAuctionsCallCount()).To(Equal(1))
+			})
+
+			It("retries the tasks of a group together", func() {
+				failed := []auctiontypes.TaskAuction{}
+				for i := range groupedTasks[:2] {
+					auction := auctiontypes.NewTaskAuction(groupedTasks[i].Task, clock.Now())
+					auction.TaskGroup = "workflow"
+					auction.PlacementError = "insufficient resources: memory"
+					failed = append(failed, auction)
+				}
+				shardDelegates[0].AuctionCompleted(auctiontypes.AuctionResults{FailedTasks: failed})
+
+				Expect(delegate.AuctionCompletedCallCount()).To(Equal(0))
+				Expect(zone2Shard.ImportPendingAuctionsCallCount()).To(Equal(1))
+				var imported auctiontypes.AuctionRequest
+				Expect(json.Unmarshal(zone2Shard.ImportPendingAuctionsArgsForCall(0), &imported)).To(Succeed())
+				Expect(imported.Tasks).To(HaveLen(2))
+				Expect(imported.Tasks[0].TaskGroup).To(Equal("workflow"))
+			})
+		})
+
+		It("retries an auction that lacked resources on another shard", func() {
+			failed := BuildLRPAuctionWithPlacementError("pg-1", "domain", 0, linuxRootFSURL, 10, 10, 10, clock.Now(), "insufficient resources: memory", []string{}, []string{})
+			failed.Attempts = 1
+			shardDelegates[0].AuctionCompleted(auctiontypes.AuctionResults{FailedLRPs: []auctiontypes.LRPAuction{failed}})
+
+			Expect(delegate.AuctionCompletedCallCount()).To(Equal(0))
+			Expect(zone1Shard.ImportPendingAuctionsCallCount()).To(Equal(0))
+			Expect(zone2Shard.ImportPendingAuctionsCallCount()).To(Equal(1))
+			retried := importedLRPs(zone2Shard, 0)
+			Expect(retried).To(HaveLen(1))
+			Expect(retried[0].Identifier()).To(Equal("pg-1.0"))
+			Expect(retried[0].Attempts).To(Equal(1))
+			Expect(retried[0].PlacementError).To(BeEmpty())
+
+			failed.Attempts = 2
+			shardDelegates[1].AuctionCompleted(auctiontypes.AuctionResults{FailedLRPs: []auctiontypes.LRPAuction{failed}})
+
+			Expect(zone1Shard.ImportPendingAuctionsCallCount()).To(Equal(0))
+			Expect(delegate.AuctionCompletedCallCount()).To(Equal(1))
+			Expect(delegate.AuctionCompletedArgsForCall(0).FailedLRPs).To(Equal([]auctiontypes.LRPAuction{failed}))
+		})
+
+		It("reports other failures without retrying them", func() {
+			failed := BuildLRPAuctionWithPlacementError("pg-1", "domain", 0, linuxRootFSURL, 10, 10, 10, clock.Now(), auctiontypes.ErrorAuctionExpired.Error(), []string{}, []string{})
+			shardDelegates[0].AuctionCompleted(auctiontypes.AuctionResults{FailedLRPs: []auctiontypes.LRPAuction{failed}})
+
+			Expect(zone2Shard.ImportPendingAuctionsCallCount()).To(Equal(0))
+			Expect(delegate.AuctionCompletedCallCount()).To(Equal(1))
+		})
+
+		It("reports the failure when the other shard is shutting down", func() {
+			zone2Shard.ImportPendingAuctionsReturns(auctiontypes.ErrorShuttingDown)
+			failed := BuildLRPAuctionWithPlacementError("pg-1", "domain", 0, linuxRootFSURL, 10, 10, 10, clock.Now(), "insufficient resources: memory", []string{}, []string{})
+			shardDelegates[0].AuctionCompleted(auctiontypes.AuctionResults{FailedLRPs: []auctiontypes.LRPAuction{failed}})
+
+			Expect(zone2Shard.ImportPendingAuctionsCallCount()).To(Equal(1))
+			Expect(delegate.AuctionCompletedCallCount()).To(Equal(1))
+			Expect(delegate.AuctionCompletedArgsForCall(0).FailedLRPs).To(Equal([]auctiontypes.LRPAuction{failed}))
+		})
+	})
+
+	Describe("importing pending auctions", func() {
+		It("routes them to the shards", func() {
+			lrpAuctions := BuildLRPAuctions(BuildLRPStartRequest("pg-1", "domain", []int{0}, linuxRootFSURL, 10, 10, 10, []string{}, []string{"gpu"}), clock.Now())
+			payload, err := json.Marshal(auctiontypes.AuctionRequest{LRPs: lrpAuctions})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(router.ImportPendingAuctions(payload)).To(Succeed())
+
+			Expect(untaggedShard.ImportPendingAuctionsCallCount()).To(Equal(0))
+			Expect(gpuShard.ImportPendingAuctionsCallCount()).To(Equal(1))
+
+			var imported auctiontypes.AuctionRequest
+			Expect(json.Unmarshal(gpuShard.ImportPendingAuctionsArgsForCall(0), &imported)).To(Succeed())
+			Expect(imported.LRPs).To(HaveLen(1))
+			Expect(imported.LRPs[0].Identifier()).To(Equal("pg-1.0"))
+		})
+	})
+
+	Describe("running", func() {
+		BeforeEach(func() {
+			for _, shard := range []*fakes.FakeAuctionRunner{untaggedShard, gpuShard} {
+				shard.RunStub = func(signals <-chan os.Signal, ready chan<- struct{}) error {
+					close(ready)
+					<-signals
+					return nil
+				}
+			}
+		})
+
+		It("runs every shard until it is signalled", func() {
+			process := ifrit.Invoke(router)
+			Expect(untaggedShard.RunCallCount()).To(Equal(1))
+			Expect(gpuShard.RunCallCount()).To(Equal(1))
+
+			process.Signal(os.Interrupt)
+			Eventually(process.Wait()).Should(Receive(BeNil()))
+		})
+	})
+})
@@ -0,0 +1,137 @@
+package auctionrunner
+
+import (
+	"code.cloudfoundry.org/auction/auctiontypes"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/rep"
+)
+
+// splitTasks returns the task auctions outside any task group, and the
+// auctions of each task group, which point into taskAuctions.
+func splitTasks(taskAuctions []auctiontypes.TaskAuction) ([]auctiontypes.TaskAuction, [][]*auctiontypes.TaskAuction) {
+	pointers := make([]*auctiontypes.TaskAuction, len(taskAuctions))
+	for i := range taskAuctions {
+		pointers[i] = &taskAuctions[i]
+	}
+
+	ungrouped, groups := splitTaskGroups(pointers)
+	if len(groups) == 0 {
+		return taskAuctions, nil
+	}
+
+	tasks := make([]auctiontypes.TaskAuction, len(ungrouped))
+	for i, taskAuction := range ungrouped {
+		tasks[i] = *taskAuction
+	}
+	return tasks, groups
+}
+
+// splitTaskGroups returns the task auctions outside any task group, and the
+// auctions of each task group in the order the groups first appear.
+func splitTaskGroups(taskAuctions []*auctiontypes.TaskAuction) ([]*auctiontypes.TaskAuction, [][]*auctiontypes.TaskAuction) {
+	var ungrouped []*auctiontypes.TaskAuction
+	var groups [][]*auctiontypes.TaskAuction
+	groupIndices := map[string]int{}
+
+	for _, taskAuction := range taskAuctions {
+		if taskAuction.TaskGroup == "" {
+			ungrouped = append(ungrouped, taskAuction)
+			continue
+		}
+
+		i, ok := groupIndices[taskAuction.TaskGroup]
+		if !ok {
+			i = len(groups)
+			groupIndices[taskAuction.TaskGroup] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], taskAuction)
+	}
+
+	return ungrouped, groups
+}
+
+/*
+scheduleTaskGroup places all the tasks of a task group on the one cell that
+scores best for their summed resources, among the cells that match every one
+of them, or none of them.  The zones are balanced by the grouping key of the
+first task.
+*/
+func (s *Scheduler) scheduleTaskGroup(taskAuctions []*auctiontypes.TaskAuction) ([]*auctiontypes.TaskAuction, error) {
+	groupingKey := s.groupingKey(taskAuctions[0])
+	filteredZones, err := s.filterTaskZones(taskAuctions, groupingKey)
+	if err != nil {
+		return nil, err
+	}
+
+	tasks := make([]*rep.Task, len(taskAuctions))
+	taskGuids := make([]string, len(taskAuctions))
+	var extraProblems []string
+	for i, taskAuction := range taskAuctions {
+		tasks[i] = &taskAuction.Task
+		taskGuids[i] = taskAuction.Identifier()
+		extraProblems = append(extraProblems, s.extraProblems(&taskAuction.PlacementConstraint)...)
+	}
+	logData := lager.Data{"task-group": taskAuctions[0].TaskGroup, "task-guids": taskGuids}
+
+	scores := newCellScores(extraProblems...)
+	scoreForTaskGroup := func(cell *Cell) (float64, error) {
+		return cell.scoreForTaskGroup(tasks, s.taskStrategy == PackTasks, s.startingContainerWeight, s.binPackFirstFitWeight, s.resourceWeights)
+	}
+
+	winnerZone := s.scoreSortedZones(sortZonesByInstances(filteredZones), &scores, scoreForTaskGroup)
+
+	winnerCell := scores.winner
+	if winnerCell == nil {
+		for _, zone := range filteredZones {
+			s.scoreCells(zone.exhausted, &scores, scoreForTaskGroup)
+		}
+
+		err := &rep.InsufficientResourcesError{Problems: scores.problems}
+		s.logger.Error("task-group-auction-failed", err, logData)
+		return nil, err
+	}
+
+	err = winnerCell.ReserveTasks(tasks)
+	if err != nil {
+		logData["cell-guid"] = winnerCell.Guid
+		s.logger.Error("task-group-failed-to-reserve-cell", err, logData)
+		return nil, err
+	}
+
+	for _, taskAuction := range taskAuctions {
+		groupingKey := s.groupingKey(taskAuction)
+		winnerCell.addToTaskGroup(groupingKey, 1)
+		s.zoneTasks.addTask(winnerZone, groupingKey)
+	}
+
+	if winnerCell.exhausted() {
+		s.filters.invalidate()
+	}
+
+	winningAuctions := make([]*auctiontypes.TaskAuction, len(taskAuctions))
+	for i, taskAuction := range taskAuctions {
+		winningAuction := taskAuction.Copy()
+		winningAuction.Winner = winnerCell.Guid
+		winningAuction.Overcommitted = winnerCell.overcommitted()
+		winningAuctions[i] = &winningAuction
+	}
+	return winningAuctions, nil
+}
+
+// intersectCells returns the cells of a that are also in b, in their order in
+// a.
+func intersectCells(a, b []*Cell) []*Cell {
+	inB := make(map[*Cell]bool, len(b))
+	for _, cell := range b {
+		inB[cell] = true
+	}
+
+	cells := []*Cell{}
+	for _, cell := range a {
+		if inB[cell] {
+			cells = append(cells, cell)
+		}
+	}
+	return cells
+}
@@ -0,0 +1,90 @@
+package auctionrunner
+
+import (
+	"code.cloudfoundry.org/auction/auctiontypes"
+	"code.cloudfoundry.org/rep"
+)
+
+// TaskPlacementStrategy is how the scheduler picks cells for tasks, which is
+// independent of how it picks them for LRPs.
+type TaskPlacementStrategy int
+
+const (
+	// SpreadTasks favors the cells running the fewest tasks, then the emptiest
+	// ones.
+	SpreadTasks TaskPlacementStrategy = iota
+	// PackTasks favors the fullest cells, and the cells with the lowest index
+	// when a bin pack first fit weight is given, so that whole cells are left
+	// free.
+	PackTasks
+	// BalanceTasksAcrossZones spreads tasks like SpreadTasks within the zones
+	// running the fewest tasks, the way LRP instances are balanced.
+	BalanceTasksAcrossZones
+)
+
+// WithTaskPlacementStrategy places tasks with strategy instead of SpreadTasks.
+func WithTaskPlacementStrategy(strategy TaskPlacementStrategy) SchedulerOption {
+	return func(s *Scheduler) {
+		s.taskStrategy = strategy
+	}
+}
+
+// WithTaskGroupingKey balances the tasks that key gives the same grouping key
+// across zones, running tasks included.  Task auctions that carry a
+// GroupingKey, such as the ones submitted to an auction runner built
+// WithTaskGroupingKeys, keep it.
+func WithTaskGroupingKey(key func(task *rep.Task) string) SchedulerOption {
+	return func(s *Scheduler) {
+		s.taskGroupingKey = key
+	}
+}
+
+// groupingKey is the grouping key of the task auction, if it has one.
+func (s *Scheduler) groupingKey(taskAuction *auctiontypes.TaskAuction) string {
+	if taskAuction.GroupingKey != "" || s.taskGroupingKey == nil {
+		return taskAuction.GroupingKey
+	}
+	return s.taskGroupingKey(&taskAuction.Task)
+}
+
+// zoneTaskIndex counts the tasks of each grouping key in each zone, and all of
+// the tasks under the empty key, so that zones can be balanced without walking
+// the tasks of every cell.
+type zoneTaskIndex map[string]map[string]int
+
+func newZoneTaskIndex(zones map[string]Zone) zoneTaskIndex {
+	index := zoneTaskIndex{}
+	for name, zone := range zones {
+		for _, cell := range zone {
+			index.addCell(name, cell, 1)
+		}
+	}
+	return index
+}
+
+// addCell adds the tasks on the cell to the counts of the zone, or removes
+// them when sign is -1.
+func (index zoneTaskIndex) addCell(name string, cell *Cell, sign int) {
+	counts, ok := index[name]
+	if !ok {
+		counts = map[string]int{}
+		index[name] = counts
+	}
+	counts[""] += sign * len(cell.state.Tasks)
+	for key, tasks := range cell.taskGroups {
+		counts[key] += sign * tasks
+	}
+}
+
+// addTask counts a task with the grouping key placed in the zone.
+func (index zoneTaskIndex) addTask(name, key string) {
+	counts, ok := index[name]
+	if !ok {
+		counts = map[string]int{}
+		index[name] = counts
+	}
+	counts[""]++
+	if key != "" {
+		counts[key]++
+	}
+}
@@ -1,12 +1,16 @@
 package auctionrunner_test
 
 import (
+	"fmt"
 	"time"
 
+	"code.cloudfoundry.org/auction/auctionrunner"
 	"code.cloudfoundry.org/auction/auctiontypes"
 	"code.cloudfoundry.org/auctioneer"
 	"code.cloudfoundry.org/bbs/models"
+	"code.cloudfoundry.org/lager"
 	"code.cloudfoundry.org/rep"
+	"code.cloudfoundry.org/rep/repfakes"
 	. "github.com/onsi/gomega"
 )
 
@@ -177,3 +181,39 @@ func BuildCellState(
 		proxyMemoryAllocationMB,
 	)
 }
+
+// BuildFleet spreads cellCount cells over zoneCount zones.  The cells come in a
+// handful of sizes and some already run instances of "pg-0", so that scoring
+// them involves ties, locality and cells without room.
+func BuildFleet(logger lager.Logger, cellCount, zoneCount int) map[string]auctionrunner.Zone {
+	zones := map[string]auctionrunner.Zone{}
+	for i := 0; i < cellCount; i++ {
+		zone := fmt.Sprintf("zone-%d", i%zoneCount)
+		cellID := fmt.Sprintf("cell-%d", i)
+
+		lrps := []rep.LRP{}
+		if i%7 == 0 {
+			lrps = append(lrps, *BuildLRP("pg-0", "domain", i, "", 10, 10, 10, []string{}))
+		}
+
+		memoryMB := int32(100 + 50*(i%4))
+		state := BuildCellState(cellID, i, zone, memoryMB, memoryMB, 100, false, 0, linuxOnlyRootFSProviders, lrps, []string{}, []string{}, []string{}, 0)
+		zones[zone] = append(zones[zone], auctionrunner.NewCell(logger, cellID, &repfakes.FakeSimClient{}, state))
+	}
+	return zones
+}
+
+// BuildFleetAuctions returns lrpCount LRP auctions over a few processes and
+// taskCount task auctions of varying sizes.
+func BuildFleetAuctions(lrpCount, taskCount int, queueTime time.Time) auctiontypes.AuctionRequest {
+	request := auctiontypes.AuctionRequest{}
+	for i := 0; i < lrpCount; i++ {
+		size := int32(10 + 10*(i%5))
+		request.LRPs = append(request.LRPs, BuildLRPAuction(fmt.Sprintf("pg-%d", i%10), "domain", i/10, linuxRootFSURL, size, size, 10, queueTime, nil, []string{}))
+	}
+	for i := 0; i < taskCount; i++ {
+		size := int32(10 + 15*(i%3))
+		request.Tasks = append(request.Tasks, BuildTaskAuction(BuildTask(fmt.Sprintf("tg-%d", i), "domain", linuxRootFSURL, size, size, 10, []string{}, []string{}), queueTime))
+	}
+	return request
+}
@@ -0,0 +1,366 @@
+package auctionrunner
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"code.cloudfoundry.org/auction/auctiontypes"
+	"code.cloudfoundry.org/lager"
+)
+
+type walEntry struct {
+	LRP            *auctiontypes.LRPAuction  `json:"lrp,omitempty"`
+	Task           *auctiontypes.TaskAuction `json:"task,omitempty"`
+	CompletedLRPs  []string                  `json:"completed_lrps,omitempty"`
+	CompletedTasks []string                  `json:"completed_tasks,omitempty"`
+}
+
+/*
+WriteAheadLog is a file-backed journal of the auctions handed to the Batch.
+Every enqueued auction is appended before it becomes visible to the auction
+runner, and an auction is marked completed once its result has been reported to
+the delegate or it has been cancelled.  Auctions that were enqueued but never
+completed, including ones that were drained but not yet reported when the
+process stopped, are replayed with their original AuctionRecord on startup.
+Each completion cancels out a single enqueued entry, so a duplicate that is
+still pending survives the completion of the auction it duplicates.
+
+Write failures are logged but do not stop auctions from being scheduled.
+*/
+type WriteAheadLog struct {
+	logger lager.Logger
+	path   string
+
+	file         *os.File
+	pendingLRPs  map[string]int
+	pendingTasks map[string]int
+	lock         *sync.Mutex
+
+	// written and synced count the appends and truncations, so that a single
+	// fsync covers every write made before it started.
+	written  uint64
+	synced   uint64
+	syncLock *sync.Mutex
+}
+
+func OpenWriteAheadLog(logger lager.Logger, path string) (*WriteAheadLog, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return &WriteAheadLog{
+		logger:       logger.Session("write-ahead-log", lager.Data{"path": path}),
+		path:         path,
+		file:         file,
+		pendingLRPs:  map[string]int{},
+		pendingTasks: map[string]int{},
+		lock:         &sync.Mutex{},
+		syncLock:     &sync.Mutex{},
+	}, nil
+}
+
+// Replay returns the auctions that were enqueued but never completed, in the
+// order they were enqueued, and compacts the log so that it only holds them.
+// An auction enqueued more often than it was completed is replayed once, with
+// its first pending record.  A partially written final entry is ignored.
+func (w *WriteAheadLog) Replay() ([]auctiontypes.LRPAuction, []auctiontypes.TaskAuction, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	_, err := w.file.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	lrpOrder := []string{}
+	lrps := map[string][]auctiontypes.LRPAuction{}
+	taskOrder := []string{}
+	tasks := map[string][]auctiontypes.TaskAuction{}
+
+	decoder := json.NewDecoder(bufio.NewReader(w.file))
+	for {
+		var entry walEntry
+		err := decoder.Decode(&entry)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			w.logger.Error("ignoring-unreadable-entries", err)
+			break
+		}
+
+		if entry.LRP != nil {
+			id := entry.LRP.Identifier()
+			if _, ok := lrps[id]; !ok {
+				lrpOrder = append(lrpOrder, id)
+			}
+			lrps[id] = append(lrps[id], *entry.LRP)
+		}
+		if entry.Task != nil {
+			id := entry.Task.Identifier()
+			if _, ok := tasks[id]; !ok {
+				taskOrder = append(taskOrder, id)
+			}
+			tasks[id] = append(tasks[id], *entry.Task)
+		}
+		for _, id := range entry.CompletedLRPs {
+			if len(lrps[id]) > 1 {
+				lrps[id] = lrps[id][1:]
+			} else {
+				delete(lrps, id)
+			}
+		}
+		for _, id := range entry.CompletedTasks {
+			if len(tasks[id]) > 1 {
+				tasks[id] = tasks[id][1:]
+			} else {
+				delete(tasks, id)
+			}
+		}
+	}
+
+	lrpAuctions := []auctiontypes.LRPAuction{}
+	for _, id := range lrpOrder {
+		if auctions, ok := lrps[id]; ok {
+			lrpAuctions = append(lrpAuctions, auctions[0])
+			delete(lrps, id)
+		}
+	}
+
+	taskAuctions := []auctiontypes.TaskAuction{}
+	for _, id := range taskOrder {
+		if auctions, ok := tasks[id]; ok {
+			taskAuctions = append(taskAuctions, auctions[0])
+			delete(tasks, id)
+		}
+	}
+
+	err = w.compact(lrpAuctions, taskAuctions)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return lrpAuctions, taskAuctions, nil
+}
+
+func (w *WriteAheadLog) RecordLRPAuctions(auctions []auctiontypes.LRPAuction) {
+	w.writeLRPAuctions(auctions)
+	w.sync()
+}
+
+func (w *WriteAheadLog) RecordTaskAuctions(auctions []auctiontypes.TaskAuction) {
+	w.writeTaskAuctions(auctions)
+	w.sync()
+}
+
+// RecordCompleted marks the auctions as done so that they are not replayed.
+// Once nothing is pending the log is truncated.
+func (w *WriteAheadLog) RecordCompleted(lrpAuctions []auctiontypes.LRPAuction, taskAuctions []auctiontypes.TaskAuction) {
+	w.writeCompleted(lrpAuctions, taskAuctions)
+	w.sync()
+}
+
+// writeLRPAuctions appends the auctions without waiting for them to reach the
+// disk; see sync.
+func (w *WriteAheadLog) writeLRPAuctions(auctions []auctiontypes.LRPAuction) {
+	if len(auctions) == 0 {
+		return
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	entries := make([]walEntry, 0, len(auctions))
+	for i := range auctions {
+		w.pendingLRPs[auctions[i].Identifier()]++
+		entries = append(entries, walEntry{LRP: &auctions[i]})
+	}
+	w.append(entries)
+}
+
+func (w *WriteAheadLog) writeTaskAuctions(auctions []auctiontypes.TaskAuction) {
+	if len(auctions) == 0 {
+		return
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	entries := make([]walEntry, 0, len(auctions))
+	for i := range auctions {
+		w.pendingTasks[auctions[i].Identifier()]++
+		entries = append(entries, walEntry{Task: &auctions[i]})
+	}
+	w.append(entries)
+}
+
+func (w *WriteAheadLog) writeCompleted(lrpAuctions []auctiontypes.LRPAuction, taskAuctions []auctiontypes.TaskAuction) {
+	if len(lrpAuctions) == 0 && len(taskAuctions) == 0 {
+		return
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	entry := walEntry{}
+	for i := range lrpAuctions {
+		id := lrpAuctions[i].Identifier()
+		complete(w.pendingLRPs, id)
+		entry.CompletedLRPs = append(entry.CompletedLRPs, id)
+	}
+	for i := range taskAuctions {
+		id := taskAuctions[i].Identifier()
+		complete(w.pendingTasks, id)
+		entry.CompletedTasks = append(entry.CompletedTasks, id)
+	}
+
+	if len(w.pendingLRPs) == 0 && len(w.pendingTasks) == 0 {
+		err := w.file.Truncate(0)
+		if err == nil {
+			w.written++
+			return
+		}
+		w.logger.Error("failed-to-truncate", err)
+	}
+
+	w.append([]walEntry{entry})
+}
+
+// sync waits until everything written so far is on disk.  Concurrent callers
+// share a single fsync, and none of them hold the lock while it runs.
+func (w *WriteAheadLog) sync() {
+	w.lock.Lock()
+	target := w.written
+	w.lock.Unlock()
+
+	w.syncLock.Lock()
+	defer w.syncLock.Unlock()
+
+	if w.synced >= target {
+		return
+	}
+
+	w.lock.Lock()
+	written := w.written
+	file := w.file
+	w.lock.Unlock()
+
+	err := file.Sync()
+	if err != nil {
+		w.logger.Error("failed-to-sync", err)
+		return
+	}
+	w.synced = written
+}
+
+func (w *WriteAheadLog) RecordResults(results auctiontypes.AuctionResults) {
+	lrpAuctions := make([]auctiontypes.LRPAuction, 0, len(results.SuccessfulLRPs)+len(results.FailedLRPs))
+	lrpAuctions = append(lrpAuctions, results.SuccessfulLRPs...)
+	lrpAuctions = append(lrpAuctions, results.FailedLRPs...)
+
+	taskAuctions := make([]auctiontypes.TaskAuction, 0, len(results.SuccessfulTasks)+len(results.FailedTasks))
+	taskAuctions = append(taskAuctions, results.SuccessfulTasks...)
+	taskAuctions = append(taskAuctions, results.FailedTasks...)
+
+	w.RecordCompleted(lrpAuctions, taskAuctions)
+}
+
+func (w *WriteAheadLog) Close() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	return w.file.Close()
+}
+
+// append must be called with the lock held.  The entries are written but not
+// synced.
+func (w *WriteAheadLog) append(entries []walEntry) {
+	writer := bufio.NewWriter(w.file)
+	encoder := json.NewEncoder(writer)
+	for i := range entries {
+		err := encoder.Encode(&entries[i])
+		if err != nil {
+			w.logger.Error("failed-to-encode-entry", err)
+			return
+		}
+	}
+
+	err := writer.Flush()
+	if err != nil {
+		w.logger.Error("failed-to-write-entries", err)
+		return
+	}
+	w.written++
+}
+
+func complete(pending map[string]int, id string) {
+	if pending[id] > 1 {
+		pending[id]--
+	} else {
+		delete(pending, id)
+	}
+}
+
+// compact must be called with the lock held
+func (w *WriteAheadLog) compact(lrpAuctions []auctiontypes.LRPAuction, taskAuctions []auctiontypes.TaskAuction) error {
+	tmpFile, err := os.CreateTemp(filepath.Dir(w.path), filepath.Base(w.path)+".compact-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+
+	writer := bufio.NewWriter(tmpFile)
+	encoder := json.NewEncoder(writer)
+	w.pendingLRPs = map[string]int{}
+	w.pendingTasks = map[string]int{}
+
+	for i := range lrpAuctions {
+		w.pendingLRPs[lrpAuctions[i].Identifier()]++
+		err = encoder.Encode(walEntry{LRP: &lrpAuctions[i]})
+		if err != nil {
+			tmpFile.Close()
+			return err
+		}
+	}
+	for i := range taskAuctions {
+		w.pendingTasks[taskAuctions[i].Identifier()]++
+		err = encoder.Encode(walEntry{Task: &taskAuctions[i]})
+		if err != nil {
+			tmpFile.Close()
+			return err
+		}
+	}
+
+	err = writer.Flush()
+	if err == nil {
+		err = tmpFile.Sync()
+	}
+	if err != nil {
+		tmpFile.Close()
+		return err
+	}
+
+	err = tmpFile.Close()
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(tmpFile.Name(), w.path)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(w.path, os.O_RDWR|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+
+	w.file.Close()
+	w.file = file
+	return nil
+}
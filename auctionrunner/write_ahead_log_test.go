@@ -0,0 +1,171 @@
+package auctionrunner_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"code.cloudfoundry.org/auction/auctionrunner"
+	"code.cloudfoundry.org/auction/auctiontypes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("WriteAheadLog", func() {
+	var (
+		tmpDir    string
+		path      string
+		wal       *auctionrunner.WriteAheadLog
+		queueTime time.Time
+
+		lrp1, lrp2   auctiontypes.LRPAuction
+		task1, task2 auctiontypes.TaskAuction
+	)
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "write-ahead-log")
+		Expect(err).NotTo(HaveOccurred())
+		path = filepath.Join(tmpDir, "batch.wal")
+
+		wal, err = auctionrunner.OpenWriteAheadLog(logger, path)
+		Expect(err).NotTo(HaveOccurred())
+
+		queueTime = time.Unix(1000, 0).UTC()
+		lrp1 = BuildLRPAuction("pg-1", "domain", 0, linuxRootFSURL, 10, 10, 10, queueTime, []string{"driver"}, []string{"tag"})
+		lrp1.Attempts = 3
+		lrp2 = BuildLRPAuction("pg-1", "domain", 1, linuxRootFSURL, 10, 10, 10, queueTime.Add(time.Second), []string{}, []string{})
+		task1 = BuildTaskAuction(BuildTask("tg-1", "domain", linuxRootFSURL, 10, 10, 10, []string{}, []string{}), queueTime)
+		task2 = BuildTaskAuction(BuildTask("tg-2", "domain", linuxRootFSURL, 10, 10, 10, []string{}, []string{}), queueTime)
+	})
+
+	AfterEach(func() {
+		wal.Close()
+		os.RemoveAll(tmpDir)
+	})
+
+	reopen := func() {
+		Expect(wal.Close()).To(Succeed())
+
+		var err error
+		wal, err = auctionrunner.OpenWriteAheadLog(logger, path)
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	It("replays nothing when the log is new", func() {
+		lrpAuctions, taskAuctions, err := wal.Replay()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(lrpAuctions).To(BeEmpty())
+		Expect(taskAuctions).To(BeEmpty())
+	})
+
+	It("replays recorded auctions with their original records", func() {
+		wal.RecordLRPAuctions([]auctiontypes.LRPAuction{lrp1, lrp2})
+		wal.RecordTaskAuctions([]auctiontypes.TaskAuction{task1})
+		reopen()
+
+		lrpAuctions, taskAuctions, err := wal.Replay()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(lrpAuctions).To(Equal([]auctiontypes.LRPAuction{lrp1, lrp2}))
+		Expect(taskAuctions).To(Equal([]auctiontypes.TaskAuction{task1}))
+	})
+
+	It("does not replay completed auctions", func() {
+		wal.RecordLRPAuctions([]auctiontypes.LRPAuction{lrp1, lrp2})
+		wal.RecordTaskAuctions([]auctiontypes.TaskAuction{task1, task2})
+		wal.RecordResults(auctiontypes.AuctionResults{
+			SuccessfulLRPs: []auctiontypes.LRPAuction{lrp1},
+			FailedTasks:    []auctiontypes.TaskAuction{task2},
+		})
+		reopen()
+
+		lrpAuctions, taskAuctions, err := wal.Replay()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(lrpAuctions).To(Equal([]auctiontypes.LRPAuction{lrp2}))
+		Expect(taskAuctions).To(Equal([]auctiontypes.TaskAuction{task1}))
+	})
+
+	It("keeps the first record of an auction that was enqueued twice", func() {
+		resubmitted := lrp1
+		resubmitted.QueueTime = queueTime.Add(time.Minute)
+		wal.RecordLRPAuctions([]auctiontypes.LRPAuction{lrp1})
+		wal.RecordLRPAuctions([]auctiontypes.LRPAuction{resubmitted})
+		reopen()
+
+		lrpAuctions, _, err := wal.Replay()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(lrpAuctions).To(Equal([]auctiontypes.LRPAuction{lrp1}))
+	})
+
+	It("keeps replaying an auction that was enqueued twice until both are completed", func() {
+		wal.RecordLRPAuctions([]auctiontypes.LRPAuction{lrp1})
+		wal.RecordLRPAuctions([]auctiontypes.LRPAuction{lrp1})
+		wal.RecordCompleted([]auctiontypes.LRPAuction{lrp1}, nil)
+		reopen()
+
+		lrpAuctions, _, err := wal.Replay()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(lrpAuctions).To(Equal([]auctiontypes.LRPAuction{lrp1}))
+
+		wal.RecordCompleted([]auctiontypes.LRPAuction{lrp1}, nil)
+		reopen()
+
+		lrpAuctions, _, err = wal.Replay()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(lrpAuctions).To(BeEmpty())
+	})
+
+	It("compacts the log down to the pending auctions when replaying", func() {
+		wal.RecordLRPAuctions([]auctiontypes.LRPAuction{lrp1, lrp2})
+		wal.RecordCompleted([]auctiontypes.LRPAuction{lrp1}, nil)
+		_, _, err := wal.Replay()
+		Expect(err).NotTo(HaveOccurred())
+
+		contents, err := ioutil.ReadFile(path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(contents)).NotTo(ContainSubstring("completed_lrps"))
+		Expect(string(contents)).NotTo(ContainSubstring(`"index":0`))
+
+		wal.RecordTaskAuctions([]auctiontypes.TaskAuction{task1})
+		reopen()
+		lrpAuctions, taskAuctions, err := wal.Replay()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(lrpAuctions).To(Equal([]auctiontypes.LRPAuction{lrp2}))
+		Expect(taskAuctions).To(Equal([]auctiontypes.TaskAuction{task1}))
+	})
+
+	It("truncates the log once nothing is pending", func() {
+		wal.RecordLRPAuctions([]auctiontypes.LRPAuction{lrp1})
+		wal.RecordTaskAuctions([]auctiontypes.TaskAuction{task1})
+		wal.RecordCompleted([]auctiontypes.LRPAuction{lrp1}, []auctiontypes.TaskAuction{task1})
+
+		info, err := os.Stat(path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info.Size()).To(BeZero())
+
+		wal.RecordTaskAuctions([]auctiontypes.TaskAuction{task2})
+		reopen()
+		_, taskAuctions, err := wal.Replay()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(taskAuctions).To(Equal([]auctiontypes.TaskAuction{task2}))
+	})
+
+	It("ignores a partially written final entry", func() {
+		wal.RecordTaskAuctions([]auctiontypes.TaskAuction{task1})
+		Expect(wal.Close()).To(Succeed())
+
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = file.WriteString(`{"task":{"TaskGuid":"tg-`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(file.Close()).To(Succeed())
+
+		wal, err = auctionrunner.OpenWriteAheadLog(logger, path)
+		Expect(err).NotTo(HaveOccurred())
+		_, taskAuctions, err := wal.Replay()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(taskAuctions).To(Equal([]auctiontypes.TaskAuction{task1}))
+	})
+})
@@ -40,30 +40,17 @@ func fetchStateAndBuildZones(logger lager.Logger, workPool *workpool.WorkPool, c
 		workPool.Submit(func() {
 			defer wg.Done()
 
-			startTime := time.Now()
-			state, err := client.State(logger)
-			if err != nil {
-				metricEmitter.FailedCellStateRequest()
-				logger.Error("failed-to-get-state", err, lager.Data{"cell-guid": guid, "duration_ns": time.Since(startTime)})
-				return
-			}
-
-			if state.Evacuating {
-				logger.Info("ignored-evacuating-cell", lager.Data{"cell-guid": guid, "duration_ns": time.Since(startTime)})
-				return
-			}
-
-			if state.CellID != "" && state.CellID != guid {
-				logger.Error("cell-id-mismatch", nil, lager.Data{"cell-guid": guid, "cell-state-guid": state.CellID, "duration_ns": time.Since(startTime)})
+			state, version, ok := fetchCellState(logger, guid, client, metricEmitter)
+			if !ok {
 				return
 			}
 
 			cell := NewCell(logger, guid, client, state)
+			cell.version = version
 
 			lock.Lock()
 			zones[state.Zone] = append(zones[state.Zone], cell)
 			lock.Unlock()
-			logger.Debug("fetched-cell-state", lager.Data{"cell-guid": guid, "duration_ns": time.Since(startTime)})
 		})
 	}
 
@@ -76,6 +63,42 @@ func fetchStateAndBuildZones(logger lager.Logger, workPool *workpool.WorkPool, c
 	return zones
 }
 
+// fetchCellState asks a cell for its state, along with the version of the state
+// when the client is a VersionedCellClient.  It reports false when the state
+// could not be fetched or the cell should not be auctioned on.
+func fetchCellState(logger lager.Logger, guid string, client rep.Client, metricEmitter auctiontypes.AuctionMetricEmitterDelegate) (rep.CellState, uint64, bool) {
+	startTime := time.Now()
+	state, version, err := cellState(logger, client)
+	if err != nil {
+		metricEmitter.FailedCellStateRequest()
+		logger.Error("failed-to-get-state", err, lager.Data{"cell-guid": guid, "duration_ns": time.Since(startTime)})
+		return rep.CellState{}, 0, false
+	}
+
+	if state.Evacuating {
+		logger.Info("ignored-evacuating-cell", lager.Data{"cell-guid": guid, "duration_ns": time.Since(startTime)})
+		return rep.CellState{}, 0, false
+	}
+
+	if state.CellID != "" && state.CellID != guid {
+		logger.Error("cell-id-mismatch", nil, lager.Data{"cell-guid": guid, "cell-state-guid": state.CellID, "duration_ns": time.Since(startTime)})
+		return rep.CellState{}, 0, false
+	}
+
+	logger.Debug("fetched-cell-state", lager.Data{"cell-guid": guid, "duration_ns": time.Since(startTime)})
+	return state, version, true
+}
+
+func cellState(logger lager.Logger, client rep.Client) (rep.CellState, uint64, error) {
+	versionedClient, ok := client.(auctiontypes.VersionedCellClient)
+	if !ok {
+		state, err := client.State(logger)
+		return state, 0, err
+	}
+
+	return versionedClient.VersionedState(logger)
+}
+
 func isBinPackFirstFitWeightProvided(binPackFirstFitWeight float64) bool {
 	return binPackFirstFitWeight > MinBinPackFirstFitWeight
 }
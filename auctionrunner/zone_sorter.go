@@ -7,7 +7,9 @@ import (
 )
 
 type lrpByZone struct {
+	name      string
 	zone      Zone
+	exhausted Zone
 	instances int
 }
 
@@ -21,50 +23,88 @@ func (s zoneSorterByInstances) Less(i, j int) bool {
 	return s.zones[i].instances < s.zones[j].instances
 }
 
-func accumulateZonesByInstances(zones map[string]Zone, processGuid string) []lrpByZone {
+func accumulateZonesByInstances(zones map[string]Zone, names []string, zoneInstances processInstanceIndex, processGuid string) []lrpByZone {
 	lrpZones := []lrpByZone{}
 
-	for _, zone := range zones {
-		instances := 0
-		for _, cell := range zone {
-			for i := range cell.state.LRPs {
-				if cell.state.LRPs[i].ProcessGuid == processGuid {
-					instances++
-				}
-			}
-		}
-		lrpZones = append(lrpZones, lrpByZone{zone, instances})
+	for _, name := range names {
+		lrpZones = append(lrpZones, lrpByZone{name: name, zone: zones[name], instances: zoneInstances[name][processGuid]})
 	}
 
 	return lrpZones
 }
 
+// processInstanceIndex counts the LRP instances of each process guid in each
+// zone, so that zones can be balanced without walking the LRPs of every cell.
+type processInstanceIndex map[string]map[string]int
+
+func newProcessInstanceIndex(zones map[string]Zone) processInstanceIndex {
+	index := processInstanceIndex{}
+	for name, zone := range zones {
+		index.addZone(name, zone)
+	}
+	return index
+}
+
+func (index processInstanceIndex) addZone(name string, zone Zone) {
+	for _, cell := range zone {
+		index.addCell(name, cell, 1)
+	}
+}
+
+// addCell adds the instances on the cell to the counts of the zone, or
+// removes them when sign is -1.
+func (index processInstanceIndex) addCell(name string, cell *Cell, sign int) {
+	counts, ok := index[name]
+	if !ok {
+		counts = map[string]int{}
+		index[name] = counts
+	}
+	for processGuid, instances := range cell.processInstances {
+		counts[processGuid] += sign * instances
+	}
+}
+
 func sortZonesByInstances(zones []lrpByZone) []lrpByZone {
 	sorter := zoneSorterByInstances{zones: zones}
 	sort.Sort(sorter)
 	return sorter.zones
 }
 
-func filterZones(zones []lrpByZone, lrpAuction *auctiontypes.LRPAuction) ([]lrpByZone, error) {
+// sortedZoneNames orders the zones by name so that ties between cells in
+// different zones are always broken the same way.
+func sortedZoneNames(zones map[string]Zone) []string {
+	names := make([]string, 0, len(zones))
+	for name := range zones {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func filterZones(filters filterCache, zones []lrpByZone, lrpAuction *auctiontypes.LRPAuction) ([]lrpByZone, error) {
 	filteredZones := []lrpByZone{}
 	var zoneError error
 
 	for _, lrpZone := range zones {
-		cells, err := lrpZone.zone.filterCells(lrpAuction.PlacementConstraint)
+		filtered := filters.filter(lrpZone.name, lrpZone.zone, lrpAuction.PlacementConstraint)
+		err := filtered.err
 		if err != nil {
 			_, isZoneErrorPlacementTagMismatchError := zoneError.(auctiontypes.PlacementTagMismatchError)
 			_, isErrPlacementTagMismatchError := err.(auctiontypes.PlacementTagMismatchError)
 
 			if isZoneErrorPlacementTagMismatchError ||
 				(zoneError == auctiontypes.ErrorVolumeDriverMismatch && isErrPlacementTagMismatchError) ||
-				zoneError == auctiontypes.ErrorCellMismatch || zoneError == nil {
+				zoneError == auctiontypes.ErrorCellMismatch || zoneError == nil ||
+				err == auctiontypes.ErrorExtendedResourceMismatch {
 				zoneError = err
 			}
 			continue
 		}
 
 		filteredZone := lrpByZone{
-			zone:      Zone(cells),
+			name:      lrpZone.name,
+			zone:      Zone(filtered.cells),
+			exhausted: Zone(filtered.exhausted),
 			instances: lrpZone.instances,
 		}
 		filteredZones = append(filteredZones, filteredZone)
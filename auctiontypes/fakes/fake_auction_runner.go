@@ -10,6 +10,62 @@ import (
 )
 
 type FakeAuctionRunner struct {
+	CancelLRPAuctionsStub        func(string, []int) []auctiontypes.LRPAuction
+	cancelLRPAuctionsMutex       sync.RWMutex
+	cancelLRPAuctionsArgsForCall []struct {
+		arg1 string
+		arg2 []int
+	}
+	cancelLRPAuctionsReturns struct {
+		result1 []auctiontypes.LRPAuction
+	}
+	cancelLRPAuctionsReturnsOnCall map[int]struct {
+		result1 []auctiontypes.LRPAuction
+	}
+	CancelTaskAuctionsStub        func([]string) []auctiontypes.TaskAuction
+	cancelTaskAuctionsMutex       sync.RWMutex
+	cancelTaskAuctionsArgsForCall []struct {
+		arg1 []string
+	}
+	cancelTaskAuctionsReturns struct {
+		result1 []auctiontypes.TaskAuction
+	}
+	cancelTaskAuctionsReturnsOnCall map[int]struct {
+		result1 []auctiontypes.TaskAuction
+	}
+	ExportPendingAuctionsStub        func() ([]byte, error)
+	exportPendingAuctionsMutex       sync.RWMutex
+	exportPendingAuctionsArgsForCall []struct {
+	}
+	exportPendingAuctionsReturns struct {
+		result1 []byte
+		result2 error
+	}
+	exportPendingAuctionsReturnsOnCall map[int]struct {
+		result1 []byte
+		result2 error
+	}
+	ImportPendingAuctionsStub        func([]byte) error
+	importPendingAuctionsMutex       sync.RWMutex
+	importPendingAuctionsArgsForCall []struct {
+		arg1 []byte
+	}
+	importPendingAuctionsReturns struct {
+		result1 error
+	}
+	importPendingAuctionsReturnsOnCall map[int]struct {
+		result1 error
+	}
+	PendingAuctionsStub        func() auctiontypes.AuctionRequest
+	pendingAuctionsMutex       sync.RWMutex
+	pendingAuctionsArgsForCall []struct {
+	}
+	pendingAuctionsReturns struct {
+		result1 auctiontypes.AuctionRequest
+	}
+	pendingAuctionsReturnsOnCall map[int]struct {
+		result1 auctiontypes.AuctionRequest
+	}
 	RunStub        func(<-chan os.Signal, chan<- struct{}) error
 	runMutex       sync.RWMutex
 	runArgsForCall []struct {
@@ -22,20 +78,340 @@ type FakeAuctionRunner struct {
 	runReturnsOnCall map[int]struct {
 		result1 error
 	}
-	ScheduleLRPsForAuctionsStub        func([]auctioneer.LRPStartRequest)
+	ScheduleLRPsForAuctionsStub        func([]auctioneer.LRPStartRequest) error
 	scheduleLRPsForAuctionsMutex       sync.RWMutex
 	scheduleLRPsForAuctionsArgsForCall []struct {
 		arg1 []auctioneer.LRPStartRequest
 	}
-	ScheduleTasksForAuctionsStub        func([]auctioneer.TaskStartRequest)
+	scheduleLRPsForAuctionsReturns struct {
+		result1 error
+	}
+	scheduleLRPsForAuctionsReturnsOnCall map[int]struct {
+		result1 error
+	}
+	ScheduleTasksForAuctionsStub        func([]auctioneer.TaskStartRequest) error
 	scheduleTasksForAuctionsMutex       sync.RWMutex
 	scheduleTasksForAuctionsArgsForCall []struct {
 		arg1 []auctioneer.TaskStartRequest
 	}
+	scheduleTasksForAuctionsReturns struct {
+		result1 error
+	}
+	scheduleTasksForAuctionsReturnsOnCall map[int]struct {
+		result1 error
+	}
 	invocations      map[string][][]interface{}
 	invocationsMutex sync.RWMutex
 }
 
+func (fake *FakeAuctionRunner) CancelLRPAuctions(arg1 string, arg2 []int) []auctiontypes.LRPAuction {
+	var arg2Copy []int
+	if arg2 != nil {
+		arg2Copy = make([]int, len(arg2))
+		copy(arg2Copy, arg2)
+	}
+	fake.cancelLRPAuctionsMutex.Lock()
+	ret, specificReturn := fake.cancelLRPAuctionsReturnsOnCall[len(fake.cancelLRPAuctionsArgsForCall)]
+	fake.cancelLRPAuctionsArgsForCall = append(fake.cancelLRPAuctionsArgsForCall, struct {
+		arg1 string
+		arg2 []int
+	}{arg1, arg2Copy})
+	fake.recordInvocation("CancelLRPAuctions", []interface{}{arg1, arg2Copy})
+	cancelLRPAuctionsStubCopy := fake.CancelLRPAuctionsStub
+	fake.cancelLRPAuctionsMutex.Unlock()
+	if cancelLRPAuctionsStubCopy != nil {
+		return cancelLRPAuctionsStubCopy(arg1, arg2)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	fakeReturns := fake.cancelLRPAuctionsReturns
+	return fakeReturns.result1
+}
+
+func (fake *FakeAuctionRunner) CancelLRPAuctionsCallCount() int {
+	fake.cancelLRPAuctionsMutex.RLock()
+	defer fake.cancelLRPAuctionsMutex.RUnlock()
+	return len(fake.cancelLRPAuctionsArgsForCall)
+}
+
+func (fake *FakeAuctionRunner) CancelLRPAuctionsCalls(stub func(string, []int) []auctiontypes.LRPAuction) {
+	fake.cancelLRPAuctionsMutex.Lock()
+	defer fake.cancelLRPAuctionsMutex.Unlock()
+	fake.CancelLRPAuctionsStub = stub
+}
+
+func (fake *FakeAuctionRunner) CancelLRPAuctionsArgsForCall(i int) (string, []int) {
+	fake.cancelLRPAuctionsMutex.RLock()
+	defer fake.cancelLRPAuctionsMutex.RUnlock()
+	argsForCall := fake.cancelLRPAuctionsArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2
+}
+
+func (fake *FakeAuctionRunner) CancelLRPAuctionsReturns(result1 []auctiontypes.LRPAuction) {
+	fake.cancelLRPAuctionsMutex.Lock()
+	defer fake.cancelLRPAuctionsMutex.Unlock()
+	fake.CancelLRPAuctionsStub = nil
+	fake.cancelLRPAuctionsReturns = struct {
+		result1 []auctiontypes.LRPAuction
+	}{result1}
+}
+
+func (fake *FakeAuctionRunner) CancelLRPAuctionsReturnsOnCall(i int, result1 []auctiontypes.LRPAuction) {
+	fake.cancelLRPAuctionsMutex.Lock()
+	defer fake.cancelLRPAuctionsMutex.Unlock()
+	fake.CancelLRPAuctionsStub = nil
+	if fake.cancelLRPAuctionsReturnsOnCall == nil {
+		fake.cancelLRPAuctionsReturnsOnCall = make(map[int]struct {
+			result1 []auctiontypes.LRPAuction
+		})
+	}
+	fake.cancelLRPAuctionsReturnsOnCall[i] = struct {
+		result1 []auctiontypes.LRPAuction
+	}{result1}
+}
+
+func (fake *FakeAuctionRunner) CancelTaskAuctions(arg1 []string) []auctiontypes.TaskAuction {
+	var arg1Copy []string
+	if arg1 != nil {
+		arg1Copy = make([]string, len(arg1))
+		copy(arg1Copy, arg1)
+	}
+	fake.cancelTaskAuctionsMutex.Lock()
+	ret, specificReturn := fake.cancelTaskAuctionsReturnsOnCall[len(fake.cancelTaskAuctionsArgsForCall)]
+	fake.cancelTaskAuctionsArgsForCall = append(fake.cancelTaskAuctionsArgsForCall, struct {
+		arg1 []string
+	}{arg1Copy})
+	fake.recordInvocation("CancelTaskAuctions", []interface{}{arg1Copy})
+	cancelTaskAuctionsStubCopy := fake.CancelTaskAuctionsStub
+	fake.cancelTaskAuctionsMutex.Unlock()
+	if cancelTaskAuctionsStubCopy != nil {
+		return cancelTaskAuctionsStubCopy(arg1)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	fakeReturns := fake.cancelTaskAuctionsReturns
+	return fakeReturns.result1
+}
+
+func (fake *FakeAuctionRunner) CancelTaskAuctionsCallCount() int {
+	fake.cancelTaskAuctionsMutex.RLock()
+	defer fake.cancelTaskAuctionsMutex.RUnlock()
+	return len(fake.cancelTaskAuctionsArgsForCall)
+}
+
+func (fake *FakeAuctionRunner) CancelTaskAuctionsCalls(stub func([]string) []auctiontypes.TaskAuction) {
+	fake.cancelTaskAuctionsMutex.Lock()
+	defer fake.cancelTaskAuctionsMutex.Unlock()
+	fake.CancelTaskAuctionsStub = stub
+}
+
+func (fake *FakeAuctionRunner) CancelTaskAuctionsArgsForCall(i int) []string {
+	fake.cancelTaskAuctionsMutex.RLock()
+	defer fake.cancelTaskAuctionsMutex.RUnlock()
+	argsForCall := fake.cancelTaskAuctionsArgsForCall[i]
+	return argsForCall.arg1
+}
+
+func (fake *FakeAuctionRunner) CancelTaskAuctionsReturns(result1 []auctiontypes.TaskAuction) {
+	fake.cancelTaskAuctionsMutex.Lock()
+	defer fake.cancelTaskAuctionsMutex.Unlock()
+	fake.CancelTaskAuctionsStub = nil
+	fake.cancelTaskAuctionsReturns = struct {
+		result1 []auctiontypes.TaskAuction
+	}{result1}
+}
+
+func (fake *FakeAuctionRunner) CancelTaskAuctionsReturnsOnCall(i int, result1 []auctiontypes.TaskAuction) {
+	fake.cancelTaskAuctionsMutex.Lock()
+	defer fake.cancelTaskAuctionsMutex.Unlock()
+	fake.CancelTaskAuctionsStub = nil
+	if fake.cancelTaskAuctionsReturnsOnCall == nil {
+		fake.cancelTaskAuctionsReturnsOnCall = make(map[int]struct {
+			result1 []auctiontypes.TaskAuction
+		})
+	}
+	fake.cancelTaskAuctionsReturnsOnCall[i] = struct {
+		result1 []auctiontypes.TaskAuction
+	}{result1}
+}
+
+func (fake *FakeAuctionRunner) ExportPendingAuctions() ([]byte, error) {
+	fake.exportPendingAuctionsMutex.Lock()
+	ret, specificReturn := fake.exportPendingAuctionsReturnsOnCall[len(fake.exportPendingAuctionsArgsForCall)]
+	fake.exportPendingAuctionsArgsForCall = append(fake.exportPendingAuctionsArgsForCall, struct {
+	}{})
+	fake.recordInvocation("ExportPendingAuctions", []interface{}{})
+	exportPendingAuctionsStubCopy := fake.ExportPendingAuctionsStub
+	fake.exportPendingAuctionsMutex.Unlock()
+	if exportPendingAuctionsStubCopy != nil {
+		return exportPendingAuctionsStubCopy()
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	fakeReturns := fake.exportPendingAuctionsReturns
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *FakeAuctionRunner) ExportPendingAuctionsCallCount() int {
+	fake.exportPendingAuctionsMutex.RLock()
+	defer fake.exportPendingAuctionsMutex.RUnlock()
+	return len(fake.exportPendingAuctionsArgsForCall)
+}
+
+func (fake *FakeAuctionRunner) ExportPendingAuctionsCalls(stub func() ([]byte, error)) {
+	fake.exportPendingAuctionsMutex.Lock()
+	defer fake.exportPendingAuctionsMutex.Unlock()
+	fake.ExportPendingAuctionsStub = stub
+}
+
+func (fake *FakeAuctionRunner) ExportPendingAuctionsReturns(result1 []byte, result2 error) {
+	fake.exportPendingAuctionsMutex.Lock()
+	defer fake.exportPendingAuctionsMutex.Unlock()
+	fake.ExportPendingAuctionsStub = nil
+	fake.exportPendingAuctionsReturns = struct {
+		result1 []byte
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeAuctionRunner) ExportPendingAuctionsReturnsOnCall(i int, result1 []byte, result2 error) {
+	fake.exportPendingAuctionsMutex.Lock()
+	defer fake.exportPendingAuctionsMutex.Unlock()
+	fake.ExportPendingAuctionsStub = nil
+	if fake.exportPendingAuctionsReturnsOnCall == nil {
+		fake.exportPendingAuctionsReturnsOnCall = make(map[int]struct {
+			result1 []byte
+			result2 error
+		})
+	}
+	fake.exportPendingAuctionsReturnsOnCall[i] = struct {
+		result1 []byte
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeAuctionRunner) ImportPendingAuctions(arg1 []byte) error {
+	var arg1Copy []byte
+	if arg1 != nil {
+		arg1Copy = make([]byte, len(arg1))
+		copy(arg1Copy, arg1)
+	}
+	fake.importPendingAuctionsMutex.Lock()
+	ret, specificReturn := fake.importPendingAuctionsReturnsOnCall[len(fake.importPendingAuctionsArgsForCall)]
+	fake.importPendingAuctionsArgsForCall = append(fake.importPendingAuctionsArgsForCall, struct {
+		arg1 []byte
+	}{arg1Copy})
+	fake.recordInvocation("ImportPendingAuctions", []interface{}{arg1Copy})
+	importPendingAuctionsStubCopy := fake.ImportPendingAuctionsStub
+	fake.importPendingAuctionsMutex.Unlock()
+	if importPendingAuctionsStubCopy != nil {
+		return importPendingAuctionsStubCopy(arg1)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	fakeReturns := fake.importPendingAuctionsReturns
+	return fakeReturns.result1
+}
+
+func (fake *FakeAuctionRunner) ImportPendingAuctionsCallCount() int {
+	fake.importPendingAuctionsMutex.RLock()
+	defer fake.importPendingAuctionsMutex.RUnlock()
+	return len(fake.importPendingAuctionsArgsForCall)
+}
+
+func (fake *FakeAuctionRunner) ImportPendingAuctionsCalls(stub func([]byte) error) {
+	fake.importPendingAuctionsMutex.Lock()
+	defer fake.importPendingAuctionsMutex.Unlock()
+	fake.ImportPendingAuctionsStub = stub
+}
+
+func (fake *FakeAuctionRunner) ImportPendingAuctionsArgsForCall(i int) []byte {
+	fake.importPendingAuctionsMutex.RLock()
+	defer fake.importPendingAuctionsMutex.RUnlock()
+	argsForCall := fake.importPendingAuctionsArgsForCall[i]
+	return argsForCall.arg1
+}
+
+func (fake *FakeAuctionRunner) ImportPendingAuctionsReturns(result1 error) {
+	fake.importPendingAuctionsMutex.Lock()
+	defer fake.importPendingAuctionsMutex.Unlock()
+	fake.ImportPendingAuctionsStub = nil
+	fake.importPendingAuctionsReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeAuctionRunner) ImportPendingAuctionsReturnsOnCall(i int, result1 error) {
+	fake.importPendingAuctionsMutex.Lock()
+	defer fake.importPendingAuctionsMutex.Unlock()
+	fake.ImportPendingAuctionsStub = nil
+	if fake.importPendingAuctionsReturnsOnCall == nil {
+		fake.importPendingAuctionsReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.importPendingAuctionsReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeAuctionRunner) PendingAuctions() auctiontypes.AuctionRequest {
+	fake.pendingAuctionsMutex.Lock()
+	ret, specificReturn := fake.pendingAuctionsReturnsOnCall[len(fake.pendingAuctionsArgsForCall)]
+	fake.pendingAuctionsArgsForCall = append(fake.pendingAuctionsArgsForCall, struct {
+	}{})
+	fake.recordInvocation("PendingAuctions", []interface{}{})
+	pendingAuctionsStubCopy := fake.PendingAuctionsStub
+	fake.pendingAuctionsMutex.Unlock()
+	if pendingAuctionsStubCopy != nil {
+		return pendingAuctionsStubCopy()
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	fakeReturns := fake.pendingAuctionsReturns
+	return fakeReturns.result1
+}
+
+func (fake *FakeAuctionRunner) PendingAuctionsCallCount() int {
+	fake.pendingAuctionsMutex.RLock()
+	defer fake.pendingAuctionsMutex.RUnlock()
+	return len(fake.pendingAuctionsArgsForCall)
+}
+
+func (fake *FakeAuctionRunner) PendingAuctionsCalls(stub func() auctiontypes.AuctionRequest) {
+	fake.pendingAuctionsMutex.Lock()
+	defer fake.pendingAuctionsMutex.Unlock()
+	fake.PendingAuctionsStub = stub
+}
+
+func (fake *FakeAuctionRunner) PendingAuctionsReturns(result1 auctiontypes.AuctionRequest) {
+	fake.pendingAuctionsMutex.Lock()
+	defer fake.pendingAuctionsMutex.Unlock()
+	fake.PendingAuctionsStub = nil
+	fake.pendingAuctionsReturns = struct {
+		result1 auctiontypes.AuctionRequest
+	}{result1}
+}
+
+func (fake *FakeAuctionRunner) PendingAuctionsReturnsOnCall(i int, result1 auctiontypes.AuctionRequest) {
+	fake.pendingAuctionsMutex.Lock()
+	defer fake.pendingAuctionsMutex.Unlock()
+	fake.PendingAuctionsStub = nil
+	if fake.pendingAuctionsReturnsOnCall == nil {
+		fake.pendingAuctionsReturnsOnCall = make(map[int]struct {
+			result1 auctiontypes.AuctionRequest
+		})
+	}
+	fake.pendingAuctionsReturnsOnCall[i] = struct {
+		result1 auctiontypes.AuctionRequest
+	}{result1}
+}
+
 func (fake *FakeAuctionRunner) Run(arg1 <-chan os.Signal, arg2 chan<- struct{}) error {
 	fake.runMutex.Lock()
 	ret, specificReturn := fake.runReturnsOnCall[len(fake.runArgsForCall)]
@@ -98,13 +474,14 @@ func (fake *FakeAuctionRunner) RunReturnsOnCall(i int, result1 error) {
 	}{result1}
 }
 
-func (fake *FakeAuctionRunner) ScheduleLRPsForAuctions(arg1 []auctioneer.LRPStartRequest) {
+func (fake *FakeAuctionRunner) ScheduleLRPsForAuctions(arg1 []auctioneer.LRPStartRequest) error {
 	var arg1Copy []auctioneer.LRPStartRequest
 	if arg1 != nil {
 		arg1Copy = make([]auctioneer.LRPStartRequest, len(arg1))
 		copy(arg1Copy, arg1)
 	}
 	fake.scheduleLRPsForAuctionsMutex.Lock()
+	ret, specificReturn := fake.scheduleLRPsForAuctionsReturnsOnCall[len(fake.scheduleLRPsForAuctionsArgsForCall)]
 	fake.scheduleLRPsForAuctionsArgsForCall = append(fake.scheduleLRPsForAuctionsArgsForCall, struct {
 		arg1 []auctioneer.LRPStartRequest
 	}{arg1Copy})
@@ -112,8 +489,13 @@ func (fake *FakeAuctionRunner) ScheduleLRPsForAuctions(arg1 []auctioneer.LRPStar
 	scheduleLRPsForAuctionsStubCopy := fake.ScheduleLRPsForAuctionsStub
 	fake.scheduleLRPsForAuctionsMutex.Unlock()
 	if scheduleLRPsForAuctionsStubCopy != nil {
-		scheduleLRPsForAuctionsStubCopy(arg1)
+		return scheduleLRPsForAuctionsStubCopy(arg1)
 	}
+	if specificReturn {
+		return ret.result1
+	}
+	fakeReturns := fake.scheduleLRPsForAuctionsReturns
+	return fakeReturns.result1
 }
 
 func (fake *FakeAuctionRunner) ScheduleLRPsForAuctionsCallCount() int {
@@ -122,7 +504,7 @@ func (fake *FakeAuctionRunner) ScheduleLRPsForAuctionsCallCount() int {
 	return len(fake.scheduleLRPsForAuctionsArgsForCall)
 }
 
-func (fake *FakeAuctionRunner) ScheduleLRPsForAuctionsCalls(stub func([]auctioneer.LRPStartRequest)) {
+func (fake *FakeAuctionRunner) ScheduleLRPsForAuctionsCalls(stub func([]auctioneer.LRPStartRequest) error) {
 	fake.scheduleLRPsForAuctionsMutex.Lock()
 	defer fake.scheduleLRPsForAuctionsMutex.Unlock()
 	fake.ScheduleLRPsForAuctionsStub = stub
@@ -135,13 +517,37 @@ func (fake *FakeAuctionRunner) ScheduleLRPsForAuctionsArgsForCall(i int) []aucti
 	return argsForCall.arg1
 }
 
-func (fake *FakeAuctionRunner) ScheduleTasksForAuctions(arg1 []auctioneer.TaskStartRequest) {
+func (fake *FakeAuctionRunner) ScheduleLRPsForAuctionsReturns(result1 error) {
+	fake.scheduleLRPsForAuctionsMutex.Lock()
+	defer fake.scheduleLRPsForAuctionsMutex.Unlock()
+	fake.ScheduleLRPsForAuctionsStub = nil
+	fake.scheduleLRPsForAuctionsReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeAuctionRunner) ScheduleLRPsForAuctionsReturnsOnCall(i int, result1 error) {
+	fake.scheduleLRPsForAuctionsMutex.Lock()
+	defer fake.scheduleLRPsForAuctionsMutex.Unlock()
+	fake.ScheduleLRPsForAuctionsStub = nil
+	if fake.scheduleLRPsForAuctionsReturnsOnCall == nil {
+		fake.scheduleLRPsForAuctionsReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.scheduleLRPsForAuctionsReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeAuctionRunner) ScheduleTasksForAuctions(arg1 []auctioneer.TaskStartRequest) error {
 	var arg1Copy []auctioneer.TaskStartRequest
 	if arg1 != nil {
 		arg1Copy = make([]auctioneer.TaskStartRequest, len(arg1))
 		copy(arg1Copy, arg1)
 	}
 	fake.scheduleTasksForAuctionsMutex.Lock()
+	ret, specificReturn := fake.scheduleTasksForAuctionsReturnsOnCall[len(fake.scheduleTasksForAuctionsArgsForCall)]
 	fake.scheduleTasksForAuctionsArgsForCall = append(fake.scheduleTasksForAuctionsArgsForCall, struct {
 		arg1 []auctioneer.TaskStartRequest
 	}{arg1Copy})
@@ -149,8 +555,13 @@ func (fake *FakeAuctionRunner) ScheduleTasksForAuctions(arg1 []auctioneer.TaskSt
 	scheduleTasksForAuctionsStubCopy := fake.ScheduleTasksForAuctionsStub
 	fake.scheduleTasksForAuctionsMutex.Unlock()
 	if scheduleTasksForAuctionsStubCopy != nil {
-		scheduleTasksForAuctionsStubCopy(arg1)
+		return scheduleTasksForAuctionsStubCopy(arg1)
+	}
+	if specificReturn {
+		return ret.result1
 	}
+	fakeReturns := fake.scheduleTasksForAuctionsReturns
+	return fakeReturns.result1
 }
 
 func (fake *FakeAuctionRunner) ScheduleTasksForAuctionsCallCount() int {
@@ -159,7 +570,7 @@ func (fake *FakeAuctionRunner) ScheduleTasksForAuctionsCallCount() int {
 	return len(fake.scheduleTasksForAuctionsArgsForCall)
 }
 
-func (fake *FakeAuctionRunner) ScheduleTasksForAuctionsCalls(stub func([]auctioneer.TaskStartRequest)) {
+func (fake *FakeAuctionRunner) ScheduleTasksForAuctionsCalls(stub func([]auctioneer.TaskStartRequest) error) {
 	fake.scheduleTasksForAuctionsMutex.Lock()
 	defer fake.scheduleTasksForAuctionsMutex.Unlock()
 	fake.ScheduleTasksForAuctionsStub = stub
@@ -172,9 +583,42 @@ func (fake *FakeAuctionRunner) ScheduleTasksForAuctionsArgsForCall(i int) []auct
 	return argsForCall.arg1
 }
 
+func (fake *FakeAuctionRunner) ScheduleTasksForAuctionsReturns(result1 error) {
+	fake.scheduleTasksForAuctionsMutex.Lock()
+	defer fake.scheduleTasksForAuctionsMutex.Unlock()
+	fake.ScheduleTasksForAuctionsStub = nil
+	fake.scheduleTasksForAuctionsReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeAuctionRunner) ScheduleTasksForAuctionsReturnsOnCall(i int, result1 error) {
+	fake.scheduleTasksForAuctionsMutex.Lock()
+	defer fake.scheduleTasksForAuctionsMutex.Unlock()
+	fake.ScheduleTasksForAuctionsStub = nil
+	if fake.scheduleTasksForAuctionsReturnsOnCall == nil {
+		fake.scheduleTasksForAuctionsReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.scheduleTasksForAuctionsReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
 func (fake *FakeAuctionRunner) Invocations() map[string][][]interface{} {
 	fake.invocationsMutex.RLock()
 	defer fake.invocationsMutex.RUnlock()
+	fake.cancelLRPAuctionsMutex.RLock()
+	defer fake.cancelLRPAuctionsMutex.RUnlock()
+	fake.cancelTaskAuctionsMutex.RLock()
+	defer fake.cancelTaskAuctionsMutex.RUnlock()
+	fake.exportPendingAuctionsMutex.RLock()
+	defer fake.exportPendingAuctionsMutex.RUnlock()
+	fake.importPendingAuctionsMutex.RLock()
+	defer fake.importPendingAuctionsMutex.RUnlock()
+	fake.pendingAuctionsMutex.RLock()
+	defer fake.pendingAuctionsMutex.RUnlock()
 	fake.runMutex.RLock()
 	defer fake.runMutex.RUnlock()
 	fake.scheduleLRPsForAuctionsMutex.RLock()
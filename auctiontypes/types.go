@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"code.cloudfoundry.org/auctioneer"
+	"code.cloudfoundry.org/lager"
 	"code.cloudfoundry.org/rep"
 	"github.com/tedsuo/ifrit"
 )
@@ -14,6 +15,8 @@ import (
 
 var ErrorCellMismatch = errors.New("found no compatible cell for required rootfs")
 var ErrorVolumeDriverMismatch = errors.New("found no compatible cell with required volume drivers")
+var ErrorExtendedResourceMismatch = errors.New("found no compatible cell with required extended resources")
+var ErrorTaskGroupMismatch = errors.New("found no compatible cell for every task in the group")
 
 type PlacementTagMismatchError struct {
 	tags []string
@@ -43,19 +46,92 @@ func (e PlacementTagMismatchError) Error() string {
 var ErrorNothingToStop = errors.New("nothing to stop")
 var ErrorCellCommunication = errors.New("unable to communicate to compatible cells")
 var ErrorExceededInflightCreation = errors.New("waiting to start instance: reached in-flight start limit")
+var ErrorBatchFull = errors.New("auction batch is full")
+var ErrorAuctionExpired = errors.New("auction expired: exceeded maximum time in queue")
+var ErrorShuttingDown = errors.New("auction runner is shutting down")
+var ErrorStaleCellState = errors.New("cell state changed since it was fetched")
 
 //go:generate counterfeiter -o fakes/fake_auction_runner.go . AuctionRunner
 type AuctionRunner interface {
 	ifrit.Runner
-	ScheduleLRPsForAuctions([]auctioneer.LRPStartRequest)
-	ScheduleTasksForAuctions([]auctioneer.TaskStartRequest)
+	ScheduleLRPsForAuctions([]auctioneer.LRPStartRequest) error
+	ScheduleTasksForAuctions([]auctioneer.TaskStartRequest) error
+	PendingAuctions() AuctionRequest
+	CancelLRPAuctions(processGuid string, indices []int) []LRPAuction
+	CancelTaskAuctions(taskGuids []string) []TaskAuction
+	ExportPendingAuctions() ([]byte, error)
+	ImportPendingAuctions([]byte) error
 }
 
+//go:generate counterfeiter -o fakes/fake_auction_runner_delegate.go . AuctionRunnerDelegate
 type AuctionRunnerDelegate interface {
 	FetchCellReps() (map[string]rep.Client, error)
 	AuctionCompleted(AuctionResults)
 }
 
+// VersionedCellClient is implemented by cell clients whose state carries a
+// version that advances whenever the cell changes.  Work performed against a
+// version the cell has moved past is rejected as a whole with
+// ErrorStaleCellState, so that it can be placed again using the new state.
+//
+//go:generate counterfeiter -o fakes/fake_versioned_cell_client.go . VersionedCellClient
+type VersionedCellClient interface {
+	rep.Client
+	VersionedState(logger lager.Logger) (rep.CellState, uint64, error)
+	PerformVersioned(logger lager.Logger, work rep.Work, version uint64) (rep.Work, uint64, error)
+}
+
+// ExtendedResources counts named resources other than memory, disk and
+// containers, such as licenses or FPGA slots.
+type ExtendedResources map[string]int32
+
+// ExtendedResourceRequests gives the extended resources each instance of work
+// with the placement constraint takes up.
+type ExtendedResourceRequests func(pc rep.PlacementConstraint) ExtendedResources
+
+// ExtendedResourcesByPlacementTag requests the extended resources of each
+// placement tag of the work, adding them up across its tags.
+func ExtendedResourcesByPlacementTag(requests map[string]ExtendedResources) ExtendedResourceRequests {
+	return func(pc rep.PlacementConstraint) ExtendedResources {
+		var total ExtendedResources
+		for _, tag := range pc.PlacementTags {
+			for name, amount := range requests[tag] {
+				if total == nil {
+					total = ExtendedResources{}
+				}
+				total[name] += amount
+			}
+		}
+		return total
+	}
+}
+
+// ContainerOverhead is what each container takes up on a cell on top of the
+// resources it asks for: a fixed cost for every container, and sidecars for
+// LRP containers, which also take up the proxy memory the cell reports.
+type ContainerOverhead struct {
+	MemoryMB int32
+	DiskMB   int32
+
+	SidecarMemoryMB int32
+	SidecarDiskMB   int32
+}
+
+// LRPResource is what an LRP container asking for res takes up on a cell that
+// allocates proxyMemoryAllocationMB to the proxy.
+func (o ContainerOverhead) LRPResource(res rep.Resource, proxyMemoryAllocationMB int) rep.Resource {
+	res.MemoryMB += o.MemoryMB + o.SidecarMemoryMB + int32(proxyMemoryAllocationMB)
+	res.DiskMB += o.DiskMB + o.SidecarDiskMB
+	return res
+}
+
+// TaskResource is what a task container asking for res takes up on a cell.
+func (o ContainerOverhead) TaskResource(res rep.Resource) rep.Resource {
+	res.MemoryMB += o.MemoryMB
+	res.DiskMB += o.DiskMB
+	return res
+}
+
 //go:generate counterfeiter -o fakes/fake_metric_emitter.go . AuctionMetricEmitterDelegate
 type AuctionMetricEmitterDelegate interface {
 	FetchStatesCompleted(time.Duration) error
@@ -85,6 +161,10 @@ type AuctionRecord struct {
 	WaitDuration time.Duration
 
 	PlacementError string
+
+	// Overcommitted is set when the winner only had room for the auction by
+	// overcommitting its resources.
+	Overcommitted bool
 }
 
 func NewAuctionRecord(now time.Time) AuctionRecord {
@@ -110,15 +190,24 @@ func (a *LRPAuction) Copy() LRPAuction {
 type TaskAuction struct {
 	rep.Task
 	AuctionRecord
+
+	// GroupingKey, such as a job id, has the tasks that share it balanced
+	// across zones.  Tasks without one are not.
+	GroupingKey string
+
+	// TaskGroup, such as a workflow id, has the task placed on the same cell
+	// as the other tasks of the auction that share it, or fail along with
+	// them.
+	TaskGroup string
 }
 
 func NewTaskAuction(task rep.Task, now time.Time) TaskAuction {
 	return TaskAuction{
-		task,
-		NewAuctionRecord(now),
+		Task:          task,
+		AuctionRecord: NewAuctionRecord(now),
 	}
 }
 
 func (a *TaskAuction) Copy() TaskAuction {
-	return TaskAuction{a.Task.Copy(), a.AuctionRecord}
+	return TaskAuction{a.Task.Copy(), a.AuctionRecord, a.GroupingKey, a.TaskGroup}
 }
@@ -2,6 +2,7 @@ package auctiontypes_test
 
 import (
 	"code.cloudfoundry.org/auction/auctiontypes"
+	"code.cloudfoundry.org/rep"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 )
@@ -72,4 +73,39 @@ var _ = Describe("Types", func() {
 			Expect(err.Error()).To(Equal("found no compatible cell for required rootfs"))
 		})
 	})
+
+	Describe("ErrorExtendedResourceMismatch", func() {
+		It("prints the proper error message", func() {
+			err := auctiontypes.ErrorExtendedResourceMismatch
+			Expect(err.Error()).To(Equal("found no compatible cell with required extended resources"))
+		})
+	})
+
+	Describe("ErrorTaskGroupMismatch", func() {
+		It("prints the proper error message", func() {
+			err := auctiontypes.ErrorTaskGroupMismatch
+			Expect(err.Error()).To(Equal("found no compatible cell for every task in the group"))
+		})
+	})
+
+	Describe("ExtendedResourcesByPlacementTag", func() {
+		var requests auctiontypes.ExtendedResourceRequests
+
+		BeforeEach(func() {
+			requests = auctiontypes.ExtendedResourcesByPlacementTag(map[string]auctiontypes.ExtendedResources{
+				"fpga":     {"fpga-slots": 1},
+				"licensed": {"licenses": 2, "fpga-slots": 1},
+			})
+		})
+
+		It("adds up the extended resources of the placement tags", func() {
+			pc := rep.NewPlacementConstraint("rootfs", []string{"fpga", "licensed", "other"}, nil)
+			Expect(requests(pc)).To(Equal(auctiontypes.ExtendedResources{"fpga-slots": 2, "licenses": 2}))
+		})
+
+		It("requests nothing for work without those tags", func() {
+			pc := rep.NewPlacementConstraint("rootfs", []string{"other"}, nil)
+			Expect(requests(pc)).To(BeEmpty())
+		})
+	})
 })
@@ -5,10 +5,12 @@ import (
 	"flag"
 	"fmt"
 	"io/ioutil"
+	"math/rand"
 	"os"
 	"os/exec"
 	"runtime"
 	"sync"
+	"time"
 
 	"code.cloudfoundry.org/clock"
 
@@ -54,6 +56,10 @@ var svgReport *visualization.SVGReport
 var reports []*visualization.Report
 var reportName string
 var disableSVGReport bool
+var sampleSize int
+var seed int64
+var optimizerBudget time.Duration
+var optimizerLookahead int
 
 var runnerProcess ifrit.Process
 var runnerDelegate *auctionRunnerDelegate
@@ -65,6 +71,10 @@ func init() {
 	flag.IntVar(&workers, "workers", 500, "number of concurrent communication worker pools")
 	flag.BoolVar(&disableSVGReport, "disableSVGReport", false, "disable displaying SVG reports of the simulation runs")
 	flag.StringVar(&reportName, "reportName", "report", "report name")
+	flag.IntVar(&sampleSize, "sampleSize", 0, "number of cells of each zone scored per auction, 0 scores every cell")
+	flag.Int64Var(&seed, "seed", 1, "seed for sampling cells")
+	flag.DurationVar(&optimizerBudget, "optimizerBudget", 0, "time allowed for planning each batch as a whole, 0 schedules greedily")
+	flag.IntVar(&optimizerLookahead, "optimizerLookahead", 2, "number of auctions the batch optimizer looks ahead")
 }
 
 func TestAuction(t *testing.T) {
@@ -103,6 +113,17 @@ var _ = BeforeEach(func() {
 
 	runnerDelegate = NewAuctionRunnerDelegate(cells)
 	metricEmitterDelegate := NewAuctionMetricEmitterDelegate()
+	options := []auctionrunner.Option{}
+	if sampleSize > 0 {
+		options = append(options, auctionrunner.WithSchedulerOptions(
+			auctionrunner.WithSampledScoring(sampleSize, rand.New(rand.NewSource(seed))),
+		))
+	}
+	if optimizerBudget > 0 {
+		options = append(options, auctionrunner.WithSchedulerOptions(
+			auctionrunner.WithBatchOptimizer(optimizerBudget, optimizerLookahead),
+		))
+	}
 	runner = auctionrunner.New(
 		logger,
 		runnerDelegate,
@@ -112,6 +133,7 @@ var _ = BeforeEach(func() {
 		0.0,
 		0.25,
 		defaultMaxContainerStartCount,
+		options...,
 	)
 	runnerProcess = ifrit.Invoke(runner)
 })
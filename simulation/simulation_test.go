@@ -3,10 +3,13 @@ package simulation_test
 import (
 	"fmt"
 	"math"
+	"os"
 	"sync"
 	"time"
 
 	"code.cloudfoundry.org/auction/auctionrunner"
+	"code.cloudfoundry.org/auction/auctiontypes"
+	"code.cloudfoundry.org/auction/simulation/simulationrep"
 	"code.cloudfoundry.org/auction/simulation/util"
 	"code.cloudfoundry.org/auction/simulation/visualization"
 	"code.cloudfoundry.org/auctioneer"
@@ -362,6 +365,146 @@ var _ = Describe("Auction", func() {
 			}
 		})
 
+		Context("Weighing resources", func() {
+			nCells := 10
+
+			newLRPWithDisk := func(memoryMB, diskMB int) rep.LRP {
+				lrpKey := models.NewActualLRPKey(util.NewGrayscaleGuid("CCC"), 0, "domain")
+				return rep.NewLRP("", lrpKey, rep.NewResource(int32(memoryMB), int32(diskMB), 10), rep.NewPlacementConstraint(linuxRootFSURL, []string{}, []string{}))
+			}
+
+			// replaces the runner with one that does not weigh in starting
+			// containers, so that only the resources in use set the cells apart
+			startRunner := func(options ...auctionrunner.SchedulerOption) {
+				runnerProcess.Signal(os.Interrupt)
+				Eventually(runnerProcess.Wait(), 20).Should(Receive())
+
+				runner = auctionrunner.New(
+					logger,
+					runnerDelegate,
+					NewAuctionMetricEmitterDelegate(),
+					clock.NewClock(),
+					workPool,
+					0.0,
+					0.0,
+					defaultMaxContainerStartCount,
+					auctionrunner.WithSchedulerOptions(options...),
+				)
+				runnerProcess = ifrit.Invoke(runner)
+			}
+
+			winnersOn := func(cellIndices ...int) int {
+				finalDistributions := getFinalDistributions()
+				winners := 0
+				for _, index := range cellIndices {
+					winners += int(finalDistributions[cellGuid(index)])
+				}
+				return winners
+			}
+
+			BeforeEach(func() {
+				// half the cells are low on disk and the other half low on memory
+				for i := 0; i < nCells; i++ {
+					if i < nCells/2 {
+						initialDistributions[i] = []rep.LRP{newLRPWithDisk(10, 20), newLRPWithDisk(10, 20), newLRPWithDisk(10, 20)}
+					} else {
+						initialDistributions[i] = []rep.LRP{newLRPWithDisk(20, 1), newLRPWithDisk(20, 1), newLRPWithDisk(20, 1)}
+					}
+				}
+			})
+
+			Context("with every resource weighed the same", func() {
+				JustBeforeEach(func() {
+					startRunner()
+				})
+
+				It("favors the cells with the least of all resources in use", func() {
+					instances := generateUniqueLRPStartAuctions(10, 1)
+					runAndReportStartAuction(instances, nCells, 0, 1)
+
+					Expect(winnersOn(5, 6, 7, 8, 9)).To(Equal(10))
+				})
+			})
+
+			Context("with only memory weighed", func() {
+				JustBeforeEach(func() {
+					startRunner(auctionrunner.WithResourceWeights(auctionrunner.ResourceWeights{MemoryMB: 1}))
+				})
+
+				It("favors the cells with the least memory in use", func() {
+					instances := generateUniqueLRPStartAuctions(10, 1)
+					runAndReportStartAuction(instances, nCells, 1, 1)
+
+					Expect(winnersOn(0, 1, 2, 3, 4)).To(Equal(10))
+				})
+			})
+
+			Context("with memory weighed more than disk", func() {
+				JustBeforeEach(func() {
+					startRunner(auctionrunner.WithResourceWeights(auctionrunner.ResourceWeights{MemoryMB: 3, DiskMB: 1, Containers: 1}))
+				})
+
+				It("still fills the cells with the least memory in use first", func() {
+					instances := generateUniqueLRPStartAuctions(10, 1)
+					runAndReportStartAuction(instances, nCells, 2, 1)
+
+					Expect(winnersOn(0, 1, 2, 3, 4)).To(Equal(10))
+				})
+			})
+		})
+
+		Context("Accounting for container overhead", func() {
+			nCells := 4
+			overhead := auctiontypes.ContainerOverhead{MemoryMB: 5}
+
+			// replaces the cells with ones that allocate memory to the proxy and
+			// to every container, and the runner with one that knows about it
+			JustBeforeEach(func() {
+				runnerProcess.Signal(os.Interrupt)
+				Eventually(runnerProcess.Wait(), 20).Should(Receive())
+
+				overheadCells := map[string]rep.SimClient{}
+				for i := 0; i < nCells; i++ {
+					guid := cellGuid(i)
+					overheadCells[guid] = simulationrep.New(guid, i, linuxStack, zone(i), repResources, defaultDrivers, simulationrep.WithContainerOverhead(10, overhead))
+				}
+				runnerDelegate = NewAuctionRunnerDelegate(overheadCells)
+
+				runner = auctionrunner.New(
+					logger,
+					runnerDelegate,
+					NewAuctionMetricEmitterDelegate(),
+					clock.NewClock(),
+					workPool,
+					0.0,
+					0.25,
+					defaultMaxContainerStartCount,
+					auctionrunner.WithSchedulerOptions(auctionrunner.WithContainerOverhead(func(rep.CellState) auctiontypes.ContainerOverhead {
+						return overhead
+					})),
+				)
+				runnerProcess = ifrit.Invoke(runner)
+			})
+
+			It("only places as many LRPs as the cells have room for, overhead included", func() {
+				instances := generateLRPStartAuctionsForProcessGuid(12, "overhead", 30)
+				runAndReportStartAuction(instances, nCells, 0, 2)
+
+				results := runnerDelegate.Results()
+				Expect(results.SuccessfulLRPs).To(HaveLen(8))
+				Expect(results.FailedLRPs).To(HaveLen(4))
+
+				cells, _ := runnerDelegate.FetchCellReps()
+				running := 0
+				for _, cell := range cells {
+					state, err := cell.State(logger)
+					Expect(err).NotTo(HaveOccurred())
+					running += len(state.LRPs)
+				}
+				Expect(running).To(Equal(8))
+			})
+		})
+
 		Context("Packing optimally when memory is low", func() {
 			nCells := 1
 
@@ -385,6 +528,13 @@ var _ = Describe("Auction", func() {
 					losers = append(losers, fmt.Sprintf("%s-%d", result.ProcessGuid, result.Index))
 				}
 
+				if optimizerBudget > 0 {
+					// the batch optimizer places as many auctions as fit, so every pebble is placed
+					Expect(winners).To(HaveLen(80))
+					Expect(losers).To(ConsistOf("red-0", "red-1"))
+					return
+				}
+
 				Expect(winners).To(HaveLen(51))
 				Expect(losers).To(HaveLen(31))
 
@@ -5,6 +5,7 @@ import (
 	"sync"
 	"time"
 
+	"code.cloudfoundry.org/auction/auctiontypes"
 	"code.cloudfoundry.org/bbs/models"
 	"code.cloudfoundry.org/lager"
 	"code.cloudfoundry.org/rep"
@@ -20,12 +21,41 @@ type SimulationRep struct {
 	tasks                  map[string]rep.Task
 	startingContainerCount int
 	volumeDrivers          []string
+	version                uint64
+
+	extendedResources auctiontypes.ExtendedResources
+	extendedRequests  auctiontypes.ExtendedResourceRequests
+
+	proxyMemoryAllocationMB int
+	overhead                auctiontypes.ContainerOverhead
 
 	lock *sync.Mutex
 }
 
-func New(cellID string, cellIndex int, stack string, zone string, totalResources rep.Resources, volumeDrivers []string) rep.SimClient {
-	return &SimulationRep{
+type Option func(*SimulationRep)
+
+// WithExtendedResources gives the cell the extended resources in
+// extendedResources, which the work it performs takes up as given by
+// requests.  Work it has too few extended resources left for fails.
+func WithExtendedResources(extendedResources auctiontypes.ExtendedResources, requests auctiontypes.ExtendedResourceRequests) Option {
+	return func(r *SimulationRep) {
+		r.extendedResources = extendedResources
+		r.extendedRequests = requests
+	}
+}
+
+// WithContainerOverhead has the containers of the cell take up overhead on
+// top of their own resources, and its LRP containers proxyMemoryAllocationMB
+// for the proxy, which the cell reports in its state.
+func WithContainerOverhead(proxyMemoryAllocationMB int, overhead auctiontypes.ContainerOverhead) Option {
+	return func(r *SimulationRep) {
+		r.proxyMemoryAllocationMB = proxyMemoryAllocationMB
+		r.overhead = overhead
+	}
+}
+
+func New(cellID string, cellIndex int, stack string, zone string, totalResources rep.Resources, volumeDrivers []string, options ...Option) rep.SimClient {
+	simulationRep := &SimulationRep{
 		cellID:                 cellID,
 		cellIndex:              cellIndex,
 		stack:                  stack,
@@ -38,12 +68,28 @@ func New(cellID string, cellIndex int, stack string, zone string, totalResources
 
 		lock: &sync.Mutex{},
 	}
+
+	for _, option := range options {
+		option(simulationRep)
+	}
+
+	return simulationRep
+}
+
+// ExtendedResources returns all the extended resources of the cell, whether in
+// use or not.
+func (r *SimulationRep) ExtendedResources() auctiontypes.ExtendedResources {
+	return r.extendedResources
 }
 
 func (r *SimulationRep) State(_ lager.Logger) (rep.CellState, error) {
 	r.lock.Lock()
 	defer r.lock.Unlock()
 
+	return r.state(), nil
+}
+
+func (r *SimulationRep) state() rep.CellState {
 	lrps := []rep.LRP{}
 	for _, lrp := range r.lrps {
 		lrps = append(lrps, lrp)
@@ -71,21 +117,30 @@ func (r *SimulationRep) State(_ lager.Logger) (rep.CellState, error) {
 		StartingContainerCount: r.startingContainerCount,
 		Zone:                   r.zone,
 		VolumeDrivers:          r.volumeDrivers,
-	}, nil
+
+		ProxyMemoryAllocationMB: r.proxyMemoryAllocationMB,
+	}
 }
 
 func (r *SimulationRep) Perform(_ lager.Logger, work rep.Work) (rep.Work, error) {
 	r.lock.Lock()
 	defer r.lock.Unlock()
 
+	return r.perform(work), nil
+}
+
+func (r *SimulationRep) perform(work rep.Work) rep.Work {
 	failedWork := rep.Work{}
 
 	availableResources := r.availableResources()
+	availableExtended := r.availableExtendedResources()
 
 	for _, start := range work.LRPs {
+		footprint := r.overhead.LRPResource(start.Resource, r.proxyMemoryAllocationMB)
 		hasRoom := availableResources.Containers >= 0
-		hasRoom = hasRoom && availableResources.MemoryMB >= start.MemoryMB
-		hasRoom = hasRoom && availableResources.DiskMB >= start.DiskMB
+		hasRoom = hasRoom && availableResources.MemoryMB >= footprint.MemoryMB
+		hasRoom = hasRoom && availableResources.DiskMB >= footprint.DiskMB
+		hasRoom = hasRoom && r.takeExtendedResources(availableExtended, start.PlacementConstraint)
 
 		if hasRoom {
 			r.lrps[start.Identifier()] = start
@@ -94,17 +149,19 @@ func (r *SimulationRep) Perform(_ lager.Logger, work rep.Work) (rep.Work, error)
 			if start.Domain == "auction" {
 				r.startingContainerCount++
 			}
-			availableResources.MemoryMB -= start.MemoryMB
-			availableResources.DiskMB -= start.DiskMB
+			availableResources.MemoryMB -= footprint.MemoryMB
+			availableResources.DiskMB -= footprint.DiskMB
 		} else {
 			failedWork.LRPs = append(failedWork.LRPs, start)
 		}
 	}
 
 	for _, task := range work.Tasks {
+		footprint := r.overhead.TaskResource(task.Resource)
 		hasRoom := availableResources.Containers >= 0
-		hasRoom = hasRoom && availableResources.MemoryMB >= task.MemoryMB
-		hasRoom = hasRoom && availableResources.DiskMB >= task.DiskMB
+		hasRoom = hasRoom && availableResources.MemoryMB >= footprint.MemoryMB
+		hasRoom = hasRoom && availableResources.DiskMB >= footprint.DiskMB
+		hasRoom = hasRoom && r.takeExtendedResources(availableExtended, task.PlacementConstraint)
 
 		if hasRoom {
 			r.tasks[task.TaskGuid] = task
@@ -113,14 +170,38 @@ func (r *SimulationRep) Perform(_ lager.Logger, work rep.Work) (rep.Work, error)
 			if task.Domain == "auction" {
 				r.startingContainerCount++
 			}
-			availableResources.MemoryMB -= task.MemoryMB
-			availableResources.DiskMB -= task.DiskMB
+			availableResources.MemoryMB -= footprint.MemoryMB
+			availableResources.DiskMB -= footprint.DiskMB
 		} else {
 			failedWork.Tasks = append(failedWork.Tasks, task)
 		}
 	}
 
-	return failedWork, nil
+	if len(failedWork.LRPs) < len(work.LRPs) || len(failedWork.Tasks) < len(work.Tasks) {
+		r.version++
+	}
+
+	return failedWork
+}
+
+func (r *SimulationRep) VersionedState(_ lager.Logger) (rep.CellState, uint64, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return r.state(), r.version, nil
+}
+
+// PerformVersioned rejects the work with ErrorStaleCellState unless version is
+// the current version of the cell state.
+func (r *SimulationRep) PerformVersioned(_ lager.Logger, work rep.Work, version uint64) (rep.Work, uint64, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if version != r.version {
+		return rep.Work{}, r.version, auctiontypes.ErrorStaleCellState
+	}
+
+	return r.perform(work), r.version, nil
 }
 
 //simulation only
@@ -132,6 +213,7 @@ func (r *SimulationRep) Reset() error {
 	r.lrps = map[string]rep.LRP{}
 	r.tasks = map[string]rep.Task{}
 	r.startingContainerCount = 0
+	r.version++
 	return nil
 }
 
@@ -158,14 +240,49 @@ func (rep *SimulationRep) StateClientTimeout() time.Duration {
 func (rep *SimulationRep) availableResources() rep.Resources {
 	resources := rep.totalResources
 	for _, lrp := range rep.lrps {
-		resources.MemoryMB -= lrp.MemoryMB
-		resources.DiskMB -= lrp.DiskMB
+		footprint := rep.overhead.LRPResource(lrp.Resource, rep.proxyMemoryAllocationMB)
+		resources.MemoryMB -= footprint.MemoryMB
+		resources.DiskMB -= footprint.DiskMB
 		resources.Containers -= 1
 	}
 	for _, task := range rep.tasks {
-		resources.MemoryMB -= task.MemoryMB
-		resources.DiskMB -= task.DiskMB
+		footprint := rep.overhead.TaskResource(task.Resource)
+		resources.MemoryMB -= footprint.MemoryMB
+		resources.DiskMB -= footprint.DiskMB
 		resources.Containers -= 1
 	}
 	return resources
 }
+
+func (r *SimulationRep) availableExtendedResources() auctiontypes.ExtendedResources {
+	available := auctiontypes.ExtendedResources{}
+	for name, amount := range r.extendedResources {
+		available[name] = amount
+	}
+	for _, lrp := range r.lrps {
+		r.takeExtendedResources(available, lrp.PlacementConstraint)
+	}
+	for _, task := range r.tasks {
+		r.takeExtendedResources(available, task.PlacementConstraint)
+	}
+	return available
+}
+
+// takeExtendedResources takes the extended resources of work with the
+// placement constraint out of available, unless there are too few left.
+func (r *SimulationRep) takeExtendedResources(available auctiontypes.ExtendedResources, pc rep.PlacementConstraint) bool {
+	if r.extendedRequests == nil {
+		return true
+	}
+
+	requests := r.extendedRequests(pc)
+	for name, amount := range requests {
+		if available[name] < amount {
+			return false
+		}
+	}
+	for name, amount := range requests {
+		available[name] -= amount
+	}
+	return true
+}